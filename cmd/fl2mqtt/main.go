@@ -1,22 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 )
 
 import (
-	"aplog"
-	"bbl"
-	"bltlog"
 	"bltmqtt"
 	"geo"
+	_ "logreaders"
 	"ltmgen"
 	"options"
-	"otx"
 	"types"
 )
 
@@ -37,24 +36,10 @@ func main() {
 
 	geo.Frobnicate_init()
 
-	var lfr types.FlightLog
 	for _, fn := range files {
-		ftype := types.EvinceFileType(fn)
-		switch ftype {
-		case types.IS_OTX:
-			l := otx.NewOTXReader(fn)
-			lfr = &l
-		case types.IS_BBL:
-			l := bbl.NewBBLReader(fn)
-			lfr = &l
-		case types.IS_BLT:
-			l := bltlog.NewBLTReader(fn)
-			lfr = &l
-		case types.IS_AP:
-			l := aplog.NewAPReader(fn)
-			lfr = &l
-		default:
-			log.Fatal("Unknown log format")
+		lfr, err := types.NewFlightLog(fn)
+		if err != nil {
+			log.Fatalf("%s: %+v\n", app, err)
 		}
 
 		metas, err := lfr.GetMetas()
@@ -81,14 +66,17 @@ func main() {
 
 						switch {
 						case strings.HasPrefix(app, "fl2mqtt"):
-							ls, res := lfr.Reader(metas[options.Config.Idx-1], nil)
-							if res {
+							ls, err := lfr.Reader(metas[options.Config.Idx-1], nil)
+							if err == nil {
 								bltmqtt.MQTTGen(ls, metas[options.Config.Idx-1])
+							} else {
+								fmt.Fprintf(os.Stderr, "%s: %v\n", app, err)
 							}
 						case strings.HasPrefix(app, "fl2ltm"):
-							ch := make(chan interface{})
-							go lfr.Reader(metas[options.Config.Idx-1], ch)
-							ltmgen.LTMGen(ch, metas[options.Config.Idx-1])
+							ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+							ch := types.Stream(ctx, lfr, metas[options.Config.Idx-1])
+							ltmgen.LTMGen(ctx, ch, metas[options.Config.Idx-1])
+							stop()
 						}
 						//						fmt.Println()
 					} else {
@@ -97,7 +85,7 @@ func main() {
 				}
 			}
 		} else {
-			log.Fatalf("fl2mqtt: %+v\n", err)
+			log.Fatalf("%s: %+v\n", app, err)
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,8 +10,8 @@ import (
 )
 
 import (
-	"bbl"
 	"geo"
+	_ "logreaders"
 	"options"
 	"sitlgen"
 	"types"
@@ -35,20 +36,15 @@ func main() {
 		}
 	}
 	geo.Frobnicate_init()
-	var lfr types.FlightLog
 	for _, fn := range files {
-		ftype := types.EvinceFileType(fn)
-		switch ftype {
-		case types.IS_BBL:
-			l := bbl.NewBBLReader(fn)
-			lfr = &l
-		default:
-			log.Fatal("Unknown log format")
+		lfr, err := types.NewFlightLog(fn)
+		if err != nil {
+			log.Fatalf("%s: %+v\n", app, err)
 		}
 
 		metas, err := lfr.GetMetas()
 		if err == nil {
-			if metas[0].Acc1G == 0 {
+			if lfr.LogType() == 'B' && metas[0].Acc1G == 0 {
 				// Old file, refresh the cache
 				currentTime := time.Now().Local()
 				err = os.Chtimes(fn, currentTime, currentTime)
@@ -77,9 +73,10 @@ func main() {
 							fmt.Println("Warning  : Log entry may be corrupt")
 						}
 						stl := sitlgen.NewSITL()
-						ch := make(chan interface{})
-						go lfr.Reader(metas[options.Config.Idx-1], ch)
-						stl.Run(ch, metas[options.Config.Idx-1])
+						ctx, cancel := context.WithCancel(context.Background())
+						ch := types.Stream(ctx, lfr, metas[options.Config.Idx-1])
+						stl.Run(ctx, ch, metas[options.Config.Idx-1])
+						cancel()
 					} else {
 						fmt.Println("Log: Not valid")
 					}
@@ -1,22 +1,26 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/yookoala/realpath"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 import (
-	"aplog"
-	"bbl"
-	"bltlog"
+	"analysis"
 	"geo"
 	"kmlgen"
+	_ "logreaders"
+	"mission"
 	"options"
-	"otx"
+	"trackgen"
 	"types"
 )
 
@@ -34,36 +38,75 @@ func main() {
 	if len(files) == 0 {
 		if len(options.Config.Mission) > 0 {
 			outms := kmlgen.GenKmlName(options.Config.Mission, options.Config.MissionIndex)
-			kmlgen.GenerateMissionOnly(outms, GetVersion)
-			show_output(outms)
+			if err := kmlgen.GenerateMissionOnly(outms, GetVersion); err != nil {
+				log.Fatalf("fl2x: %v\n", err)
+			}
+			show_output(os.Stdout, outms)
 		} else if len(options.Config.Cli) > 0 {
 			outms := kmlgen.GenKmlName(options.Config.Cli, 0)
-			kmlgen.GenerateCliOnly(outms, GetVersion)
-			show_output(outms)
+			if err := kmlgen.GenerateCliOnly(outms, GetVersion); err != nil {
+				log.Fatalf("fl2x: %v\n", err)
+			}
+			show_output(os.Stdout, outms)
 		} else {
 			options.Usage()
 		}
 		os.Exit(1)
 	}
 
-	var lfr types.FlightLog
-	for _, fn := range files {
-		ftype := types.EvinceFileType(fn)
-		switch ftype {
-		case types.IS_OTX:
-			l := otx.NewOTXReader(fn)
-			lfr = &l
-		case types.IS_BBL:
-			l := bbl.NewBBLReader(fn)
-			lfr = &l
-		case types.IS_BLT:
-			l := bltlog.NewBLTReader(fn)
-			lfr = &l
-		case types.IS_AP:
-			l := aplog.NewAPReader(fn)
-			lfr = &l
+	switch options.Config.Format {
+	case "kml", "kmz", "gpx", "igc", "csv", "geojson", "czml":
+	default:
+		fmt.Fprintf(os.Stderr, "fl2x: unknown output format \"%s\"\n", options.Config.Format)
+		os.Exit(1)
+	}
+
+	switch options.Config.SummaryFormat {
+	case "", "text", "json", "csv":
+	default:
+		fmt.Fprintf(os.Stderr, "fl2x: unknown summary format \"%s\"\n", options.Config.SummaryFormat)
+		os.Exit(1)
+	}
+
+	if options.Config.Merge {
+		switch options.Config.Format {
+		case "kml", "kmz":
 		default:
-			log.Fatalf("%s: unknown log format\n", fn)
+			fmt.Fprintf(os.Stderr, "fl2x: -merge requires KML/Z output\n")
+			os.Exit(1)
+		}
+	}
+
+	if options.Config.Vibration && options.Config.Merge {
+		fmt.Fprintf(os.Stderr, "fl2x: -vibration can't be used with -merge\n")
+		os.Exit(1)
+	}
+
+	switch options.Config.Model {
+	case "", "auto", "fw", "mr":
+	default:
+		fmt.Fprintf(os.Stderr, "fl2x: unknown model type \"%s\"\n", options.Config.Model)
+		os.Exit(1)
+	}
+
+	if err := kmlgen.ValidateGradient(options.Config.Gradset); err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := kmlgen.ValidateLayers(options.Config.Layers); err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		os.Exit(1)
+	}
+
+	var jobs []fl2xjob
+	nerr := 0
+	for _, fn := range files {
+		lfr, err := types.NewFlightLog(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+			nerr++
+			continue
 		}
 
 		metas, err := lfr.GetMetas()
@@ -72,56 +115,395 @@ func main() {
 				lfr.Dump()
 				os.Exit(0)
 			}
-			options.Config.Tmpdir, err = ioutil.TempDir("", ".fl2x")
-			if err != nil {
-				log.Fatalf("fl2x: %+v\n", err)
-			}
-			defer os.RemoveAll(options.Config.Tmpdir)
-
 			for _, b := range metas {
-				outfn := ""
 				if (options.Config.Idx == 0 || options.Config.Idx == b.Index) && b.Flags&types.Is_Valid != 0 {
-					for k, v := range b.Summary() {
-						fmt.Printf("%-8.8s : %s\n", k, v)
-					}
-					ls, res := lfr.Reader(b, nil)
-					if res {
-						if dump_log {
-							for _, b := range ls.L.Items {
-								fmt.Fprintf(os.Stderr, "%+v\n", b)
-							}
-						} else if options.Config.Summary == false {
-							outfn = kmlgen.GenKmlName(b.Logname, b.Index)
-							kmlgen.GenerateKML(ls.H, ls.L, outfn, b, ls.M, GetVersion)
-						}
-					}
-					for k, v := range ls.M {
-						fmt.Printf("%-8.8s : %s\n", k, v)
-					}
-					if s, ok := b.ShowDisarm(); ok {
-						fmt.Printf("%-8.8s : %s\n", "Disarm", s)
-					}
-					if !res {
-						fmt.Fprintf(os.Stderr, "*** skipping KML/Z for log  with no valid geospatial data\n")
-					} else {
-						show_output(outfn)
-					}
-					fmt.Println()
+					jobs = append(jobs, fl2xjob{fn: fn, meta: b, seq: len(jobs)})
 				}
 			}
 		} else {
-			log.Fatalf("fl2x: %+v\n", err)
+			fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+			nerr++
 		}
 	}
+
+	njobs := options.Config.Jobs
+	if njobs < 1 {
+		njobs = 1
+	}
+
+	// merged flights and summaries, in job order
+	flights := make([]*kmlgen.Flight, len(jobs))
+	sums := make([]*types.FlightSummary, len(jobs))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	jch := make(chan fl2xjob)
+	for i := 0; i < njobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jch {
+				var sb strings.Builder
+				res := run_job(j, dump_log, &sb)
+				flights[j.seq] = res.flight
+				sums[j.seq] = res.summary
+				mu.Lock()
+				os.Stdout.WriteString(sb.String())
+				if !res.ok {
+					nerr++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		jch <- j
+	}
+	close(jch)
+	wg.Wait()
+
+	structured := is_structured(options.Config.SummaryFormat)
+	if options.Config.Merge && !dump_log && !options.Config.Summary {
+		w := io.Writer(os.Stdout)
+		if structured {
+			w = io.Discard
+		}
+		if !generate_merged(files[0], flights, w) {
+			nerr++
+		}
+	}
+
+	if structured && !dump_log {
+		var sl []types.FlightSummary
+		for _, s := range sums {
+			if s != nil {
+				sl = append(sl, *s)
+			}
+		}
+		if err := trackgen.WriteSummaries(os.Stdout, options.Config.SummaryFormat, sl); err != nil {
+			fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+			nerr++
+		}
+	}
+
+	if nerr > 0 {
+		os.Exit(1)
+	}
+}
+
+func generate(cfg *options.Configuration, fl *kmlgen.Flight, outfn string) error {
+	ls, b := fl.Seg, fl.Meta
+	switch cfg.Format {
+	case "gpx":
+		return trackgen.GenerateGPX(outfn, ls, b, GetVersion)
+	case "igc":
+		return trackgen.GenerateIGC(outfn, ls, b, GetVersion)
+	case "csv":
+		return trackgen.GenerateCSV(outfn, ls, b, GetVersion)
+	case "geojson":
+		return trackgen.GenerateGeoJSON(outfn, ls, b, GetVersion)
+	case "czml":
+		return kmlgen.GenerateCZML(cfg, ls.H, ls.L, ls.R, outfn, b, ls.M, GetVersion)
+	default:
+		return kmlgen.GenerateKML(cfg, fl, outfn, GetVersion)
+	}
+}
+
+type fl2xjob struct {
+	fn   string
+	meta types.FlightMeta
+	seq  int
+}
+
+type fl2xresult struct {
+	ok      bool
+	flight  *kmlgen.Flight
+	summary *types.FlightSummary
+}
+
+// JSON and CSV summaries replace the text output
+func is_structured(sfmt string) bool {
+	return sfmt == "json" || sfmt == "csv"
+}
+
+// Each job has its own reader, configuration copy and temporary
+// directory, so jobs share no mutable state. Output is buffered and
+// written by the caller so that concurrent jobs don't interleave. For
+// merged output, the flight is returned rather than generated.
+func run_job(j fl2xjob, dump_log bool, w io.Writer) fl2xresult {
+	cfg := options.Config
+	lfr, err := types.NewFlightLog(j.fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		return fl2xresult{}
+	}
+	if is_structured(cfg.SummaryFormat) {
+		w = io.Discard
+	}
+	if c, ok := lfr.(options.Configurable); ok {
+		c.SetConfig(&cfg)
+	}
+	cfg.Tmpdir, err = ioutil.TempDir("", ".fl2x")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		return fl2xresult{}
+	}
+	defer os.RemoveAll(cfg.Tmpdir)
+
+	b := j.meta
+	outfn := ""
+	var fl *kmlgen.Flight
+	var evs []types.FlightEvent
+	for k, v := range b.Summary() {
+		fmt.Fprintf(w, "%-8.8s : %s\n", k, v)
+	}
+	ls, err := lfr.Reader(b, nil)
+	// The analyses need a log that was read, but not a GPS fix
+	usable := err == nil || errors.Is(err, types.ErrNoGPSFix)
+	var ests []analysis.WindEstimate
+	var bs analysis.BatteryStats
+	var lr analysis.LinkReport
+	var ms *mission.Mission
+	var mr analysis.MissionReport
+	var gr analysis.GeozoneReport
+	var tr analysis.TerrainReport
+	bok, lok, mok, gok, tok := false, false, false, false, false
+	if usable {
+		ests = analysis.EstimateWind(ls.L, b)
+		bs, bok = analysis.AnalyseBattery(ls.L, cfg.LowCell)
+		lr, lok = analysis.AnalyseLink(ls.L)
+		if len(cfg.Mission) > 0 {
+			ms = kmlgen.AdherenceMission(&cfg, ls.R)
+			mr, mok = analysis.AnalyseMission(ms, ls.L, ls.H, cfg.Intvl)
+		}
+		gr, gok = analysis.CheckGeozones(kmlgen.LoadGeozones(&cfg, ls.R), ls.L)
+	}
+	if cfg.Vibration && !dump_log && usable {
+		// The IMU data doesn't need a GPS fix
+		outfn = kmlgen.GenOutName(b.Logname, b.Index, "vibration.txt")
+		err = generate_vibration(lfr, b, outfn, w)
+	} else if err == nil {
+		if dump_log {
+			for _, b := range ls.L.Items {
+				fmt.Fprintf(os.Stderr, "%+v\n", b)
+			}
+		} else {
+			if cfg.Agl && !analysis.ComputeAGL(&ls.L, ls.H) {
+				fmt.Fprintf(os.Stderr, "fl2x: no DEM data, terrain clearance not available\n")
+			}
+			tr, tok = analysis.TerrainClearance(ls.L, cfg.AglMin)
+			evs = types.DetectEvents(ls.L, cfg.LowCell)
+			if evs == nil {
+				// detected, but none (vice null in the summary)
+				evs = []types.FlightEvent{}
+			}
+			f := &kmlgen.Flight{Meta: b, Seg: ls, Events: evs, Wind: ests}
+			if bok {
+				f.Battery = &bs
+			}
+			if mok {
+				f.Mission, f.Adherence = ms, mr
+			}
+			if gok {
+				f.Geozones = &gr
+			}
+			if tok {
+				f.Terrain = &tr
+			}
+			if cfg.Events {
+				err = trackgen.GenerateEvents(kmlgen.GenOutName(b.Logname, b.Index, "events.json"), evs, b, GetVersion)
+			}
+			if err == nil && cfg.Link {
+				if lok {
+					err = generate_link(lr, ls, b, w)
+				} else {
+					fmt.Fprintf(w, "%-8.8s : no RSSI, link analysis skipped\n", "Link")
+				}
+			}
+			if err == nil && mok {
+				err = generate_mission(mr, b, w)
+			}
+			if err == nil && cfg.Merge {
+				fl = f
+			} else if err == nil && cfg.Summary == false {
+				outfn = kmlgen.GenOutName(b.Logname, b.Index, cfg.Format)
+				err = generate(&cfg, f, outfn)
+			}
+		}
+	}
+	for k, v := range ls.M {
+		fmt.Fprintf(w, "%-8.8s : %s\n", k, v)
+	}
+	if s, ok := b.ShowDisarm(); ok {
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Disarm", s)
+	}
+	wspd, wdir, wok := analysis.MeanWind(ests)
+	if wok {
+		fmt.Fprintf(w, "%-8.8s : %.1f m/s from %.0f°\n", "Wind", wspd, wdir)
+	}
+	if bok {
+		bm := bs.Summary()
+		for _, k := range []string{"Battery", "Landing"} {
+			fmt.Fprintf(w, "%-8.8s : %s\n", k, bm[k])
+		}
+	}
+	if lok {
+		s := fmt.Sprintf("RSSI %.0f%% at %.0f m", lr.ByRange[len(lr.ByRange)-1].Rssi, lr.MaxRange)
+		if lr.PredRange > 0 {
+			s += fmt.Sprintf(", predicted range %.0f m", lr.PredRange)
+		}
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Link", s)
+	}
+	if mok {
+		s := fmt.Sprintf("%d of %d legs reached, XTE mean %.1f m, max %.1f m", mr.Reached, len(mr.Legs), mr.MeanXTE, mr.MaxXTE)
+		if len(mr.Missed) > 0 {
+			s += fmt.Sprintf(", %d WP missed", len(mr.Missed))
+		}
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Mission", s)
+	}
+	if tok {
+		fmt.Fprintf(w, "%-8.8s : minimum %.0f m at %s\n", "Terrain", tr.Min, analysis.ShowTime(tr.MinTime))
+		for _, l := range tr.Low {
+			fmt.Fprintf(w, "%-8.8s : below %.0f m %s - %s, minimum %.0f m\n", "Terrain", cfg.AglMin,
+				analysis.ShowTime(l.Start), analysis.ShowTime(l.End), l.Min)
+		}
+	}
+	if gok {
+		for _, z := range gr.Intervals {
+			fmt.Fprintf(w, "%-8.8s : %s\n", "Geozone", z.Text())
+		}
+	}
+	for _, e := range evs {
+		fmt.Fprintf(w, "%-8.8s : %s %s\n", "Event", e.ShowTime(), e.Text)
+	}
+	res := true
+	if errors.Is(err, types.ErrNoGPSFix) {
+		fmt.Fprintf(os.Stderr, "*** skipping KML/Z for log  with no valid geospatial data\n")
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		res = false
+	} else {
+		show_output(w, outfn)
+	}
+	fmt.Fprintln(w)
+	if !res {
+		fl = nil
+	}
+	sum := types.NewFlightSummary(b, ls)
+	sum.Output = outfn
+	sum.Events = evs
+	if wok {
+		sum.WindSpeed, sum.WindDir = &wspd, &wdir
+	}
+	if lok && lr.PredRange > 0 {
+		sum.PredRange = &lr.PredRange
+	}
+	if tok {
+		nlow := len(tr.Low)
+		sum.MinAgl, sum.MinAglTime, sum.LowAgl = &tr.Min, &tr.MinTime, &nlow
+	}
+	if gok {
+		sum.ZoneViolations = &gr.Violations
+	}
+	if mok {
+		nmissed := len(mr.Missed)
+		sum.WPReached, sum.WPMissed = &mr.Reached, &nmissed
+		sum.MeanXTE, sum.MaxXTE = &mr.MeanXTE, &mr.MaxXTE
+	}
+	if bok {
+		sum.Cells = &bs.Cells
+		sum.StartVolts, sum.EndVolts, sum.EndSoc = &bs.Start, &bs.End, &bs.EndSoc
+		// the sag needs the internal resistance, which needs current
+		if bs.IR > 0 {
+			sum.BatteryIR, sum.MaxSag, sum.MeanSag = &bs.IR, &bs.MaxSag, &bs.MeanSag
+		}
+		if bs.Capacity > 0 {
+			sum.Capacity, sum.Remaining = &bs.Capacity, &bs.Remaining
+		}
+		sum.BatteryCurve = bs.Curve
+	}
+	return fl2xresult{ok: res, flight: fl, summary: &sum}
+}
+
+// Link quality report and coverage plot
+func generate_link(lr analysis.LinkReport, ls types.LogSegment, b types.FlightMeta, w io.Writer) error {
+	outfn := kmlgen.GenOutName(b.Logname, b.Index, "link.txt")
+	err := trackgen.GenerateLink(outfn, lr, ls.L, b, GetVersion)
+	if err == nil {
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Report", outfn)
+	}
+	return err
+}
+
+// Mission adherence report, against the -mission
+func generate_mission(mr analysis.MissionReport, b types.FlightMeta, w io.Writer) error {
+	outfn := kmlgen.GenOutName(b.Logname, b.Index, "mission.txt")
+	err := trackgen.GenerateMission(outfn, mr, b, GetVersion)
+	if err == nil {
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Report", outfn)
+	}
+	return err
+}
+
+// Vibration analysis of the full rate IMU data (BBL only)
+func generate_vibration(lfr types.FlightLog, b types.FlightMeta, outfn string, w io.Writer) error {
+	ir, ok := lfr.(types.IMUReader)
+	if !ok {
+		return fmt.Errorf("%s: vibration analysis requires a Blackbox log", b.LogName())
+	}
+	samples, err := ir.IMUSamples(b)
+	if err != nil {
+		return err
+	}
+	vr, err := analysis.AnalyseVibration(samples)
+	if err != nil {
+		return fmt.Errorf("%s: %w", b.LogName(), err)
+	}
+	var sb []string
+	for a, n := range analysis.GyroAxes {
+		sb = append(sb, fmt.Sprintf("%s %.1f", n, vr.GyroRMS[a]))
+	}
+	fmt.Fprintf(w, "%-8.8s : gyro RMS %s deg/s\n", "Vibe", strings.Join(sb, ", "))
+	sb = sb[:0]
+	for a, n := range analysis.GyroAxes {
+		sb = append(sb, fmt.Sprintf("%s %.0f Hz", n, vr.GyroPeak[a].Freq))
+	}
+	fmt.Fprintf(w, "%-8.8s : gyro peak %s\n", "Vibe", strings.Join(sb, ", "))
+	return trackgen.GenerateVibration(outfn, vr, b, GetVersion)
+}
+
+// One KML/Z for all the flights, named for the first log file
+func generate_merged(fn string, flights []*kmlgen.Flight, w io.Writer) bool {
+	var fls []kmlgen.Flight
+	for _, f := range flights {
+		if f != nil {
+			fls = append(fls, *f)
+		}
+	}
+	if len(fls) == 0 {
+		return false
+	}
+	cfg := options.Config
+	outfn := kmlgen.GenOutName(fn, 0, "merged."+cfg.Format)
+	if err := kmlgen.GenerateMergedKML(&cfg, fls, outfn, GetVersion); err != nil {
+		fmt.Fprintf(os.Stderr, "fl2x: %v\n", err)
+		return false
+	}
+	fmt.Fprintln(w, "Merged")
+	for k, v := range kmlgen.MergeSummary(&cfg, fls) {
+		fmt.Fprintf(w, "%-8.8s : %s\n", k, v)
+	}
+	show_output(w, outfn)
+	return true
 }
 
-func show_output(outfn string) {
+func show_output(w io.Writer, outfn string) {
 	if outfn != "" {
 		rp, err := realpath.Realpath(outfn)
 		if err != nil || rp == "" {
-			fmt.Printf("%-8.8s : <%s> <%s>\n", "RealPath", rp, err)
+			fmt.Fprintf(w, "%-8.8s : <%s> <%s>\n", "RealPath", rp, err)
 			rp = outfn
 		}
-		fmt.Printf("%-8.8s : %s\n", "Output", rp)
+		fmt.Fprintf(w, "%-8.8s : %s\n", "Output", rp)
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -8,13 +9,10 @@ import (
 )
 
 import (
-	"aplog"
-	"bbl"
-	"bltlog"
 	"geo"
 	ltom "log2mission"
+	_ "logreaders"
 	"options"
-	"otx"
 	"types"
 )
 
@@ -33,24 +31,10 @@ func main() {
 	}
 
 	geo.Frobnicate_init()
-	var lfr types.FlightLog
 	for _, fn := range files {
-		ftype := types.EvinceFileType(fn)
-		switch ftype {
-		case types.IS_OTX:
-			l := otx.NewOTXReader(fn)
-			lfr = &l
-		case types.IS_BBL:
-			l := bbl.NewBBLReader(fn)
-			lfr = &l
-		case types.IS_BLT:
-			l := bltlog.NewBLTReader(fn)
-			lfr = &l
-		case types.IS_AP:
-			l := aplog.NewAPReader(fn)
-			lfr = &l
-		default:
-			log.Fatal("Unknown log format")
+		lfr, err := types.NewFlightLog(fn)
+		if err != nil {
+			log.Fatalf("log2mission: %+v\n", err)
 		}
 		metas, err := lfr.GetMetas()
 		if err == nil {
@@ -65,20 +49,24 @@ func main() {
 					if metas[options.Config.Idx-1].Flags&types.Is_Suspect != 0 {
 						fmt.Println("Warning  : Log entry may be corrupt")
 					}
-					ls, res := lfr.Reader(metas[options.Config.Idx-1], nil)
-					if res {
+					ls, err := lfr.Reader(metas[options.Config.Idx-1], nil)
+					if err == nil {
 						for k, v := range ls.M {
 							fmt.Printf("%-8.8s : %s\n", k, v)
 						}
 						ltom.Generate_mission(ls, metas[options.Config.Idx-1])
-					} else {
+					} else if errors.Is(err, types.ErrNoGPSFix) {
 						fmt.Fprintf(os.Stderr, "*** skipping generation for log  with no valid geospatial data\n")
+					} else {
+						fmt.Fprintf(os.Stderr, "log2mission: %v\n", err)
 					}
 					fmt.Println()
 				} else {
 					fmt.Println("Not valid")
 				}
 			}
+		} else {
+			fmt.Fprintf(os.Stderr, "log2mission: %v\n", err)
 		}
 	}
 }
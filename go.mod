@@ -6,9 +6,11 @@ require (
 	github.com/mazznoer/colorgrad v0.9.1
 	github.com/twpayne/go-kml v1.5.2
 	github.com/yookoala/realpath v1.0.0
+	golang.org/x/image v0.18.0
 )
 
 require (
+	analysis v1.0.0
 	aplog v1.0.0
 	bbl v1.0.0
 	bltlog v1.0.0
@@ -16,11 +18,15 @@ require (
 	geo v1.0.0
 	kmlgen v1.0.0
 	log2mission v1.0.0
+	logreaders v1.0.0
 	ltmgen v1.0.0
 	mission v1.0.0
+	mwplog v1.0.0
 	options v1.0.0
 	otx v1.0.0
+	plot v1.0.0
 	sitlgen v1.0.0
+	trackgen v1.0.0
 	types v1.0.0
 )
 
@@ -64,10 +70,20 @@ replace types v1.0.0 => ./pkg/types
 
 replace aplog v1.0.0 => ./pkg/aplog
 
+replace mwplog v1.0.0 => ./pkg/mwplog
+
+replace logreaders v1.0.0 => ./pkg/logreaders
+
 replace ltmgen v1.0.0 => ./pkg/ltmgen
 
 replace kmlgen v1.0.0 => ./pkg/kmlgen
 
+replace trackgen v1.0.0 => ./pkg/trackgen
+
+replace analysis v1.0.0 => ./pkg/analysis
+
+replace plot v1.0.0 => ./pkg/plot
+
 replace sitlgen v1.0.0 => ./pkg/sitlgen
 
 replace styles v1.0.0 => ./pkg/styles
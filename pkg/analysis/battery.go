@@ -0,0 +1,233 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+import (
+	"types"
+)
+
+/*
+ * Battery analysis. The pack is modelled as an open circuit (resting)
+ * voltage Voc in series with an internal resistance R, so under load
+ *
+ *   V = Voc - R.I
+ *
+ * R is the median of least squares fits of V against I over short
+ * windows (over which Voc is taken as constant) with sufficient current
+ * variation. The resting voltage is then V + R.I, and the sag R.I. The
+ * state of charge is from a typical LiPo resting voltage curve, so the
+ * capacity estimates are not valid for other chemistries (e.g. Li-ion).
+ */
+
+const (
+	BATT_WINDOW   = 10   // s
+	BATT_STEP     = 5    // s
+	BATT_MIN_DI   = 1.0  // A, current standard deviation in a window
+	BATT_MAX_R    = 1.0  // ohm, pack
+	BATT_LOAD     = 1.0  // A, loaded
+	BATT_CURVE    = 30   // s, resting curve interval
+	BATT_MIN_DSOC = 10.0 // %, for a capacity estimate
+	BATT_LOW_CELL = 3.3  // V, default usable limit
+)
+
+// Typical LiPo resting cell voltage, 0% to 100% SoC in 5% steps
+var soc_curve = []float64{3.27, 3.61, 3.69, 3.71, 3.73, 3.75, 3.77, 3.79, 3.80, 3.82, 3.84,
+	3.85, 3.87, 3.91, 3.95, 3.98, 4.02, 4.08, 4.11, 4.15, 4.20}
+
+type BatteryStats struct {
+	Cells     int
+	IR        float64 // ohm, pack; 0 if not estimated
+	MaxSag    float64 // V/cell
+	MeanSag   float64 // V/cell, while loaded
+	Start     float64 // resting V/cell
+	End       float64 // resting V/cell, at landing
+	StartSoc  float64 // %
+	EndSoc    float64 // %
+	Used      float64 // mAh, 0 if unknown
+	Capacity  float64 // mAh, estimated; 0 if unknown
+	Remaining float64 // mAh, estimated usable (to the low cell voltage) at landing
+	Curve     []types.CellPoint
+}
+
+// State of charge (%) for a resting cell voltage
+func CellSoc(v float64) float64 {
+	if v <= soc_curve[0] {
+		return 0
+	}
+	for j := 1; j < len(soc_curve); j++ {
+		if v < soc_curve[j] {
+			f := (v - soc_curve[j-1]) / (soc_curve[j] - soc_curve[j-1])
+			return 5 * (float64(j-1) + f)
+		}
+	}
+	return 100
+}
+
+func median(vals []float64) float64 {
+	sort.Float64s(vals)
+	n := len(vals)
+	if n%2 == 1 {
+		return vals[n/2]
+	}
+	return (vals[n/2-1] + vals[n/2]) / 2
+}
+
+// Pack resistance, the median of the window fits
+func internal_resistance(items []types.LogItem) float64 {
+	var rs []float64
+	t0 := items[0].Stamp
+	tend := items[len(items)-1].Stamp
+	j0 := 0
+	for ts := t0; ts <= tend; ts += BATT_STEP * 1000000 {
+		for j0 < len(items) && items[j0].Stamp < ts {
+			j0++
+		}
+		var n, si, sv, sii, siv float64
+		for j := j0; j < len(items) && items[j].Stamp < ts+BATT_WINDOW*1000000; j++ {
+			b := items[j]
+			if b.Volts <= 0 {
+				continue
+			}
+			n++
+			si += b.Amps
+			sv += b.Volts
+			sii += b.Amps * b.Amps
+			siv += b.Amps * b.Volts
+		}
+		if n < 3 {
+			continue
+		}
+		vari := sii/n - (si/n)*(si/n)
+		if vari < BATT_MIN_DI*BATT_MIN_DI {
+			continue
+		}
+		r := -(siv/n - (si/n)*(sv/n)) / vari
+		if r > 0 && r < BATT_MAX_R {
+			rs = append(rs, r)
+		}
+	}
+	if len(rs) == 0 {
+		return 0
+	}
+	return median(rs)
+}
+
+// Mean resting voltage (V/cell) over the items in [t0, t1)
+func rest_volts(items []types.LogItem, r float64, ncells int, t0, t1 uint64) float64 {
+	var n, sv float64
+	for _, b := range items {
+		if b.Stamp >= t0 && b.Stamp < t1 && b.Volts > 0 {
+			n++
+			sv += b.Volts + r*b.Amps
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sv / n / float64(ncells)
+}
+
+// Analyses the battery voltage and current. lowcell is the (loaded)
+// per cell voltage regarded as empty; 0 (which disables the low voltage
+// event) uses BATT_LOW_CELL for the usable capacity.
+func AnalyseBattery(rec types.LogRec, lowcell float64) (BatteryStats, bool) {
+	var bs BatteryStats
+	items := rec.Items
+	if len(items) == 0 || rec.Cap&types.CAP_VOLTS == 0 {
+		return bs, false
+	}
+	bs.Cells = types.PackCells(items)
+	if bs.Cells == 0 {
+		return bs, false
+	}
+	nc := float64(bs.Cells)
+	if rec.Cap&types.CAP_AMPS != 0 {
+		bs.IR = internal_resistance(items)
+	}
+
+	// Sag
+	var nl float64
+	for _, b := range items {
+		if b.Volts > 0 && b.Amps > BATT_LOAD {
+			sag := bs.IR * b.Amps / nc
+			bs.MaxSag = math.Max(bs.MaxSag, sag)
+			bs.MeanSag += sag
+			nl++
+		}
+	}
+	if nl > 0 {
+		bs.MeanSag /= nl
+	}
+
+	// Resting voltage curve
+	t0 := items[0].Stamp
+	tend := items[len(items)-1].Stamp
+	for ts := t0; ts < tend; ts += BATT_CURVE * 1000000 {
+		v := rest_volts(items, bs.IR, bs.Cells, ts, ts+BATT_CURVE*1000000)
+		if v == 0 {
+			continue
+		}
+		p := types.CellPoint{Time: float64(ts-t0) / 1e6, Volts: v}
+		if rec.Cap&types.CAP_ENERGY != 0 {
+			for _, b := range items {
+				if b.Stamp >= ts+BATT_CURVE*1000000/2 {
+					break
+				}
+				p.Used = b.Energy
+			}
+		}
+		bs.Curve = append(bs.Curve, p)
+	}
+
+	// The maximum resting voltage at the start (i.e. before any voltage
+	// drop from discharge); the voltage at the end of the log
+	for _, b := range items {
+		if b.Stamp-t0 > types.CELL_REST_TIME {
+			break
+		}
+		if v := (b.Volts + bs.IR*b.Amps) / nc; v > bs.Start {
+			bs.Start = v
+		}
+	}
+	te := t0
+	if tend-t0 > types.CELL_REST_TIME {
+		te = tend - types.CELL_REST_TIME
+	}
+	bs.End = rest_volts(items, bs.IR, bs.Cells, te, tend+1)
+	bs.StartSoc = CellSoc(bs.Start)
+	bs.EndSoc = CellSoc(bs.End)
+
+	if rec.Cap&types.CAP_ENERGY != 0 {
+		bs.Used = items[len(items)-1].Energy
+		if dsoc := bs.StartSoc - bs.EndSoc; dsoc > BATT_MIN_DSOC && bs.Used > 0 {
+			bs.Capacity = 100 * bs.Used / dsoc
+			if lowcell <= 0 {
+				lowcell = BATT_LOW_CELL
+			}
+			// lowcell is a loaded voltage; the usable limit allows for
+			// the mean sag
+			usable := bs.EndSoc - CellSoc(lowcell+bs.MeanSag)
+			bs.Remaining = math.Max(0, bs.Capacity*usable/100)
+		}
+	}
+	return bs, true
+}
+
+func (bs *BatteryStats) Summary() types.MapRec {
+	m := make(types.MapRec)
+	bat := fmt.Sprintf("%dS, %.2f V/cell", bs.Cells, bs.Start)
+	if bs.IR > 0 {
+		bat += fmt.Sprintf(", IR %.0f mΩ, sag %.2f V/cell (max %.2f)", bs.IR*1000, bs.MeanSag, bs.MaxSag)
+	}
+	m["Battery"] = bat
+	land := fmt.Sprintf("%.2f V/cell, %.0f%%", bs.End, bs.EndSoc)
+	if bs.Capacity > 0 {
+		land += fmt.Sprintf(", %.0f mAh usable (capacity %.0f mAh)", bs.Remaining, bs.Capacity)
+	}
+	m["Landing"] = land
+	return m
+}
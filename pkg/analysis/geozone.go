@@ -0,0 +1,165 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+)
+
+import (
+	"cli"
+	"geo"
+	"types"
+)
+
+/*
+ * Geozone checks. Each position (with a GPS fix) is tested against each
+ * zone; a zone is occupied when inside its boundary (circle or polygon)
+ * and altitude band (relative to home, cm; a maximum of 0 is unlimited).
+ * Occupying an exclusive zone is a violation, as is (where there are
+ * inclusive zones) occupying none of the inclusive zones, the action being
+ * that of the inclusive zone last occupied (or the first defined).
+ */
+
+const (
+	ZONE_OUTSIDE_INC = -1 // Zid of an excursion from the inclusive zones
+)
+
+type ZoneInterval struct {
+	Zid         int // or ZONE_OUTSIDE_INC
+	Type        string
+	Action      string
+	Start, End  float64 // s, from the start of the log; End is the first time outside
+	First, Last int     // LogRec item indices
+	Violation   bool
+}
+
+type GeozoneReport struct {
+	Intervals  []ZoneInterval // in order of entry
+	Violations int
+}
+
+func (z *ZoneInterval) Text() string {
+	var s string
+	if z.Zid == ZONE_OUTSIDE_INC {
+		s = fmt.Sprintf("outside inclusive zones (%s)", z.Action)
+	} else {
+		s = fmt.Sprintf("zone %d (%s, %s)", z.Zid, z.Type, z.Action)
+	}
+	s += fmt.Sprintf(" %s - %s", ShowTime(z.Start), ShowTime(z.End))
+	if z.Violation {
+		s += ", violation"
+	}
+	return s
+}
+
+// Formats a time (s) from the start of the log as mm:ss
+func ShowTime(t float64) string {
+	secs := int(math.Round(t))
+	return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+}
+
+// Ray casting, in (lon, lat)
+func in_polygon(pts []cli.Point, lat, lon float64) bool {
+	in := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		if (pts[i].Lat > lat) != (pts[j].Lat > lat) &&
+			lon < (pts[j].Lon-pts[i].Lon)*(lat-pts[i].Lat)/(pts[j].Lat-pts[i].Lat)+pts[i].Lon {
+			in = !in
+		}
+	}
+	return in
+}
+
+func in_zone(g cli.GeoZone, b types.LogItem) bool {
+	alt := b.Alt * 100
+	if alt < float64(g.Minalt) || (g.Maxalt != 0 && alt > float64(g.Maxalt)) {
+		return false
+	}
+	switch g.Shape {
+	case cli.SHAPE_CIRCLE:
+		if len(g.Points) < 2 {
+			return false
+		}
+		_, d := geo.Csedist(g.Points[0].Lat, g.Points[0].Lon, b.Lat, b.Lon)
+		return d*1852 <= g.Points[1].Lat
+	case cli.SHAPE_POLY:
+		return len(g.Points) > 2 && in_polygon(g.Points, b.Lat, b.Lon)
+	}
+	return false
+}
+
+func CheckGeozones(zones []cli.GeoZone, rec types.LogRec) (GeozoneReport, bool) {
+	var gr GeozoneReport
+	if len(zones) == 0 || len(rec.Items) == 0 {
+		return gr, false
+	}
+	open := make([]int, len(zones)) // interval index, or -1
+	for k := range open {
+		open[k] = -1
+	}
+	inc := -1
+	for k := range zones {
+		if zones[k].Gtype == cli.TYPE_INC {
+			inc = k
+			break
+		}
+	}
+	lastinc := inc
+	excursion := -1
+
+	stamp := func(j int) float64 {
+		return float64(rec.Items[j].Stamp-rec.Items[0].Stamp) / 1e6
+	}
+	closeiv := func(iv int, j int) {
+		gr.Intervals[iv].Last = j
+		gr.Intervals[iv].End = stamp(j)
+	}
+	openiv := func(z ZoneInterval, j int) int {
+		z.First, z.Start = j, stamp(j)
+		gr.Intervals = append(gr.Intervals, z)
+		if z.Violation {
+			gr.Violations++
+		}
+		return len(gr.Intervals) - 1
+	}
+
+	for j, b := range rec.Items {
+		if b.Fix < 2 {
+			continue
+		}
+		ininc := false
+		for k, g := range zones {
+			in := in_zone(g, b)
+			if in && open[k] == -1 {
+				open[k] = openiv(ZoneInterval{Zid: g.Zid, Type: g.TypeName(), Action: g.ActionName(),
+					Violation: g.Gtype == cli.TYPE_EXC}, j)
+			} else if !in && open[k] != -1 {
+				closeiv(open[k], j)
+				open[k] = -1
+			}
+			if in && g.Gtype == cli.TYPE_INC {
+				ininc = true
+				lastinc = k
+			}
+		}
+		if inc != -1 {
+			if !ininc && excursion == -1 {
+				excursion = openiv(ZoneInterval{Zid: ZONE_OUTSIDE_INC, Type: "inclusive",
+					Action: zones[lastinc].ActionName(), Violation: true}, j)
+			} else if ininc && excursion != -1 {
+				closeiv(excursion, j)
+				excursion = -1
+			}
+		}
+	}
+	last := len(rec.Items) - 1
+	for _, iv := range open {
+		if iv != -1 {
+			closeiv(iv, last)
+		}
+	}
+	if excursion != -1 {
+		closeiv(excursion, last)
+	}
+	return gr, true
+}
@@ -0,0 +1,111 @@
+package analysis
+
+import (
+	"testing"
+)
+
+import (
+	"cli"
+	"types"
+)
+
+// An L shape, the notch being the upper right quarter of the unit square
+var lshape = []cli.Point{{Lat: 0, Lon: 0}, {Lat: 1, Lon: 0}, {Lat: 1, Lon: 0.5},
+	{Lat: 0.5, Lon: 0.5}, {Lat: 0.5, Lon: 1}, {Lat: 0, Lon: 1}}
+
+var polygon_tests = []struct {
+	lat, lon float64
+	want     bool
+}{
+	{0.25, 0.25, true},
+	{0.75, 0.25, true},
+	{0.25, 0.75, true},
+	{0.75, 0.75, false}, // in the notch
+	{1.5, 0.25, false},
+	{-0.1, 0.25, false},
+	{0.25, -0.1, false},
+	{0.25, 1.1, false},
+	{0.5, 0.25, true}, // level with vertices
+	{0.5, 1.5, false},
+}
+
+func TestInPolygon(t *testing.T) {
+	for _, pt := range polygon_tests {
+		if in := in_polygon(lshape, pt.lat, pt.lon); in != pt.want {
+			t.Errorf("%.2f %.2f: %v, want %v", pt.lat, pt.lon, in, pt.want)
+		}
+	}
+}
+
+// West to east along the equator, 0.0005° (~56 m) a second, at alt m
+func zone_track(alt float64) types.LogRec {
+	var rec types.LogRec
+	for j := 0; j <= 8; j++ {
+		rec.Items = append(rec.Items, types.LogItem{Stamp: uint64(j) * 1000000, Fix: 3,
+			Lat: 0, Lon: -0.002 + float64(j)*0.0005, Alt: alt})
+	}
+	return rec
+}
+
+// A 100 m radius circle at 0,0
+func circle_zone(zid, gtype, minalt, maxalt int) cli.GeoZone {
+	return cli.GeoZone{Zid: zid, Shape: cli.SHAPE_CIRCLE, Gtype: gtype, Minalt: minalt, Maxalt: maxalt,
+		Action: cli.ACTION_RTH, Points: []cli.Point{{Lat: 0, Lon: 0}, {Lat: 100, Lon: 0}}}
+}
+
+// Square, from lon -0.0012 to 0.0012
+var inc_square = cli.GeoZone{Zid: 2, Shape: cli.SHAPE_POLY, Gtype: cli.TYPE_INC, Action: cli.ACTION_AVOID,
+	Points: []cli.Point{{Lat: -0.001, Lon: -0.0012}, {Lat: 0.001, Lon: -0.0012},
+		{Lat: 0.001, Lon: 0.0012}, {Lat: -0.001, Lon: 0.0012}}}
+
+type zone_iv struct {
+	zid         int
+	first, last int
+	violation   bool
+}
+
+var geozone_tests = []struct {
+	name       string
+	zones      []cli.GeoZone
+	alt        float64
+	intervals  []zone_iv
+	violations int
+}{
+	{"exclusive circle", []cli.GeoZone{circle_zone(1, cli.TYPE_EXC, 0, 0)}, 50,
+		[]zone_iv{{1, 3, 6, true}}, 1},
+	{"exclusive, above", []cli.GeoZone{circle_zone(1, cli.TYPE_EXC, 0, 4000)}, 50,
+		nil, 0},
+	{"exclusive, below", []cli.GeoZone{circle_zone(1, cli.TYPE_EXC, 6000, 0)}, 50,
+		nil, 0},
+	{"inclusive circle", []cli.GeoZone{circle_zone(1, cli.TYPE_INC, 0, 0)}, 50,
+		[]zone_iv{{ZONE_OUTSIDE_INC, 0, 3, true}, {1, 3, 6, false}, {ZONE_OUTSIDE_INC, 6, 8, true}}, 2},
+	{"inclusive square, exclusive circle", []cli.GeoZone{inc_square, circle_zone(1, cli.TYPE_EXC, 0, 0)}, 50,
+		[]zone_iv{{ZONE_OUTSIDE_INC, 0, 2, true}, {2, 2, 7, false}, {1, 3, 6, true},
+			{ZONE_OUTSIDE_INC, 7, 8, true}}, 3},
+}
+
+func TestCheckGeozones(t *testing.T) {
+	for _, gt := range geozone_tests {
+		gr, ok := CheckGeozones(gt.zones, zone_track(gt.alt))
+		if !ok {
+			t.Errorf("%s: not checked", gt.name)
+			continue
+		}
+		if gr.Violations != gt.violations {
+			t.Errorf("%s: %d violations, want %d", gt.name, gr.Violations, gt.violations)
+		}
+		if len(gr.Intervals) != len(gt.intervals) {
+			t.Errorf("%s: %d intervals, want %d", gt.name, len(gr.Intervals), len(gt.intervals))
+			continue
+		}
+		for j, w := range gt.intervals {
+			z := gr.Intervals[j]
+			if z.Zid != w.zid || z.First != w.first || z.Last != w.last || z.Violation != w.violation {
+				t.Errorf("%s: interval %d %+v, want %+v", gt.name, j, z, w)
+			}
+		}
+	}
+	if _, ok := CheckGeozones(nil, zone_track(50)); ok {
+		t.Errorf("checked, with no zones")
+	}
+}
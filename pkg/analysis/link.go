@@ -0,0 +1,179 @@
+package analysis
+
+import (
+	"math"
+)
+
+import (
+	"types"
+)
+
+/*
+ * Link analysis. RSSI (and LQ, where logged) are binned by range from
+ * home, by bearing from home and by altitude. The maximum range is
+ * predicted from a fit of RSSI against log10(range) (i.e. a log distance
+ * path loss model), extrapolated to LINK_RSSI_LIMIT.
+ */
+
+const (
+	LINK_SECTORS    = 16   // bearing bins
+	LINK_RANGE_BINS = 10   // approximate
+	LINK_ALT_BINS   = 8    // approximate
+	LINK_MIN_RANGE  = 50.0 // m, for the fit
+	LINK_MIN_RATIO  = 2.0  // max/min fitted range
+	LINK_MIN_N      = 10   // fitted items
+	LINK_RSSI_LIMIT = 10.0 // %, predicted range
+	LINK_MAX_PRED   = 20.0 // maximum prediction, multiple of the observed range
+)
+
+type LinkBin struct {
+	Lo, Hi   float64
+	N        int
+	Rssi     float64 // mean %
+	RssiMin  float64
+	LQ       float64 // mean %, where CAP_LQ
+	LQMin    float64
+	MaxRange float64 // m
+}
+
+type LinkReport struct {
+	HasLQ     bool
+	MaxRange  float64 // m, observed
+	ByRange   []LinkBin
+	ByBearing []LinkBin
+	ByAlt     []LinkBin
+	// RSSI = A + B.log10(range), for range >= LINK_MIN_RANGE
+	A, B, R2  float64
+	PredRange float64 // m, 0 if not predicted
+}
+
+// "Nice" (1, 2, 5 * 10^n) bin size, for about n bins over span
+func nice_step(span float64, n int) float64 {
+	raw := span / float64(n)
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, f := range []float64{1, 2, 5} {
+		if f*mag >= raw {
+			return f * mag
+		}
+	}
+	return 10 * mag
+}
+
+func new_bins(lo, step float64, n int) []LinkBin {
+	bins := make([]LinkBin, n)
+	for j := range bins {
+		bins[j] = LinkBin{Lo: lo + float64(j)*step, Hi: lo + float64(j+1)*step, RssiMin: 100, LQMin: 100}
+	}
+	return bins
+}
+
+func (lb *LinkBin) add(b types.LogItem) {
+	lb.N++
+	lb.Rssi += float64(b.Rssi)
+	lb.LQ += float64(b.LQ)
+	lb.RssiMin = math.Min(lb.RssiMin, float64(b.Rssi))
+	lb.LQMin = math.Min(lb.LQMin, float64(b.LQ))
+	lb.MaxRange = math.Max(lb.MaxRange, b.Vrange)
+}
+
+func finish_bins(bins []LinkBin) {
+	for j := range bins {
+		lb := &bins[j]
+		if lb.N > 0 {
+			lb.Rssi /= float64(lb.N)
+			lb.LQ /= float64(lb.N)
+		} else {
+			lb.RssiMin, lb.LQMin = 0, 0
+		}
+	}
+}
+
+func bin_index(v, lo, step float64, n int) int {
+	j := int(math.Floor((v - lo) / step))
+	if j < 0 {
+		j = 0
+	} else if j >= n {
+		j = n - 1
+	}
+	return j
+}
+
+// Analyses the RSSI / LQ, for logs with valid RSSI
+func AnalyseLink(rec types.LogRec) (LinkReport, bool) {
+	var lr LinkReport
+	if rec.Cap&types.CAP_RSSI_VALID == 0 {
+		return lr, false
+	}
+	lr.HasLQ = rec.Cap&types.CAP_LQ != 0
+	var items []types.LogItem
+	amin, amax := math.Inf(1), math.Inf(-1)
+	for _, b := range rec.Items {
+		if b.Fix > 1 && b.Bearing >= 0 {
+			items = append(items, b)
+			lr.MaxRange = math.Max(lr.MaxRange, b.Vrange)
+			amin, amax = math.Min(amin, b.Alt), math.Max(amax, b.Alt)
+		}
+	}
+	if len(items) == 0 {
+		return lr, false
+	}
+
+	rstep := nice_step(lr.MaxRange, LINK_RANGE_BINS)
+	nr := int(lr.MaxRange/rstep) + 1
+	lr.ByRange = new_bins(0, rstep, nr)
+	lr.ByBearing = new_bins(0, 360.0/LINK_SECTORS, LINK_SECTORS)
+	astep := nice_step(amax-amin, LINK_ALT_BINS)
+	alo := astep * math.Floor(amin/astep)
+	na := int((amax-alo)/astep) + 1
+	lr.ByAlt = new_bins(alo, astep, na)
+
+	// sectors are centred on the bearing, i.e. N is [-11.25, 11.25)
+	half := 180.0 / LINK_SECTORS
+	for j := range lr.ByBearing {
+		lr.ByBearing[j].Lo -= half
+		lr.ByBearing[j].Hi -= half
+	}
+
+	var n, sx, sy, sxx, sxy, syy, rmin, rmax float64
+	rmin = math.Inf(1)
+	for _, b := range items {
+		lr.ByRange[bin_index(b.Vrange, 0, rstep, nr)].add(b)
+		brg := math.Mod(float64(b.Bearing)+half, 360)
+		lr.ByBearing[bin_index(brg, 0, 360.0/LINK_SECTORS, LINK_SECTORS)].add(b)
+		lr.ByAlt[bin_index(b.Alt, alo, astep, na)].add(b)
+		if b.Vrange >= LINK_MIN_RANGE && b.Rssi > 0 {
+			x, y := math.Log10(b.Vrange), float64(b.Rssi)
+			n++
+			sx += x
+			sy += y
+			sxx += x * x
+			sxy += x * y
+			syy += y * y
+			rmin, rmax = math.Min(rmin, b.Vrange), math.Max(rmax, b.Vrange)
+		}
+	}
+	finish_bins(lr.ByRange)
+	finish_bins(lr.ByBearing)
+	finish_bins(lr.ByAlt)
+
+	if n >= LINK_MIN_N && rmax >= LINK_MIN_RATIO*rmin {
+		vx := sxx/n - (sx/n)*(sx/n)
+		vy := syy/n - (sy/n)*(sy/n)
+		cxy := sxy/n - (sx/n)*(sy/n)
+		lr.B = cxy / vx
+		lr.A = sy/n - lr.B*sx/n
+		if vy > 0 {
+			lr.R2 = cxy * cxy / (vx * vy)
+		}
+		if lr.B < 0 {
+			pr := math.Pow(10, (LINK_RSSI_LIMIT-lr.A)/lr.B)
+			if pr <= LINK_MAX_PRED*lr.MaxRange {
+				lr.PredRange = pr
+			}
+		}
+	}
+	return lr, true
+}
@@ -0,0 +1,244 @@
+package analysis
+
+import (
+	"math"
+)
+
+import (
+	"geo"
+	"inav"
+	"mission"
+	"types"
+)
+
+/*
+ * Mission adherence. The log is walked (in WP mode) alongside the
+ * mission, the active waypoint being determined by inav.WPState (from the
+ * logged active WP where available, else by proximity), as for the LTM /
+ * MQTT replay. Each leg runs from the previous waypoint (or the position
+ * at which WP mode was entered) to the active waypoint; the cross-track
+ * error is the distance from the great circle leg (+ve right of track),
+ * the altitude error that from the planned altitude, interpolated along
+ * the leg. The overshoot at a waypoint is the maximum cross-track error,
+ * on the outside of the turn, over the first half of the following leg.
+ * A leg left other than by advancing to the next waypoint (e.g. the last,
+ * or a change of mode) counts as reached if the closest approach was
+ * within MISSION_REACH_RADIUS (the minimum WPState capture distance).
+ */
+
+const (
+	MISSION_OVERSHOOT_FRAC = 0.5  // of the following leg
+	MISSION_REACH_RADIUS   = 30.0 // m
+)
+
+// A logged position and the nearest point on the planned leg (altitudes
+// relative to home)
+type LegSample struct {
+	Index      int // in the LogRec
+	TLat, TLon float64
+	TAlt       float64
+	XTE        float64 // m, +ve right of track
+	AltErr     float64 // m, +ve above plan, where HasAlt
+}
+
+type MissionLeg struct {
+	No         int // WP number
+	Action     string
+	Lat, Lon   float64 // WP
+	Length     float64 // m, planned
+	Start, End float64 // s, from the start of the log
+	Reached    bool
+	Dist       float64 // m, flown
+	Closest    float64 // m, closest approach to the WP
+	MeanXTE    float64 // m, mean absolute
+	MaxXTE     float64
+	HasAlt     bool
+	MeanAlt    float64 // m, mean absolute
+	MaxAlt     float64
+	Overshoot  float64 // m, at the turn onto the following leg
+	Samples    []LegSample
+	brg        float64
+	salt, talt float64
+}
+
+type MissionReport struct {
+	Legs    []MissionLeg
+	Reached int
+	Missed  []int // WP numbers never reached
+	MeanXTE float64
+	MaxXTE  float64
+	MeanAlt float64
+	MaxAlt  float64
+}
+
+// Planned altitude relative to home; P3 bit 0 is an absolute (AMSL)
+// altitude, which requires the home altitude
+func wp_altitude(mi mission.MissionItem, hpos types.HomeRec) (float64, bool) {
+	if mi.P3&1 == 0 {
+		return float64(mi.Alt), true
+	}
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		return float64(mi.Alt) - hpos.HomeAlt, true
+	}
+	return 0, false
+}
+
+func is_waypoint(mi mission.MissionItem) bool {
+	return mi.Is_GeoPoint() && mi.Action != "SET_POI"
+}
+
+// Closes a leg; the means are accumulated as sums
+func (l *MissionLeg) finish(rec types.LogRec, j int, reached bool) {
+	b := rec.Items[j]
+	l.End = float64(b.Stamp-rec.Items[0].Stamp) / 1e6
+	l.Reached = reached || l.Closest <= MISSION_REACH_RADIUS
+	if n := len(l.Samples); n > 0 {
+		l.Dist = b.Tdist - rec.Items[l.Samples[0].Index].Tdist
+		l.MeanXTE /= float64(n)
+		l.MeanAlt /= float64(n)
+	}
+}
+
+func AnalyseMission(ms *mission.Mission, rec types.LogRec, hpos types.HomeRec, intvl int) (MissionReport, bool) {
+	var mr MissionReport
+	if ms == nil || len(ms.MissionItems) == 0 || len(rec.Items) == 0 {
+		return mr, false
+	}
+	// WPState updates the jump counters, so use a copy
+	m := *ms
+	m.MissionItems = append([]mission.MissionItem(nil), ms.MissionItems...)
+	for k, mi := range m.MissionItems {
+		if mi.Action == "JUMP" {
+			m.MissionItems[k].P3 = mi.P2
+		}
+	}
+	nitems := len(m.MissionItems)
+
+	wps := inav.NewWPState(intvl)
+	tgt := 0
+	inwp := false
+	var cur *MissionLeg
+	var slat, slon, salt float64
+	for j, b := range rec.Items {
+		if b.Fmode != types.FM_WP {
+			if cur != nil {
+				cur.finish(rec, j, false)
+				cur = nil
+			}
+			inwp = false
+			continue
+		}
+		if !inwp {
+			tgt = 1
+			inwp = true
+			slat, slon, salt = b.Lat, b.Lon, b.Alt
+		}
+		if tgt > 0 && tgt <= nitems {
+			tgt, _ = wps.WP_state(&m, b, tgt)
+		}
+		if cur != nil && cur.No != tgt {
+			// advanced, in sequence
+			cur.finish(rec, j, true)
+			slat, slon = cur.Lat, cur.Lon
+			if cur.HasAlt {
+				salt = cur.talt
+			} else {
+				salt = b.Alt
+			}
+			cur = nil
+		}
+		if tgt < 1 || tgt > nitems || !is_waypoint(m.MissionItems[tgt-1]) {
+			// complete, RTH or not the logged mission
+			continue
+		}
+		if cur == nil {
+			mi := m.MissionItems[tgt-1]
+			mr.Legs = append(mr.Legs, MissionLeg{No: mi.No, Action: mi.Action, Lat: mi.Lat, Lon: mi.Lon,
+				Start: float64(b.Stamp-rec.Items[0].Stamp) / 1e6, Closest: math.Inf(1), salt: salt})
+			cur = &mr.Legs[len(mr.Legs)-1]
+			cur.talt, cur.HasAlt = wp_altitude(mi, hpos)
+			var d float64
+			cur.brg, d = geo.Csedist(slat, slon, mi.Lat, mi.Lon)
+			cur.Length = d * 1852
+		}
+
+		brg, d := geo.Csedist(slat, slon, b.Lat, b.Lon)
+		d *= 1852
+		da := (brg - cur.brg) * math.Pi / 180
+		xte := d * math.Sin(da)
+		ate := d * math.Cos(da)
+		frac := 0.0
+		if cur.Length > 0 {
+			frac = math.Max(0, math.Min(1, ate/cur.Length))
+		}
+		s := LegSample{Index: j, XTE: xte}
+		s.TLat, s.TLon = geo.Posit(slat, slon, cur.brg, frac*cur.Length/1852)
+		if cur.HasAlt {
+			s.TAlt = cur.salt + frac*(cur.talt-cur.salt)
+			s.AltErr = b.Alt - s.TAlt
+		} else {
+			s.TAlt = b.Alt
+		}
+		cur.Samples = append(cur.Samples, s)
+		cur.MeanXTE += math.Abs(xte)
+		cur.MaxXTE = math.Max(cur.MaxXTE, math.Abs(xte))
+		cur.MeanAlt += math.Abs(s.AltErr)
+		cur.MaxAlt = math.Max(cur.MaxAlt, math.Abs(s.AltErr))
+		_, dwp := geo.Csedist(b.Lat, b.Lon, cur.Lat, cur.Lon)
+		cur.Closest = math.Min(cur.Closest, dwp*1852)
+	}
+	if cur != nil {
+		cur.finish(rec, len(rec.Items)-1, false)
+	}
+	if len(mr.Legs) == 0 {
+		return mr, false
+	}
+
+	// overshoot, at each WP reached and followed directly by the next leg
+	for k := 0; k < len(mr.Legs)-1; k++ {
+		l, nl := &mr.Legs[k], &mr.Legs[k+1]
+		if !l.Reached || nl.Start != l.End || nl.Length == 0 {
+			continue
+		}
+		turn := 1.0 // right
+		if math.Mod(nl.brg-l.brg+540, 360)-180 < 0 {
+			turn = -1
+		}
+		for _, s := range nl.Samples {
+			_, d := geo.Csedist(l.Lat, l.Lon, s.TLat, s.TLon)
+			if d*1852 > MISSION_OVERSHOOT_FRAC*nl.Length {
+				break
+			}
+			l.Overshoot = math.Max(l.Overshoot, -turn*s.XTE)
+		}
+	}
+
+	reached := make(map[int]bool)
+	n, na := 0, 0
+	for _, l := range mr.Legs {
+		if l.Reached {
+			mr.Reached++
+			reached[l.No] = true
+		}
+		mr.MeanXTE += l.MeanXTE * float64(len(l.Samples))
+		mr.MaxXTE = math.Max(mr.MaxXTE, l.MaxXTE)
+		n += len(l.Samples)
+		if l.HasAlt {
+			mr.MeanAlt += l.MeanAlt * float64(len(l.Samples))
+			mr.MaxAlt = math.Max(mr.MaxAlt, l.MaxAlt)
+			na += len(l.Samples)
+		}
+	}
+	if n > 0 {
+		mr.MeanXTE /= float64(n)
+	}
+	if na > 0 {
+		mr.MeanAlt /= float64(na)
+	}
+	for _, mi := range m.MissionItems {
+		if is_waypoint(mi) && !reached[mi.No] {
+			mr.Missed = append(mr.Missed, mi.No)
+		}
+	}
+	return mr, true
+}
@@ -0,0 +1,125 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+import (
+	"geo"
+	"mission"
+	"types"
+)
+
+type tpt struct {
+	lat, lon float64
+	wp       uint8 // logged active WP, on the segment to this point
+}
+
+// A WP mode track from pts[0] through pts, sampled about every 11 m (but
+// not at the later vertices), one a second
+func wp_track(pts []tpt) types.LogRec {
+	var rec types.LogRec
+	add := func(lat, lon float64, cse float64, wp uint8) {
+		b := types.LogItem{Stamp: uint64(len(rec.Items)) * 1000000, Lat: lat, Lon: lon,
+			Cse: uint32(cse), Fmode: types.FM_WP, Fix: 3, ActiveWP: wp}
+		if n := len(rec.Items); n > 0 {
+			_, d := geo.Csedist(rec.Items[n-1].Lat, rec.Items[n-1].Lon, lat, lon)
+			b.Tdist = rec.Items[n-1].Tdist + d*1852
+		}
+		rec.Items = append(rec.Items, b)
+	}
+	add(pts[0].lat, pts[0].lon, 0, pts[0].wp)
+	for k := 1; k < len(pts); k++ {
+		p0, p1 := pts[k-1], pts[k]
+		cse, _ := geo.Csedist(p0.lat, p0.lon, p1.lat, p1.lon)
+		n := int(math.Ceil(math.Hypot(p1.lat-p0.lat, p1.lon-p0.lon) / 0.0001))
+		for j := 0; j < n; j++ {
+			f := (float64(j) + 0.5) / float64(n)
+			add(p0.lat+f*(p1.lat-p0.lat), p0.lon+f*(p1.lon-p0.lon), cse, p1.wp)
+		}
+	}
+	return rec
+}
+
+func wp_mission(items ...mission.MissionItem) *mission.Mission {
+	for k := range items {
+		items[k].No = k + 1
+	}
+	return &mission.Mission{MissionItems: items}
+}
+
+func waypoint(lat, lon float64) mission.MissionItem {
+	return mission.MissionItem{Action: "WAYPOINT", Lat: lat, Lon: lon}
+}
+
+// North to WP1 (11 m right of track), then a turn onto WP2, east or
+// west; the first part of the second leg is 22 m north (beyond WP1, so
+// outside the turn) or south of track
+var overshoot_tests = []struct {
+	name      string
+	dir, dlat float64
+	want      float64
+}{
+	{"right turn, outside", 1, 0.0002, 22.2},
+	{"right turn, inside", 1, -0.0002, 0},
+	{"left turn, outside", -1, 0.0002, 22.2},
+	{"left turn, inside", -1, -0.0002, 0},
+}
+
+func TestMissionLegs(t *testing.T) {
+	for _, ot := range overshoot_tests {
+		ms := wp_mission(waypoint(0.002, 0), waypoint(0.002, ot.dir*0.002))
+		rec := wp_track([]tpt{{0, 0, 1}, {0.0002, 0.0001, 1}, {0.002, 0.0001, 1},
+			{0.002 + ot.dlat, ot.dir * 0.0003, 2}, {0.002 + ot.dlat, ot.dir * 0.0006, 2},
+			{0.002, ot.dir * 0.002, 2}})
+		mr, ok := AnalyseMission(ms, rec, types.HomeRec{}, 100)
+		if !ok || len(mr.Legs) != 2 {
+			t.Errorf("%s: %d legs (%v), want 2", ot.name, len(mr.Legs), ok)
+			continue
+		}
+		l0, l1 := mr.Legs[0], mr.Legs[1]
+		if l0.No != 1 || l1.No != 2 || l1.Start != l0.End || mr.Reached != 2 || len(mr.Missed) != 0 {
+			t.Errorf("%s: legs %d %.0f-%.0f, %d %.0f-%.0f, %d reached, missed %v", ot.name,
+				l0.No, l0.Start, l0.End, l1.No, l1.Start, l1.End, mr.Reached, mr.Missed)
+		}
+		if xte := l0.Samples[len(l0.Samples)/2].XTE; math.Abs(xte-11.1) > 0.5 {
+			t.Errorf("%s: leg 1 XTE %.1f m, want 11.1 (right of track)", ot.name, xte)
+		}
+		if math.Abs(l0.Overshoot-ot.want) > 1 {
+			t.Errorf("%s: overshoot %.1f m, want %.1f", ot.name, l0.Overshoot, ot.want)
+		}
+	}
+}
+
+// WP2 is followed by a JUMP to WP1, once; WPs are reached by proximity
+// (no logged active WP), as for older logs
+func TestMissionJump(t *testing.T) {
+	jump := mission.MissionItem{Action: "JUMP", P1: 1, P2: 1}
+	ms := wp_mission(waypoint(0.002, 0), waypoint(0.002, 0.002), jump, waypoint(0, 0.002))
+	rec := wp_track([]tpt{{0, 0, 0}, {0.002, 0, 0}, {0.002, 0.002, 0}, {0.002, 0, 0},
+		{0.002, 0.002, 0}, {0, 0.002, 0}})
+	mr, ok := AnalyseMission(ms, rec, types.HomeRec{}, 100)
+	if !ok {
+		t.Fatal("not analysed")
+	}
+	want := []int{1, 2, 1, 2, 4}
+	var nos []int
+	for _, l := range mr.Legs {
+		nos = append(nos, l.No)
+	}
+	if len(nos) != len(want) {
+		t.Fatalf("legs %v, want %v", nos, want)
+	}
+	for j := range want {
+		if nos[j] != want[j] || !mr.Legs[j].Reached {
+			t.Errorf("leg %d: WP %d (reached %v), want %d", j, nos[j], mr.Legs[j].Reached, want[j])
+		}
+	}
+	if mr.Reached != len(want) || len(mr.Missed) != 0 {
+		t.Errorf("%d reached, missed %v", mr.Reached, mr.Missed)
+	}
+	if ms.MissionItems[2].P3 != 0 {
+		t.Errorf("mission JUMP counter modified (%d)", ms.MissionItems[2].P3)
+	}
+}
@@ -0,0 +1,137 @@
+package analysis
+
+import (
+	"math"
+)
+
+import (
+	"geo"
+	"types"
+)
+
+/*
+ * Terrain clearance (AGL), from the HGT DEM. The aircraft's AMSL altitude
+ * is the DEM elevation at home plus the (home relative) altitude, so that
+ * any DEM bias at home cancels. The minimum clearance and the low
+ * segments are only considered while airborne (as for the takeoff and
+ * landing events).
+ */
+
+const (
+	DEM_VOID = -1000.0 // m, below which the DEM has no data
+)
+
+type LowSegment struct {
+	First, Last int     // LogRec item indices
+	Start, End  float64 // s, from the start of the log
+	Min         float64 // m
+}
+
+type TerrainReport struct {
+	Min      float64 // m
+	MinIndex int
+	MinTime  float64 // s, from the start of the log
+	Low      []LowSegment
+}
+
+// Sets the AGL of each item (NaN where there is no DEM data) and
+// CAP_AGL. DEM tiles are downloaded as required; a tile that can't be
+// read is not retried.
+func ComputeAGL(rec *types.LogRec, hpos types.HomeRec) bool {
+	if len(rec.Items) == 0 {
+		return false
+	}
+	d := geo.InitDem("")
+	defer d.Close()
+	failed := make(map[[2]float64]bool)
+	elevation := func(lat, lon float64) (float64, bool) {
+		tile := [2]float64{math.Floor(lat), math.Floor(lon)}
+		if failed[tile] {
+			return 0, false
+		}
+		e, err := d.Get_Elevation(lat, lon)
+		if err != nil {
+			failed[tile] = true
+			return 0, false
+		}
+		return e, e > DEM_VOID
+	}
+
+	hlat, hlon := hpos.HomeLat, hpos.HomeLon
+	if (hpos.Flags & types.HOME_ARM) == 0 {
+		hlat, hlon = math.NaN(), math.NaN()
+		for _, b := range rec.Items {
+			if b.Fix > 1 {
+				hlat, hlon = b.Lat, b.Lon
+				break
+			}
+		}
+		if math.IsNaN(hlat) {
+			return false
+		}
+	}
+	helev, ok := elevation(hlat, hlon)
+	if !ok {
+		return false
+	}
+	for j := range rec.Items {
+		b := &rec.Items[j]
+		b.Agl = math.NaN()
+		if b.Fix > 1 {
+			if e, ok := elevation(b.Lat, b.Lon); ok {
+				b.Agl = helev + b.Alt - e
+			}
+		}
+	}
+	rec.Cap |= types.CAP_AGL
+	return true
+}
+
+// Minimum clearance, and the segments below minagl (0 disables)
+func TerrainClearance(rec types.LogRec, minagl float64) (TerrainReport, bool) {
+	tr := TerrainReport{Min: math.Inf(1), MinIndex: -1}
+	if rec.Cap&types.CAP_AGL == 0 {
+		return tr, false
+	}
+	items := rec.Items
+	first, last := -1, -1
+	for j, b := range items {
+		if b.Alt > types.EVT_AIR_ALT {
+			if first == -1 {
+				first = j
+			}
+			last = j
+		}
+	}
+	if first == -1 {
+		return tr, false
+	}
+	stamp := func(j int) float64 {
+		return float64(items[j].Stamp-items[0].Stamp) / 1e6
+	}
+	low := -1
+	for j := first; j <= last; j++ {
+		a := items[j].Agl
+		if math.IsNaN(a) {
+			continue
+		}
+		if a < tr.Min {
+			tr.Min, tr.MinIndex = a, j
+		}
+		if minagl > 0 && a < minagl {
+			if low == -1 {
+				tr.Low = append(tr.Low, LowSegment{First: j, Start: stamp(j), Min: a})
+				low = len(tr.Low) - 1
+			}
+			tr.Low[low].Min = math.Min(tr.Low[low].Min, a)
+			tr.Low[low].Last, tr.Low[low].End = j, stamp(j)
+		} else {
+			low = -1
+		}
+	}
+	if tr.MinIndex == -1 {
+		return tr, false
+	}
+	tr.MinTime = stamp(tr.MinIndex)
+	return tr, true
+}
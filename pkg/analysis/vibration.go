@@ -0,0 +1,228 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+import (
+	"types"
+)
+
+/*
+ * Vibration analysis of full rate gyro and accelerometer data. The
+ * spectra are Welch averages: the samples are divided into 50% overlapping
+ * segments, each is detrended (mean removed), Hann windowed and
+ * transformed; the amplitude of each bin is the RMS over the segments,
+ * scaled such that a sinusoid of amplitude A shows as A. The RMS
+ * vibration (of the detrended segments) is accumulated by the segment's
+ * mean throttle.
+ */
+
+const (
+	VIB_SEG     = 1024 // samples per FFT segment (maximum)
+	VIB_MIN_SEG = 64   // samples
+	VIB_MIN_HZ  = 10.0 // Hz, peaks below are (probably) flight motion
+	VIB_BAND    = 25   // %, throttle band width
+)
+
+var (
+	GyroAxes = [3]string{"roll", "pitch", "yaw"}
+	AccAxes  = [3]string{"x", "y", "z"}
+)
+
+type VibPeak struct {
+	Freq float64 // Hz
+	Amp  float64
+}
+
+type ThrottleBand struct {
+	Lo, Hi   int // %
+	Segments int
+	Gyro     [3]float64 // RMS deg/s
+	Acc      [3]float64 // RMS g
+}
+
+type VibrationReport struct {
+	Rate        float64 // Hz, sample rate
+	Samples     int
+	Duration    float64 // s
+	Freq        []float64
+	Gyro        [3][]float64 // amplitude (deg/s) per Freq
+	Acc         [3][]float64 // amplitude (g) per Freq
+	GyroPeak    [3]VibPeak
+	AccPeak     [3]VibPeak
+	GyroRMS     [3]float64
+	AccRMS      [3]float64
+	Bands       []ThrottleBand
+	HasThrottle bool // throttle is available
+}
+
+// In place radix-2 FFT; len(x) must be a power of 2
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for l := 2; l <= n; l <<= 1 {
+		w := cmplx.Exp(complex(0, -2*math.Pi/float64(l)))
+		for i := 0; i < n; i += l {
+			wk := complex(1, 0)
+			for k := 0; k < l/2; k++ {
+				u := x[i+k]
+				v := x[i+k+l/2] * wk
+				x[i+k] = u + v
+				x[i+k+l/2] = u - v
+				wk *= w
+			}
+		}
+	}
+}
+
+func hann(n int) ([]float64, float64) {
+	w := make([]float64, n)
+	var sw float64
+	for j := range w {
+		w[j] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(j)/float64(n-1))
+		sw += w[j]
+	}
+	return w, sw
+}
+
+// Largest (maximum VIB_SEG) power of 2 <= n
+func seg_size(n int) int {
+	m := VIB_SEG
+	for m > n {
+		m >>= 1
+	}
+	return m
+}
+
+func peak(freq, amp []float64) VibPeak {
+	var p VibPeak
+	for k, f := range freq {
+		if f >= VIB_MIN_HZ && amp[k] > p.Amp {
+			p = VibPeak{Freq: f, Amp: amp[k]}
+		}
+	}
+	return p
+}
+
+// Analyses full rate IMU samples (in time order)
+func AnalyseVibration(samples []types.IMUSample) (VibrationReport, error) {
+	var vr VibrationReport
+	n := len(samples)
+	if n < VIB_MIN_SEG {
+		return vr, fmt.Errorf("too few IMU samples (%d)", n)
+	}
+	if samples[n-1].Stamp <= samples[0].Stamp {
+		return vr, fmt.Errorf("invalid IMU timestamps")
+	}
+	vr.Samples = n
+	vr.Duration = float64(samples[n-1].Stamp-samples[0].Stamp) / 1e6
+	vr.Rate = float64(n-1) / vr.Duration
+
+	m := seg_size(n)
+	win, sw := hann(m)
+	nb := m/2 + 1
+	vr.Freq = make([]float64, nb)
+	for k := range vr.Freq {
+		vr.Freq[k] = float64(k) * vr.Rate / float64(m)
+	}
+	var pg, pa [3][]float64
+	for a := 0; a < 3; a++ {
+		pg[a] = make([]float64, nb)
+		pa[a] = make([]float64, nb)
+	}
+	nbands := 100 / VIB_BAND
+	for b := 0; b < nbands; b++ {
+		vr.Bands = append(vr.Bands, ThrottleBand{Lo: b * VIB_BAND, Hi: (b + 1) * VIB_BAND})
+	}
+
+	buf := make([]complex128, m)
+	vals := make([]float64, m)
+	// transforms a segment, adding to the power, returning the variance
+	spectrum := func(pw []float64) float64 {
+		var mean, vari float64
+		for _, v := range vals {
+			mean += v
+		}
+		mean /= float64(m)
+		for j, v := range vals {
+			vari += (v - mean) * (v - mean)
+			buf[j] = complex((v-mean)*win[j], 0)
+		}
+		fft(buf)
+		for k := 0; k < nb; k++ {
+			a := 2 * cmplx.Abs(buf[k]) / sw
+			pw[k] += a * a
+		}
+		return vari / float64(m)
+	}
+
+	var nseg int
+	var gss, ass [3]float64
+	for j0 := 0; j0+m <= n; j0 += m / 2 {
+		seg := samples[j0 : j0+m]
+		var thr int
+		for _, s := range seg {
+			thr += s.Throttle
+			if s.Throttle > 0 {
+				vr.HasThrottle = true
+			}
+		}
+		band := (thr / m) / VIB_BAND
+		if band >= nbands {
+			band = nbands - 1
+		}
+		tb := &vr.Bands[band]
+		tb.Segments++
+		for a := 0; a < 3; a++ {
+			for j, s := range seg {
+				vals[j] = s.Gyro[a]
+			}
+			v := spectrum(pg[a])
+			gss[a] += v
+			tb.Gyro[a] += v
+			for j, s := range seg {
+				vals[j] = s.Acc[a]
+			}
+			v = spectrum(pa[a])
+			ass[a] += v
+			tb.Acc[a] += v
+		}
+		nseg++
+	}
+
+	for a := 0; a < 3; a++ {
+		vr.Gyro[a] = make([]float64, nb)
+		vr.Acc[a] = make([]float64, nb)
+		for k := 0; k < nb; k++ {
+			vr.Gyro[a][k] = math.Sqrt(pg[a][k] / float64(nseg))
+			vr.Acc[a][k] = math.Sqrt(pa[a][k] / float64(nseg))
+		}
+		vr.GyroPeak[a] = peak(vr.Freq, vr.Gyro[a])
+		vr.AccPeak[a] = peak(vr.Freq, vr.Acc[a])
+		vr.GyroRMS[a] = math.Sqrt(gss[a] / float64(nseg))
+		vr.AccRMS[a] = math.Sqrt(ass[a] / float64(nseg))
+	}
+	for j := range vr.Bands {
+		tb := &vr.Bands[j]
+		if tb.Segments == 0 {
+			continue
+		}
+		for a := 0; a < 3; a++ {
+			tb.Gyro[a] = math.Sqrt(tb.Gyro[a] / float64(tb.Segments))
+			tb.Acc[a] = math.Sqrt(tb.Acc[a] / float64(tb.Segments))
+		}
+	}
+	return vr, nil
+}
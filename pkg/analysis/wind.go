@@ -0,0 +1,166 @@
+package analysis
+
+import (
+	"math"
+	"time"
+)
+
+import (
+	"types"
+)
+
+/*
+ * Wind estimation. The log is divided into (overlapping) windows; in each
+ * window the ground velocity (course and speed over ground) is the sum of
+ * the air velocity (along the heading) and the wind.
+ *
+ * Where airspeed is logged, the wind is the mean of the ground velocity
+ * less the air velocity. Otherwise, assuming the airspeed is constant over
+ * the window, the airspeed and wind are the least squares solution of
+ *
+ *   Vg.x = Va.sin(hdg) + Wx ; Vg.y = Va.cos(hdg) + Wy
+ *
+ * which requires a range of headings (i.e. turns) in the window. This
+ * assumes that the aircraft flies along its heading, so is not valid for
+ * multirotors, for which no estimate is made.
+ */
+
+const (
+	WIND_WINDOW  = 30  // s
+	WIND_STEP    = 15  // s
+	WIND_MIN_SPD = 3.0 // m/s, ground speed
+	WIND_MIN_ALT = 3.0 // m, above home
+	WIND_MIN_N   = 5   // samples in a window
+	WIND_MAX_R   = 0.7 // heading resultant, for sufficient turning
+)
+
+const (
+	WIND_AIRSPEED = "airspeed"
+	WIND_TURNS    = "turns"
+)
+
+type WindEstimate struct {
+	Index  int // item at the middle of the window
+	Utc    time.Time
+	Speed  float64 // m/s
+	Dir    float64 // degrees, from which the wind blows
+	Airspd float64 // m/s, mean measured or estimated airspeed
+	Method string
+}
+
+func unit(deg float64) (float64, float64) {
+	r := deg * math.Pi / 180
+	return math.Sin(r), math.Cos(r)
+}
+
+// Direction from which a (towards) vector blows
+func from_dir(wx, wy float64) float64 {
+	d := math.Atan2(-wx, -wy) * 180 / math.Pi
+	if d < 0 {
+		d += 360
+	}
+	return d
+}
+
+func wind_window(items []types.LogItem, useas bool) (WindEstimate, bool) {
+	var n, sgx, sgy, ss, sc, sb, sas float64
+	for _, b := range items {
+		gx, gy := unit(float64(b.Cog))
+		gx, gy = gx*b.Spd, gy*b.Spd
+		s, c := unit(float64(b.Cse))
+		if useas {
+			gx, gy = gx-b.Airspd*s, gy-b.Airspd*c
+			sas += b.Airspd
+		}
+		n++
+		sgx += gx
+		sgy += gy
+		ss += s
+		sc += c
+		sb += s*gx + c*gy
+	}
+	if n < WIND_MIN_N {
+		return WindEstimate{}, false
+	}
+	if useas {
+		wx, wy := sgx/n, sgy/n
+		return WindEstimate{Speed: math.Hypot(wx, wy), Dir: from_dir(wx, wy),
+			Airspd: sas / n, Method: WIND_AIRSPEED}, true
+	}
+	// heading diversity; R = 1 for straight flight
+	r := math.Hypot(ss, sc) / n
+	if r > WIND_MAX_R {
+		return WindEstimate{}, false
+	}
+	va := (sb - (ss*sgx+sc*sgy)/n) / (n * (1 - r*r))
+	wx := (sgx - va*ss) / n
+	wy := (sgy - va*sc) / n
+	w := math.Hypot(wx, wy)
+	if va <= 0 || w >= va {
+		return WindEstimate{}, false
+	}
+	return WindEstimate{Speed: w, Dir: from_dir(wx, wy), Airspd: va, Method: WIND_TURNS}, true
+}
+
+// Wind estimates over sliding windows of the airborne, moving, 3D fix
+// items.
+func EstimateWind(rec types.LogRec, meta types.FlightMeta) []WindEstimate {
+	var ests []WindEstimate
+	items := rec.Items
+	if mr, _ := meta.Multirotor(); mr || len(items) == 0 {
+		return ests
+	}
+	hasas := rec.Cap&types.CAP_AIRSPEED != 0
+	t0 := items[0].Stamp
+	tend := items[len(items)-1].Stamp
+	j0 := 0
+	for ts := t0; ts+WIND_WINDOW*1000000/2 <= tend; ts += WIND_STEP * 1000000 {
+		for j0 < len(items) && items[j0].Stamp < ts {
+			j0++
+		}
+		var win []types.LogItem
+		mid := -1
+		useas := hasas
+		for j := j0; j < len(items); j++ {
+			b := items[j]
+			if b.Stamp >= ts+WIND_WINDOW*1000000 {
+				break
+			}
+			if mid == -1 && b.Stamp >= ts+WIND_WINDOW*1000000/2 {
+				mid = j
+			}
+			if b.Fix > 1 && b.Spd > WIND_MIN_SPD && b.Alt > WIND_MIN_ALT {
+				win = append(win, b)
+				if b.Airspd <= 0 {
+					useas = false
+				}
+			}
+		}
+		if mid == -1 {
+			continue
+		}
+		if e, ok := wind_window(win, useas); ok {
+			e.Index = mid
+			e.Utc = items[mid].Utc
+			ests = append(ests, e)
+		}
+	}
+	return ests
+}
+
+// Mean wind (vector average) of the estimates
+func MeanWind(ests []WindEstimate) (float64, float64, bool) {
+	if len(ests) == 0 {
+		return 0, 0, false
+	}
+	var wx, wy float64
+	for _, e := range ests {
+		// towards vector
+		s, c := unit(e.Dir)
+		wx -= e.Speed * s
+		wy -= e.Speed * c
+	}
+	wx /= float64(len(ests))
+	wy /= float64(len(ests))
+	return math.Hypot(wx, wy), from_dir(wx, wy), true
+}
@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+import (
+	"types"
+)
+
+// An item flying hdg at airspd in a wind (towards) wx, wy; as logged, the
+// course over ground is whole degrees
+func wind_item(hdg, airspd, wx, wy float64, useas bool) types.LogItem {
+	s, c := unit(hdg)
+	gx, gy := airspd*s+wx, airspd*c+wy
+	cog := math.Mod(math.Round(math.Atan2(gx, gy)*180/math.Pi)+360, 360)
+	b := types.LogItem{Cse: uint32(hdg), Cog: uint32(cog), Spd: math.Hypot(gx, gy)}
+	if useas {
+		b.Airspd = airspd
+	}
+	return b
+}
+
+func wind_items(hdgs []float64, airspd, wx, wy float64, useas bool) []types.LogItem {
+	var items []types.LogItem
+	for _, h := range hdgs {
+		items = append(items, wind_item(h, airspd, wx, wy, useas))
+	}
+	return items
+}
+
+var orbit = []float64{0, 45, 90, 135, 180, 225, 270, 315}
+
+var wind_tests = []struct {
+	name   string
+	items  []types.LogItem
+	useas  bool
+	ok     bool
+	speed  float64
+	dir    float64
+	method string
+}{
+	{"airspeed, headwind", wind_items([]float64{0, 0, 0, 0, 0}, 20, 0, -5, true), true,
+		true, 5, 0, WIND_AIRSPEED},
+	{"airspeed, westerly", wind_items(orbit, 20, 5, 0, true), true,
+		true, 5, 270, WIND_AIRSPEED},
+	{"airspeed, too few", wind_items([]float64{0, 0, 0, 0}, 20, 0, -5, true), true,
+		false, 0, 0, ""},
+	{"turns, westerly", wind_items(orbit, 20, 5, 0, false), false,
+		true, 5, 270, WIND_TURNS},
+	{"turns, south easterly", wind_items(orbit, 18, -4, 4, false), false,
+		true, 4 * math.Sqrt2, 135, WIND_TURNS},
+	{"turns, half orbit", wind_items([]float64{0, 30, 60, 90, 120, 150, 180}, 20, 0, 6, false), false,
+		true, 6, 180, WIND_TURNS},
+	{"turns, straight", wind_items([]float64{90, 90, 90, 90, 90, 90}, 20, 5, 0, false), false,
+		false, 0, 0, ""},
+	{"turns, too little turn", wind_items([]float64{0, 20, 40, 60, 80, 100}, 20, 5, 0, false), false,
+		false, 0, 0, ""},
+}
+
+func TestWindWindow(t *testing.T) {
+	for _, wt := range wind_tests {
+		e, ok := wind_window(wt.items, wt.useas)
+		if ok != wt.ok {
+			t.Errorf("%s: ok %v, want %v", wt.name, ok, wt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		ddir := math.Abs(math.Mod(e.Dir-wt.dir+540, 360) - 180)
+		if math.Abs(e.Speed-wt.speed) > 0.3 || ddir > 3 || e.Method != wt.method {
+			t.Errorf("%s: %.2f m/s from %.1f° (%s), want %.2f m/s from %.0f° (%s)",
+				wt.name, e.Speed, e.Dir, e.Method, wt.speed, wt.dir, wt.method)
+		}
+	}
+}
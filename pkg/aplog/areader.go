@@ -1,14 +1,11 @@
 package aplog
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
 	"fmt"
-	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strings"
 	"time"
 )
 
@@ -18,16 +15,6 @@ import (
 	"types"
 )
 
-type MavMeta struct {
-	Type      string  `json:"type"`
-	Timestamp float64 `json:"timestamp"`
-}
-
-type MavLog struct {
-	Meta MavMeta         `json:"meta"`
-	Data json.RawMessage `json:"data"`
-}
-
 type MavAtt struct {
 	DesPitch int64   `json:"DesPitch"`
 	DesRoll  int64   `json:"DesRoll"`
@@ -138,15 +125,32 @@ type MavErr struct {
 type APLOG struct {
 	name string
 	meta []types.FlightMeta
+	cfg  *options.Configuration
 }
 
 func NewAPReader(fn string) APLOG {
 	var l APLOG
 	l.name = fn
 	l.meta = nil
+	l.cfg = &options.Config
 	return l
 }
 
+func (o *APLOG) SetConfig(cfg *options.Configuration) {
+	o.cfg = cfg
+}
+
+func init() {
+	types.RegisterReader(types.LogReader{Ftype: types.IS_AP, Name: "Ardupilot",
+		Detect: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte{DF_HEAD1, DF_HEAD2, DF_FMT, DF_FMT, DF_FMT_LEN, 'F', 'M', 'T'})
+		},
+		New: func(fn string) types.FlightLog {
+			l := NewAPReader(fn)
+			return &l
+		}})
+}
+
 func (o *APLOG) LogType() byte {
 	return 'A'
 }
@@ -155,7 +159,9 @@ func (o *APLOG) GetMetas() ([]types.FlightMeta, error) {
 	m, err := types.ReadMetaCache(o.name)
 	if err != nil {
 		m, err = metas(o.name)
-		types.WriteMetaCache(o.name, m)
+		if err == nil {
+			types.WriteMetaCache(o.name, m)
+		}
 	}
 	o.meta = m
 	return m, err
@@ -174,48 +180,60 @@ func metas(logfile string) ([]types.FlightMeta, error) {
 		return nil, err
 	}
 	size := fi.Size()
-	cmd := exec.Command("mavlogdump.py", "--format", "json", logfile)
-	types.SetSilentProcess(cmd)
-	out, err := cmd.StdoutPipe()
-	defer cmd.Wait()
-	defer out.Close()
-	err = cmd.Start()
+	r, err := new_dfreader(logfile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start err=%v", err)
-		os.Exit(1)
+		return nil, err
 	}
+	defer r.Close()
 
 	mt := types.FlightMeta{Logname: filepath.Base(logfile), Size: size, Start: 0}
 
-	var st time.Time
-	var mlog MavLog
+	var g MavGPS
+	var fus, lus uint64
 	nl := 0
-	scanner := bufio.NewScanner(out)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if err := json.Unmarshal([]byte(text), &mlog); err == nil {
-			st = time_from_log_stamp(mlog.Meta.Timestamp)
-			if mt.Date.IsZero() {
-				mt.Date = st
-			}
-			if mlog.Meta.Type == "GPS" {
-				nl += 1
+	for {
+		mtype, f, vals, err := r.next()
+		if err != nil {
+			break
+		}
+		if us, ok := f.time_us(vals); ok {
+			if fus == 0 {
+				fus = us
 			}
+			lus = us
+		}
+		if f.name == "GPS" && f.primary(vals) {
+			r.fill(&g, mtype, f, vals)
+			r.update_clock(g)
+			nl += 1
+		}
+		if f.name == "MSG" && mt.Vehicle == types.VEHICLE_UNKNOWN {
+			mt.Vehicle = ap_vehicle(r.text(f, "Message"))
 		}
 	}
 
-	mt.Duration = st.Sub(mt.Date)
+	mt.Duration = r.stamp(lus).Sub(r.stamp(fus))
+	if r.hasoffs {
+		mt.Date = r.stamp(fus)
+	} else {
+		// No GPS time, best effort from the file
+		mt.Date = fi.ModTime().Add(-mt.Duration)
+	}
 	mt.End = nl
 	mt.Flags = types.Is_Valid | types.Has_Size | types.Has_Start
 	metas = append(metas, mt)
-	return metas, err
+	return metas, nil
 }
 
-func time_from_log_stamp(s float64) time.Time {
-	isec, fract := math.Modf(s)
-	nsec := int64(fract * 1e9)
-	return time.Unix(int64(isec), nsec)
+// From the firmware banner message, e.g. "ArduCopter V4.4.0 (c6e4d7b8)"
+func ap_vehicle(msg string) uint8 {
+	switch {
+	case strings.HasPrefix(msg, "ArduCopter"):
+		return types.VEHICLE_MR
+	case strings.HasPrefix(msg, "ArduPlane"):
+		return types.VEHICLE_FW
+	}
+	return types.VEHICLE_UNKNOWN
 }
 
 func create_record(m MavRec, have_origin bool) (types.LogItem, bool) {
@@ -305,27 +323,22 @@ func apmode_normalise(mnum int64) uint8 {
 	return md
 }
 
-func (lg *APLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegment, bool) {
-	cmd := exec.Command("mavlogdump.py", "--format", "json", lg.name)
-	types.SetSilentProcess(cmd)
-	out, err := cmd.StdoutPipe()
-	defer cmd.Wait()
-	defer out.Close()
-	err = cmd.Start()
+func (lg *APLOG) Reader(m types.FlightMeta, stream *types.LogStream) (types.LogSegment, error) {
+	ls := types.LogSegment{}
+	r, err := new_dfreader(lg.name)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start err=%v", err)
-		os.Exit(1)
+		return ls, err
 	}
+	defer r.Close()
 
 	var homes types.HomeRec
 	var rec types.LogRec
 	rec.Cap = (types.CAP_ALTITUDE | types.CAP_SPEED)
 
-	ndelay := 1000 * uint64(options.Config.Intvl)
+	ndelay := 1000 * uint64(lg.cfg.Intvl)
 
 	stats := types.LogStats{}
 	var mrec MavRec
-	var mlog MavLog
 	have_origin := false
 	var llat, llon float64
 	var dt, st, lt uint64
@@ -334,36 +347,35 @@ func (lg *APLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegme
 	lwhkm := 0.0
 	whacc := 0.0
 
-	re := regexp.MustCompile(": NaN,")
-
-	scanner := bufio.NewScanner(out)
-	scanner.Split(bufio.ScanLines)
-	for scanner.Scan() {
-		text := scanner.Text()
-		t := re.ReplaceAllString(text, ": null,")
-		if err := json.Unmarshal([]byte(t), &mlog); err != nil {
+	for {
+		mtype, f, vals, err := r.next()
+		if err != nil {
+			break
+		}
+		if !f.primary(vals) {
 			continue
 		}
-		switch mlog.Meta.Type {
+		switch f.name {
 		case "ATT":
-			json.Unmarshal(mlog.Data, &mrec.a)
+			r.fill(&mrec.a, mtype, f, vals)
 		case "ORGN":
-			json.Unmarshal(mlog.Data, &mrec.o)
+			r.fill(&mrec.o, mtype, f, vals)
 		case "BAT":
-			json.Unmarshal(mlog.Data, &mrec.b)
+			r.fill(&mrec.b, mtype, f, vals)
 		case "MODE":
-			json.Unmarshal(mlog.Data, &mrec.m)
+			r.fill(&mrec.m, mtype, f, vals)
 		case "CTUN":
-			json.Unmarshal(mlog.Data, &mrec.c)
+			r.fill(&mrec.c, mtype, f, vals)
 		case "ERR":
-			json.Unmarshal(mlog.Data, &mrec.err)
+			r.fill(&mrec.err, mtype, f, vals)
 		case "EV":
-			json.Unmarshal(mlog.Data, &mrec.ev)
+			r.fill(&mrec.ev, mtype, f, vals)
 		case "RAD":
-			json.Unmarshal(mlog.Data, &mrec.r)
+			r.fill(&mrec.r, mtype, f, vals)
 		case "GPS":
-			json.Unmarshal(mlog.Data, &mrec.g)
-			mrec.stamp = time_from_log_stamp(mlog.Meta.Timestamp)
+			r.fill(&mrec.g, mtype, f, vals)
+			r.update_clock(mrec.g)
+			mrec.stamp = r.stamp(uint64(mrec.g.TimeUS))
 			b, xhave_origin := create_record(mrec, have_origin)
 			if xhave_origin && have_origin == false {
 				homes.HomeLat = b.Hlat
@@ -374,8 +386,8 @@ func (lg *APLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegme
 				st = b.Stamp
 				llat = b.Lat
 				llon = b.Lon
-				if ch != nil {
-					ch <- homes
+				if stream != nil && !stream.Home(homes) {
+					return ls, stream.Err()
 				}
 			} else {
 				c, d := geo.Csedist(homes.HomeLat, homes.HomeLon, b.Lat, b.Lon)
@@ -433,8 +445,10 @@ func (lg *APLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegme
 							}
 						}
 
-						if ch != nil {
-							ch <- b
+						if stream != nil {
+							if !stream.Item(b) {
+								return ls, stream.Err()
+							}
 						} else {
 							rec.Items = append(rec.Items, b)
 						}
@@ -463,17 +477,18 @@ func (lg *APLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegme
 		}
 	}
 	srec := stats.Summary(lt - st)
-	ls := types.LogSegment{}
-	if ch != nil {
-		ch <- srec
-		return ls, true
-	} else {
-		ok := homes.Flags != 0 && len(rec.Items) > 0
-		if ok {
-			ls.L = rec
-			ls.H = homes
-			ls.M = srec
-		}
-		return ls, ok
+	if stream != nil {
+		ls.M = srec
+		ls.S = stats
+	} else if homes.Flags != 0 && len(rec.Items) > 0 {
+		ls.L = rec
+		ls.H = homes
+		ls.M = srec
+		ls.S = stats
+	}
+	// A fix on the last record sets the home, but leaves no items
+	if homes.Flags == 0 || (stream == nil && len(rec.Items) == 0) {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, m.Index, types.ErrNoGPSFix)
 	}
+	return ls, nil
 }
@@ -0,0 +1,305 @@
+package aplog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+ * Native reader for ArduPilot DataFlash (.bin) logs.
+ * Each message is 0xa3 0x95 <type> <payload>, with the payload layout
+ * defined by the FMT (type 0x80) messages in the log itself.
+ */
+
+const (
+	DF_HEAD1   = 0xa3
+	DF_HEAD2   = 0x95
+	DF_FMT     = 0x80
+	DF_FMT_LEN = 89
+)
+
+// GPS epoch, less the (current) GPS-UTC leap seconds
+var gps_epoch = time.Date(1980, 1, 6, 0, 0, 0, 0, time.UTC).Add(-18 * time.Second)
+
+type dfformat struct {
+	name    string
+	length  int
+	format  string
+	columns []string
+	timeidx int
+	instidx int
+	skip    bool // unknown field type, can't be decoded
+}
+
+type dfkey struct {
+	mtype byte
+	rtype reflect.Type
+}
+
+type dfreader struct {
+	name    string
+	fh      *os.File
+	rd      *bufio.Reader
+	fmts    map[byte]*dfformat
+	fmap    map[dfkey][]int
+	buf     []byte
+	vals    []float64
+	offset  time.Duration
+	hasoffs bool
+}
+
+func new_dfreader(fn string) (*dfreader, error) {
+	fh, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	r := &dfreader{name: fn, fh: fh, rd: bufio.NewReaderSize(fh, 1024*1024),
+		fmts: make(map[byte]*dfformat), fmap: make(map[dfkey][]int)}
+	r.fmts[DF_FMT] = &dfformat{name: "FMT", length: DF_FMT_LEN, format: "BBnNZ",
+		columns: []string{"Type", "Length", "Name", "Format", "Columns"}, timeidx: -1, instidx: -1}
+	return r, nil
+}
+
+func (r *dfreader) Close() {
+	r.fh.Close()
+}
+
+func df_string(b []byte) string {
+	if n := strings.IndexByte(string(b), 0); n != -1 {
+		b = b[:n]
+	}
+	return string(b)
+}
+
+func (r *dfreader) add_format(p []byte) {
+	f := &dfformat{length: int(p[1]), timeidx: -1, instidx: -1}
+	f.name = df_string(p[2:6])
+	f.format = df_string(p[6:22])
+	cols := df_string(p[22:86])
+	if cols != "" {
+		f.columns = strings.Split(cols, ",")
+	}
+	for j, c := range f.columns {
+		switch c {
+		case "TimeUS":
+			f.timeidx = j
+		case "I", "Inst", "Instance":
+			f.instidx = j
+		}
+	}
+	for j := 0; j < len(f.format); j++ {
+		if df_size(f.format[j]) == 0 {
+			// the offsets of any following fields are unknown
+			fmt.Fprintf(os.Stderr, "%s: unknown field type '%c' in %s, messages ignored\n",
+				r.name, f.format[j], f.name)
+			f.skip = true
+			break
+		}
+	}
+	r.fmts[p[0]] = f
+}
+
+func df_size(c byte) int {
+	switch c {
+	case 'b', 'B', 'M':
+		return 1
+	case 'h', 'H', 'c', 'C':
+		return 2
+	case 'i', 'I', 'f', 'e', 'E', 'L', 'n':
+		return 4
+	case 'd', 'q', 'Q':
+		return 8
+	case 'N':
+		return 16
+	case 'Z', 'a':
+		return 64
+	}
+	return 0
+}
+
+// Decodes the numeric payload fields, with the same scaling as pymavlink;
+// string and array fields are returned as 0
+func (r *dfreader) decode(f *dfformat, p []byte) []float64 {
+	r.vals = r.vals[:0]
+	off := 0
+	for j := 0; j < len(f.format); j++ {
+		c := f.format[j]
+		sz := df_size(c)
+		if off+sz > len(p) {
+			break
+		}
+		b := p[off:]
+		v := 0.0
+		switch c {
+		case 'b':
+			v = float64(int8(b[0]))
+		case 'B', 'M':
+			v = float64(b[0])
+		case 'h':
+			v = float64(int16(binary.LittleEndian.Uint16(b)))
+		case 'H':
+			v = float64(binary.LittleEndian.Uint16(b))
+		case 'c':
+			v = float64(int16(binary.LittleEndian.Uint16(b))) / 100.0
+		case 'C':
+			v = float64(binary.LittleEndian.Uint16(b)) / 100.0
+		case 'i':
+			v = float64(int32(binary.LittleEndian.Uint32(b)))
+		case 'I':
+			v = float64(binary.LittleEndian.Uint32(b))
+		case 'e':
+			v = float64(int32(binary.LittleEndian.Uint32(b))) / 100.0
+		case 'E':
+			v = float64(binary.LittleEndian.Uint32(b)) / 100.0
+		case 'L':
+			v = float64(int32(binary.LittleEndian.Uint32(b))) / 1e7
+		case 'f':
+			v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case 'd':
+			v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case 'q':
+			v = float64(int64(binary.LittleEndian.Uint64(b)))
+		case 'Q':
+			v = float64(binary.LittleEndian.Uint64(b))
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			v = 0
+		}
+		r.vals = append(r.vals, v)
+		off += sz
+	}
+	return r.vals
+}
+
+// Returns the next message's type, format and decoded values, io.EOF at
+// the end. FMT messages are consumed internally.
+func (r *dfreader) next() (byte, *dfformat, []float64, error) {
+	for {
+		c, err := r.rd.ReadByte()
+		if err != nil {
+			return 0, nil, nil, io.EOF
+		}
+		if c != DF_HEAD1 {
+			continue
+		}
+		if c, err = r.rd.ReadByte(); err != nil {
+			return 0, nil, nil, io.EOF
+		}
+		if c != DF_HEAD2 {
+			r.rd.UnreadByte()
+			continue
+		}
+		mtype, err := r.rd.ReadByte()
+		if err != nil {
+			return 0, nil, nil, io.EOF
+		}
+		f, ok := r.fmts[mtype]
+		if !ok || f.length < 3 {
+			// unknown message, resynchronise on the next header
+			continue
+		}
+		if cap(r.buf) < f.length {
+			r.buf = make([]byte, f.length)
+		}
+		p := r.buf[:f.length-3]
+		if _, err = io.ReadFull(r.rd, p); err != nil {
+			return 0, nil, nil, io.EOF
+		}
+		if mtype == DF_FMT {
+			r.add_format(p)
+			continue
+		}
+		if f.skip {
+			continue
+		}
+		return mtype, f, r.decode(f, p), nil
+	}
+}
+
+// The string field col of the last message returned by next()
+func (r *dfreader) text(f *dfformat, col string) string {
+	off := 0
+	for j := 0; j < len(f.format) && j < len(f.columns); j++ {
+		sz := df_size(f.format[j])
+		if f.columns[j] == col {
+			if off+sz > f.length-3 {
+				break
+			}
+			return df_string(r.buf[off : off+sz])
+		}
+		off += sz
+	}
+	return ""
+}
+
+func (f *dfformat) value(vals []float64, idx int) float64 {
+	if idx >= 0 && idx < len(vals) {
+		return vals[idx]
+	}
+	return 0
+}
+
+// Primary instance only for multi-instance messages (GPS, BAT etc.)
+func (f *dfformat) primary(vals []float64) bool {
+	return f.instidx == -1 || f.value(vals, f.instidx) == 0
+}
+
+func (f *dfformat) time_us(vals []float64) (uint64, bool) {
+	if f.timeidx == -1 {
+		return 0, false
+	}
+	return uint64(f.value(vals, f.timeidx)), true
+}
+
+// Maintains the TimeUS to UTC offset from GPS week / milliseconds
+func (r *dfreader) update_clock(g MavGPS) {
+	if g.GWk > 0 {
+		utc := gps_epoch.Add(time.Duration(g.GWk) * 7 * 24 * time.Hour).Add(time.Duration(g.Gms) * time.Millisecond)
+		r.offset = utc.Sub(time.Unix(0, 0).Add(time.Duration(g.TimeUS) * time.Microsecond))
+		r.hasoffs = true
+	}
+}
+
+func (r *dfreader) stamp(us uint64) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(us) * time.Microsecond).Add(r.offset)
+}
+
+// Fills a Mav* struct from the message values, matching columns to the
+// (case insensitive) json tags, as the previous JSON decoding did.
+func (r *dfreader) fill(dst interface{}, mtype byte, f *dfformat, vals []float64) {
+	rv := reflect.ValueOf(dst).Elem()
+	rt := rv.Type()
+	key := dfkey{mtype, rt}
+	idx, ok := r.fmap[key]
+	if !ok {
+		idx = make([]int, len(f.columns))
+		for j, c := range f.columns {
+			idx[j] = -1
+			for k := 0; k < rt.NumField(); k++ {
+				if strings.EqualFold(rt.Field(k).Tag.Get("json"), c) {
+					idx[j] = k
+					break
+				}
+			}
+		}
+		r.fmap[key] = idx
+	}
+	for j, k := range idx {
+		if k != -1 && j < len(vals) {
+			fv := rv.Field(k)
+			switch fv.Kind() {
+			case reflect.Int64:
+				fv.SetInt(int64(vals[j]))
+			case reflect.Float64:
+				fv.SetFloat(vals[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package aplog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * A synthetic DataFlash log: FMT messages for ATT, GPS, MODE and a
+ * message with an unknown field type, the messages themselves, a second
+ * GPS instance, a message of an undefined type and finally a truncated
+ * GPS message.
+ */
+
+const (
+	T_ATT  = 10
+	T_GPS  = 11
+	T_MODE = 12
+	T_XXX  = 13
+	T_NONE = 0x55 // no FMT
+)
+
+type dfenc struct {
+	bytes.Buffer
+}
+
+func (e *dfenc) head(mtype byte) {
+	e.Write([]byte{DF_HEAD1, DF_HEAD2, mtype})
+}
+
+func (e *dfenc) fixed(s string, n int) {
+	b := make([]byte, n)
+	copy(b, s)
+	e.Write(b)
+}
+
+func (e *dfenc) fmt(mtype byte, length int, name, format, cols string) {
+	e.head(DF_FMT)
+	e.WriteByte(mtype)
+	e.WriteByte(byte(length))
+	e.fixed(name, 4)
+	e.fixed(format, 16)
+	e.fixed(cols, 64)
+}
+
+func (e *dfenc) put(vals ...interface{}) {
+	for _, v := range vals {
+		binary.Write(&e.Buffer, binary.LittleEndian, v)
+	}
+}
+
+func (e *dfenc) gps(inst uint8, us uint64, lat, lon float64) {
+	e.head(T_GPS)
+	e.put(us, inst, uint8(6), uint32(388800000), uint16(2300), uint8(14), int16(85),
+		int32(math.Round(lat*1e7)), int32(math.Round(lon*1e7)), int32(12345),
+		float32(12.5), float32(270), float32(-1.5), float32(0), uint8(1))
+}
+
+func df_log() []byte {
+	e := &dfenc{}
+	e.fmt(DF_FMT, DF_FMT_LEN, "FMT", "BBnNZ", "Type,Length,Name,Format,Columns")
+	e.fmt(T_ATT, 3+8+4*2+2*2+2*4, "ATT", "QccccCCff", "TimeUS,DesRoll,Roll,DesPitch,Pitch,DesYaw,Yaw,ErrRP,ErrYaw")
+	e.fmt(T_GPS, 3+48, "GPS", "QBBIHBcLLeffffB", "TimeUS,I,Status,GMS,GWk,NSats,HDop,Lat,Lng,Alt,Spd,GCrs,VZ,Yaw,U")
+	e.fmt(T_MODE, 3+11, "MODE", "QMBB", "TimeUS,Mode,ModeNum,Rsn")
+	e.fmt(T_XXX, 3+9, "XXX", "QX", "TimeUS,Val")
+
+	e.head(T_ATT)
+	e.put(uint64(1000000), int16(-250), int16(-300), int16(510), int16(450), uint16(9000), uint16(18050),
+		float32(0.25), float32(0.5))
+	e.head(T_MODE)
+	e.put(uint64(1100000), uint8(11), uint8(11), uint8(2))
+	e.head(T_XXX)
+	e.put(uint64(1150000), uint8(0xff))
+	e.head(T_NONE)
+	e.Write([]byte{1, 2, 3, 4})
+	e.gps(0, 1200000, 51.5, -0.12)
+	e.gps(1, 1200000, 1, 2)
+	// truncated
+	e.head(T_GPS)
+	e.put(uint64(1300000), uint8(0))
+	return e.Bytes()
+}
+
+func TestDataFlash(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.bin")
+	if err := os.WriteFile(fn, df_log(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := new_dfreader(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var m MavRec
+	var names []string
+	for {
+		mtype, f, vals, err := r.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.name)
+		if !f.primary(vals) {
+			continue
+		}
+		switch f.name {
+		case "ATT":
+			r.fill(&m.a, mtype, f, vals)
+		case "MODE":
+			r.fill(&m.m, mtype, f, vals)
+		case "GPS":
+			r.fill(&m.g, mtype, f, vals)
+			r.update_clock(m.g)
+		}
+	}
+
+	// XXX (unknown field type), the undefined type and the truncated GPS
+	// are dropped; both GPS instances are returned
+	want := []string{"ATT", "MODE", "GPS", "GPS"}
+	if len(names) != len(want) {
+		t.Fatalf("messages %q, want %q", names, want)
+	}
+	for j := range want {
+		if names[j] != want[j] {
+			t.Fatalf("messages %q, want %q", names, want)
+		}
+	}
+
+	if a := (MavAtt{DesRoll: -2, Roll: -3, DesPitch: 5, Pitch: 4.5, DesYaw: 90, Yaw: 180.5,
+		ErrRP: 0.25, ErrYaw: 0.5, TimeUS: 1000000}); m.a != a {
+		t.Errorf("ATT %+v, want %+v", m.a, a)
+	}
+	if md := (MavMode{Mode: 11, ModeNum: 11, Rsn: 2, TimeUS: 1100000}); m.m != md {
+		t.Errorf("MODE %+v, want %+v", m.m, md)
+	}
+	g := m.g
+	if g.TimeUS != 1200000 || g.Status != 6 || g.Gms != 388800000 || g.GWk != 2300 || g.NSats != 14 ||
+		g.HDop != 0.85 || math.Abs(g.Lat-51.5) > 1e-7 || math.Abs(g.Lng+0.12) > 1e-7 ||
+		g.Alt != 123.45 || g.Spd != 12.5 || g.GCrs != 270 || g.Vz != -1.5 || g.U != 1 {
+		t.Errorf("GPS %+v", g)
+	}
+
+	// GPS week 2300 (2024-02-04), 388800000 ms, less the leap seconds
+	if !r.hasoffs {
+		t.Fatal("no GPS clock")
+	}
+	if ts := r.stamp(1200000).Format("2006-01-02T15:04:05.000Z07:00"); ts != "2024-02-08T11:59:42.000Z" {
+		t.Errorf("GPS time %s", ts)
+	}
+}
@@ -0,0 +1,682 @@
+package bbl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Native decoder for INAV blackbox logs.
+ * Presents the same records (and header names) as
+ * `blackbox_decode --datetime --merge-gps --stdout`, so the rest of the
+ * package doesn't care which decoder is in use.
+ */
+
+var bbl_product = []byte("H Product:Blackbox")
+
+// INAV flightModeFlags, stateFlags and failsafePhase names, as blackbox_decode
+var bbl_fmode_names = []string{"ANGLE", "HORIZON", "HEADINGHOLD", "NAVALTHOLD",
+	"NAVRTH", "NAVPOSHOLD", "HEADFREE", "NAVLAUNCH", "MANUAL", "FAILSAFE",
+	"AUTOTUNE", "NAVWP", "NAVCOURSEHOLD", "FLAPERON", "TURNASSIST", "TURTLE", "SOARING"}
+
+var bbl_state_names = []string{"GPS_FIX_HOME", "GPS_FIX", "CALIBRATE_MAG", "SMALL_ANGLE",
+	"FIXED_WING", "ANTI_WINDUP", "FLAPERON_AVAILABLE", "NAV_MOTOR_STOP_OR_IDLE",
+	"COMPASS_CALIBRATED", "ACCELEROMETER_CALIBRATED"}
+
+var bbl_fs_names = []string{"IDLE", "RX_LOSS_DETECTED", "RX_LOSS_IDLE", "RETURN_TO_HOME",
+	"LANDING", "LANDED", "RX_LOSS_MONITORING", "RX_LOSS_RECOVERED"}
+
+// Units appended to field names by blackbox_decode
+var bbl_units = map[string]string{
+	"time":            " (us)",
+	"vbat":            " (V)",
+	"vbatLatest":      " (V)",
+	"amperage":        " (A)",
+	"amperageLatest":  " (A)",
+	"BaroAlt":         " (cm)",
+	"flightModeFlags": " (flags)",
+	"stateFlags":      " (flags)",
+	"failsafePhase":   " (flags)",
+	"GPS_speed":       " (m/s)",
+}
+
+var (
+	ErrBBLUnsupported = errors.New("bbl: log not supported by the native decoder")
+	ErrBBLNoSegment   = errors.New("bbl: log index not found")
+)
+
+type framedef struct {
+	names     []string
+	signed    []bool
+	predictor []int
+	encoding  []int
+}
+
+type bbldecoder struct {
+	s           bblstream
+	fdefs       map[byte]*framedef
+	minthrottle int64
+	vbatref     int64
+	motorout    [2]int64
+	i_interval  int64
+	p_num       int64
+	p_denom     int64
+	start       time.Time
+
+	main      [3][]int64
+	cur       []int64
+	mainvalid bool
+	gps       []int64
+	home      []int64
+	slow      []int64
+	havegps   bool
+	havehome  bool
+	haveslow  bool
+	last_iter int64
+	last_time int64
+
+	idx_iter   int
+	idx_time   int
+	idx_motor0 int
+	idx_amps   int
+	gidx_lon   int
+
+	hdr_done   bool
+	ended      bool
+	energy     float64
+	first_time int64
+	etime      int64
+}
+
+// Returns the byte offsets of each log segment in the file data
+func bbl_segments(data []byte) [][2]int {
+	var segs [][2]int
+	off := 0
+	for {
+		n := bytes.Index(data[off:], bbl_product)
+		if n == -1 {
+			break
+		}
+		if len(segs) > 0 {
+			segs[len(segs)-1][1] = off + n
+		}
+		segs = append(segs, [2]int{off + n, len(data)})
+		off += n + len(bbl_product)
+	}
+	return segs
+}
+
+// The file data and its segment offsets, read once and shared by the
+// decoders for each segment
+type bblfile struct {
+	data []byte
+	segs [][2]int
+}
+
+func read_bbl_file(fn string) (*bblfile, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &bblfile{data: data, segs: bbl_segments(data)}, nil
+}
+
+func new_bbl_decoder(f *bblfile, idx int) (*bbldecoder, error) {
+	if idx < 1 || idx > len(f.segs) {
+		return nil, ErrBBLNoSegment
+	}
+	d := &bbldecoder{fdefs: make(map[byte]*framedef), i_interval: 32, p_num: 1, p_denom: 1,
+		last_iter: -1, idx_iter: -1, idx_time: -1, idx_motor0: -1, idx_amps: -1, gidx_lon: -1}
+	d.s.data = f.data[f.segs[idx-1][0]:f.segs[idx-1][1]]
+	if err := d.parse_headers(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func split_ints(s string) []int {
+	var ia []int
+	for _, p := range strings.Split(s, ",") {
+		v, _ := strconv.Atoi(strings.TrimSpace(p))
+		ia = append(ia, v)
+	}
+	return ia
+}
+
+func (d *bbldecoder) fdef(ftype byte) *framedef {
+	fd, ok := d.fdefs[ftype]
+	if !ok {
+		fd = &framedef{}
+		d.fdefs[ftype] = fd
+	}
+	return fd
+}
+
+func (d *bbldecoder) parse_headers() error {
+	dvers := ""
+	fwrev := ""
+	for d.s.pos+1 < len(d.s.data) && d.s.data[d.s.pos] == 'H' && d.s.data[d.s.pos+1] == ' ' {
+		eol := bytes.IndexByte(d.s.data[d.s.pos:], '\n')
+		if eol == -1 {
+			return ErrBBLUnsupported
+		}
+		line := string(d.s.data[d.s.pos+2 : d.s.pos+eol])
+		d.s.pos += eol + 1
+		n := strings.Index(line, ":")
+		if n == -1 {
+			continue
+		}
+		key := line[:n]
+		val := line[n+1:]
+		switch {
+		case strings.HasPrefix(key, "Field ") && len(key) > 8:
+			fd := d.fdef(key[6])
+			switch key[8:] {
+			case "name":
+				fd.names = strings.Split(val, ",")
+			case "signed":
+				for _, v := range split_ints(val) {
+					fd.signed = append(fd.signed, v != 0)
+				}
+			case "predictor":
+				fd.predictor = split_ints(val)
+			case "encoding":
+				fd.encoding = split_ints(val)
+			}
+		case key == "Data version":
+			dvers = val
+		case key == "Firmware revision":
+			fwrev = val
+		case key == "I interval":
+			d.i_interval, _ = strconv.ParseInt(val, 10, 64)
+		case key == "P interval":
+			if parts := strings.Split(val, "/"); len(parts) == 2 {
+				d.p_num, _ = strconv.ParseInt(parts[0], 10, 64)
+				d.p_denom, _ = strconv.ParseInt(parts[1], 10, 64)
+			}
+		case key == "P ratio":
+			d.p_num = 1
+			d.p_denom, _ = strconv.ParseInt(val, 10, 64)
+		case key == "minthrottle":
+			d.minthrottle, _ = strconv.ParseInt(val, 10, 64)
+		case key == "vbatref":
+			d.vbatref, _ = strconv.ParseInt(val, 10, 64)
+		case key == "motorOutput":
+			for j, v := range split_ints(val) {
+				if j < 2 {
+					d.motorout[j] = int64(v)
+				}
+			}
+		case key == "Log start datetime":
+			t, err := time.Parse(time.RFC3339, val)
+			if err == nil && t.Year() > 2000 {
+				d.start = t
+			}
+		}
+	}
+
+	// Only INAV 2.0 and later logs have the units assumed here
+	if dvers != "2" || !strings.HasPrefix(fwrev, "INAV ") {
+		return ErrBBLUnsupported
+	}
+	if parts := strings.Split(fwrev, " "); len(parts) > 1 {
+		if major, err := strconv.Atoi(strings.Split(parts[1], ".")[0]); err != nil || major < 2 {
+			return ErrBBLUnsupported
+		}
+	}
+
+	if d.i_interval < 1 {
+		d.i_interval = 1
+	}
+	if d.p_num < 1 || d.p_denom < 1 {
+		d.p_num = 1
+		d.p_denom = 1
+	}
+
+	ifd, iok := d.fdefs['I']
+	pfd, pok := d.fdefs['P']
+	if !iok || !pok || len(ifd.names) == 0 {
+		return ErrBBLUnsupported
+	}
+	pfd.names = ifd.names
+	pfd.signed = ifd.signed
+	for ftype, fd := range d.fdefs {
+		n := len(fd.names)
+		if len(fd.predictor) != n || len(fd.encoding) != n {
+			return fmt.Errorf("bbl: inconsistent '%c' field definitions: %w", ftype, ErrBBLUnsupported)
+		}
+		for len(fd.signed) < n {
+			fd.signed = append(fd.signed, false)
+		}
+	}
+
+	for j, s := range ifd.names {
+		switch s {
+		case "loopIteration":
+			d.idx_iter = j
+		case "time":
+			d.idx_time = j
+		case "motor[0]":
+			d.idx_motor0 = j
+		case "amperage":
+			d.idx_amps = j
+		}
+	}
+	if d.idx_time == -1 {
+		return ErrBBLUnsupported
+	}
+	if gfd, ok := d.fdefs['G']; ok {
+		for j, s := range gfd.names {
+			if s == "GPS_coord[1]" {
+				d.gidx_lon = j
+			}
+		}
+		d.gps = make([]int64, len(gfd.names))
+	}
+	if hfd, ok := d.fdefs['H']; ok {
+		d.home = make([]int64, len(hfd.names))
+	}
+	if sfd, ok := d.fdefs['S']; ok {
+		d.slow = make([]int64, len(sfd.names))
+	}
+	for j := range d.main {
+		d.main[j] = make([]int64, len(ifd.names))
+	}
+	d.cur = make([]int64, len(ifd.names))
+	return nil
+}
+
+func (d *bbldecoder) predict(pred int, fi int, v int64, cur, prev, prev2 []int64) int64 {
+	// I frames have no second history frame
+	if prev2 == nil {
+		prev2 = prev
+	}
+	switch pred {
+	case PRED_PREVIOUS:
+		if prev != nil {
+			v += prev[fi]
+		}
+	case PRED_STRAIGHT_LINE:
+		if prev != nil {
+			v += 2*prev[fi] - prev2[fi]
+		}
+	case PRED_AVERAGE_2:
+		if prev != nil {
+			v += (prev[fi] + prev2[fi]) / 2
+		}
+	case PRED_MINTHROTTLE:
+		v += d.minthrottle
+	case PRED_MOTOR_0:
+		if d.idx_motor0 != -1 && d.idx_motor0 < len(cur) {
+			v += cur[d.idx_motor0]
+		}
+	case PRED_HOME_COORD:
+		if d.havehome && len(d.home) > 1 {
+			if fi == d.gidx_lon {
+				v += d.home[1]
+			} else {
+				v += d.home[0]
+			}
+		}
+	case PRED_1500:
+		v += 1500
+	case PRED_VBATREF:
+		v += d.vbatref
+	case PRED_LAST_MAIN_FRAME_TIME:
+		v += d.last_time
+	case PRED_MINMOTOR:
+		v += d.motorout[0]
+	}
+	return v
+}
+
+// Decodes one frame's worth of fields into cur; false if the encoding is unknown
+func (d *bbldecoder) parse_frame(fd *framedef, cur, prev, prev2 []int64, skipped int64) bool {
+	var values [8]int64
+	n := len(fd.names)
+	for i := 0; i < n; {
+		if fd.predictor[i] == PRED_INCREMENT {
+			cur[i] = skipped + 1
+			if prev != nil {
+				cur[i] += prev[i]
+			}
+			i++
+			continue
+		}
+		group := 1
+		switch fd.encoding[i] {
+		case ENC_SIGNED_VB:
+			values[0] = int64(d.s.read_signed_vb())
+		case ENC_UNSIGNED_VB:
+			values[0] = int64(d.s.read_unsigned_vb())
+		case ENC_NEG_14BIT:
+			values[0] = int64(d.s.read_neg_14bit())
+		case ENC_TAG8_4S16:
+			d.s.read_tag8_4s16(values[:])
+			group = 4
+		case ENC_TAG2_3S32:
+			d.s.read_tag2_3s32(values[:])
+			group = 3
+		case ENC_TAG2_3SVARIABLE:
+			d.s.read_tag2_3svariable(values[:])
+			group = 3
+		case ENC_TAG8_8SVB:
+			for group = 1; i+group < n && group < 8 && fd.encoding[i+group] == ENC_TAG8_8SVB; group++ {
+			}
+			d.s.read_tag8_8svb(values[:], group)
+		case ENC_NULL:
+			values[0] = 0
+		default:
+			return false
+		}
+		for j := 0; j < group && i < n; j++ {
+			cur[i] = d.predict(fd.predictor[i], i, values[j], cur, prev, prev2)
+			i++
+		}
+	}
+	return !d.s.eof
+}
+
+// A frame is only trusted if it is followed by another frame marker (or the end of data)
+func (d *bbldecoder) frame_end_ok() bool {
+	c, err := d.s.peek_byte()
+	if err != nil {
+		return true
+	}
+	switch c {
+	case 'I', 'P', 'G', 'H', 'S', 'E':
+		return true
+	}
+	return false
+}
+
+func (d *bbldecoder) should_have_frame(idx int64) bool {
+	return (idx%d.i_interval+d.p_num-1)%d.p_denom < d.p_num
+}
+
+func (d *bbldecoder) skipped_frames() int64 {
+	n := int64(0)
+	if d.last_iter != -1 {
+		for idx := d.last_iter + 1; !d.should_have_frame(idx) && n < d.i_interval; idx++ {
+			n++
+		}
+	}
+	return n
+}
+
+func (d *bbldecoder) parse_event() bool {
+	switch d.s.read_byte() {
+	case 0: // sync beep
+		d.s.read_unsigned_vb()
+	case 10: // autotune cycle start
+		d.s.pos += 5
+	case 11: // autotune cycle result
+		d.s.pos += 4
+	case 12: // autotune targets
+		d.s.pos += 8
+	case 13: // inflight adjustment
+		if d.s.read_byte()&0x80 != 0 {
+			d.s.pos += 4
+		} else {
+			d.s.read_signed_vb()
+		}
+	case 14: // logging resume
+		d.last_iter = int64(d.s.read_unsigned_vb())
+		d.last_time = int64(d.s.read_unsigned_vb())
+	case 30: // flight mode
+		d.s.read_unsigned_vb()
+		d.s.read_unsigned_vb()
+	case 255: // log end
+		if strings.HasPrefix(d.s.read_cstring(), "End of log") {
+			d.ended = true
+			return true
+		}
+		return false
+	default:
+		return false
+	}
+	if d.s.pos > len(d.s.data) {
+		d.s.eof = true
+	}
+	return !d.s.eof
+}
+
+// Advances to the next valid main (I or P) frame, merging in any
+// intervening GPS, home and slow frames. Returns false at end of log.
+func (d *bbldecoder) next_main() bool {
+	ifd := d.fdefs['I']
+	pfd := d.fdefs['P']
+	tmp := d.cur
+	for !d.ended {
+		start := d.s.pos
+		c, err := d.s.peek_byte()
+		if err != nil {
+			return false
+		}
+		d.s.pos++
+		ok := true
+		ismain := false
+		switch c {
+		case 'I':
+			ok = d.parse_frame(ifd, tmp, d.main[1], nil, 0) && d.frame_end_ok()
+			if ok {
+				copy(d.main[0], tmp)
+				copy(d.main[1], tmp)
+				copy(d.main[2], tmp)
+				d.mainvalid = true
+				ismain = true
+			}
+		case 'P':
+			ok = d.parse_frame(pfd, tmp, d.main[1], d.main[2], d.skipped_frames()) && d.frame_end_ok()
+			if ok && d.mainvalid {
+				copy(d.main[0], tmp)
+				copy(d.main[2], d.main[1])
+				copy(d.main[1], tmp)
+				ismain = true
+			}
+		case 'G', 'H', 'S':
+			fd, fok := d.fdefs[c]
+			if !fok {
+				ok = false
+				break
+			}
+			// no history, as blackbox_decode
+			vals := make([]int64, len(fd.names))
+			ok = d.parse_frame(fd, vals, nil, nil, 0) && d.frame_end_ok()
+			if ok {
+				switch c {
+				case 'G':
+					d.gps = vals
+					d.havegps = true
+				case 'H':
+					d.home = vals
+					d.havehome = true
+				case 'S':
+					d.slow = vals
+					d.haveslow = true
+				}
+			}
+		case 'E':
+			ok = d.parse_event() && (d.ended || d.frame_end_ok())
+		default:
+			ok = false
+		}
+		if !ok {
+			// corrupt data, resynchronise on the next plausible frame
+			d.s.eof = false
+			d.s.pos = start + 1
+			d.mainvalid = false
+			continue
+		}
+		if ismain {
+			if d.idx_iter != -1 {
+				d.last_iter = d.main[0][d.idx_iter]
+			}
+			t := d.main[0][d.idx_time]
+			if d.etime != 0 && d.idx_amps != -1 && t > d.last_time {
+				amps := float64(d.main[0][d.idx_amps]) / 100.0
+				d.energy += amps * float64(t-d.last_time) / 3.6e6
+			}
+			if d.etime == 0 {
+				d.first_time = t
+			}
+			d.etime = t
+			d.last_time = t
+			return true
+		}
+	}
+	return false
+}
+
+func flags_string(v int64, names []string) string {
+	if v == 0 {
+		return "0"
+	}
+	var sb strings.Builder
+	for j := 0; j < 32; j++ {
+		if v&(1<<j) != 0 {
+			if sb.Len() > 0 {
+				sb.WriteByte('|')
+			}
+			if j < len(names) {
+				sb.WriteString(names[j])
+			} else {
+				sb.WriteString(strconv.Itoa(j))
+			}
+		}
+	}
+	return sb.String()
+}
+
+func field_string(name string, v int64, signed bool) string {
+	switch name {
+	case "vbat", "vbatLatest", "amperage", "amperageLatest":
+		return fmt.Sprintf("%.3f", float64(v)/100.0)
+	case "flightModeFlags":
+		return flags_string(v, bbl_fmode_names)
+	case "stateFlags":
+		return flags_string(v, bbl_state_names)
+	case "failsafePhase":
+		if v >= 0 && int(v) < len(bbl_fs_names) {
+			return bbl_fs_names[v]
+		}
+	case "GPS_coord[0]", "GPS_coord[1]", "GPS_home[0]", "GPS_home[1]":
+		return fmt.Sprintf("%.7f", float64(v)/1e7)
+	case "GPS_speed":
+		return fmt.Sprintf("%.2f", float64(v)/100.0)
+	case "GPS_ground_course":
+		return fmt.Sprintf("%.1f", float64(v)/10.0)
+	}
+	if !signed {
+		v = int64(uint32(v))
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func (d *bbldecoder) header_record() []string {
+	var hdr []string
+	for _, s := range d.fdefs['I'].names {
+		hdr = append(hdr, s+bbl_units[s])
+	}
+	if d.idx_amps != -1 {
+		hdr = append(hdr, "energyCumulative (mAh)")
+	}
+	if fd, ok := d.fdefs['S']; ok {
+		for _, s := range fd.names {
+			hdr = append(hdr, s+bbl_units[s])
+		}
+	}
+	if fd, ok := d.fdefs['G']; ok {
+		for _, s := range fd.names {
+			if s != "time" {
+				hdr = append(hdr, s+bbl_units[s])
+			}
+		}
+	}
+	if _, ok := d.fdefs['H']; ok {
+		hdr = append(hdr, "GPS_home_lat", "GPS_home_lon")
+	}
+	hdr = append(hdr, "dateTime")
+	return hdr
+}
+
+func (d *bbldecoder) data_record() []string {
+	var rec []string
+	ifd := d.fdefs['I']
+	for j, s := range ifd.names {
+		rec = append(rec, field_string(s, d.main[0][j], ifd.signed[j]))
+	}
+	if d.idx_amps != -1 {
+		rec = append(rec, fmt.Sprintf("%.3f", d.energy))
+	}
+	if fd, ok := d.fdefs['S']; ok {
+		for j, s := range fd.names {
+			if d.haveslow {
+				rec = append(rec, field_string(s, d.slow[j], fd.signed[j]))
+			} else {
+				rec = append(rec, "")
+			}
+		}
+	}
+	if fd, ok := d.fdefs['G']; ok {
+		for j, s := range fd.names {
+			if s == "time" {
+				continue
+			}
+			if d.havegps {
+				rec = append(rec, field_string(s, d.gps[j], fd.signed[j]))
+			} else {
+				rec = append(rec, "")
+			}
+		}
+	}
+	if fd, ok := d.fdefs['H']; ok {
+		if d.havehome && len(fd.names) > 1 {
+			rec = append(rec, field_string("GPS_home[0]", d.home[0], true),
+				field_string("GPS_home[1]", d.home[1], true))
+		} else {
+			rec = append(rec, "", "")
+		}
+	}
+	if !d.start.IsZero() {
+		ts := d.start.Add(time.Duration(d.etime-d.first_time) * time.Microsecond)
+		rec = append(rec, ts.Format(time.RFC3339Nano))
+	} else {
+		rec = append(rec, "")
+	}
+	return rec
+}
+
+// Read returns the header names, then one record per main frame, as the
+// CSV output of blackbox_decode
+func (d *bbldecoder) Read() ([]string, error) {
+	if !d.hdr_done {
+		d.hdr_done = true
+		return d.header_record(), nil
+	}
+	if d.next_main() {
+		return d.data_record(), nil
+	}
+	return nil, io.EOF
+}
+
+func (d *bbldecoder) Close() {
+	d.s.data = nil
+}
+
+func native_duration(f *bblfile, idx int) (time.Duration, error) {
+	d, err := new_bbl_decoder(f, idx)
+	if err != nil {
+		return 0, err
+	}
+	defer d.Close()
+	for d.next_main() {
+	}
+	return time.Duration(d.etime-d.first_time) * time.Microsecond, nil
+}
@@ -0,0 +1,496 @@
+package bbl
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+ * Golden file tests of the native decoder. Each testdata/*.TXT (or .bbl)
+ * INAV log is decoded and compared, column by column, with the CSV of
+ * `blackbox_decode --datetime --merge-gps --stdout --index 1`, from the
+ * .csv of the same name, or from blackbox_decode itself if that is
+ * installed and there is no .csv.
+ */
+
+func read_csv(t *testing.T, r io.Reader) [][]string {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	recs, err := cr.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func golden_records(t *testing.T, fn string) [][]string {
+	gfn := strings.TrimSuffix(fn, filepath.Ext(fn)) + ".csv"
+	if fh, err := os.Open(gfn); err == nil {
+		defer fh.Close()
+		return read_csv(t, fh)
+	}
+	if _, err := exec.LookPath("blackbox_decode"); err != nil {
+		t.Fatalf("%s: no golden CSV and no blackbox_decode", fn)
+	}
+	out, err := exec.Command("blackbox_decode", "--datetime", "--merge-gps", "--stdout",
+		"--index", "1", fn).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read_csv(t, strings.NewReader(string(out)))
+}
+
+// All the records (including the header) from the decoder
+func decode_all(t *testing.T, d *bbldecoder) [][]string {
+	var got [][]string
+	for {
+		rec, err := d.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec)
+	}
+	return got
+}
+
+func compare_records(t *testing.T, got, want [][]string) {
+	if len(got) != len(want) {
+		t.Fatalf("%d records, want %d", len(got), len(want))
+	}
+	for j := range want {
+		if len(got[j]) != len(want[j]) {
+			t.Fatalf("record %d: %d columns, want %d\n got %q\nwant %q", j, len(got[j]), len(want[j]), got[j], want[j])
+		}
+		for k := range want[j] {
+			if got[j][k] != want[j][k] {
+				t.Errorf("record %d, %s: %q, want %q", j, want[0][k], got[j][k], want[j][k])
+			}
+		}
+	}
+}
+
+func TestNativeGolden(t *testing.T) {
+	logs, _ := filepath.Glob(filepath.Join("testdata", "*.TXT"))
+	bbls, _ := filepath.Glob(filepath.Join("testdata", "*.bbl"))
+	logs = append(logs, bbls...)
+	if len(logs) == 0 {
+		t.Fatal("no sample logs in testdata")
+	}
+	for _, fn := range logs {
+		t.Run(filepath.Base(fn), func(t *testing.T) {
+			want := golden_records(t, fn)
+			bf, err := read_bbl_file(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := new_bbl_decoder(bf, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer d.Close()
+			compare_records(t, decode_all(t, d), want)
+		})
+	}
+}
+
+// An I frame field with a predictor using two history frames (as from an
+// unusual or corrupt header) falls back to the previous frame
+func TestPredictIFrame(t *testing.T) {
+	d := &bbldecoder{}
+	prev := []int64{10}
+	for _, pred := range []int{PRED_STRAIGHT_LINE, PRED_AVERAGE_2} {
+		if v := d.predict(pred, 0, 1, nil, prev, nil); v != 11 {
+			t.Errorf("predictor %d: %d, want 11", pred, v)
+		}
+	}
+}
+
+// The motor[0] predictor on a frame without a motor[0] field (as from a
+// corrupt header) is ignored, rather than indexing out of range
+func TestPredictMotor0(t *testing.T) {
+	d := &bbldecoder{idx_motor0: 12}
+	if v := d.predict(PRED_MOTOR_0, 0, 5, []int64{0, 0}, nil, nil); v != 5 {
+		t.Errorf("motor[0] predictor: %d, want 5", v)
+	}
+}
+
+// Firmware style field encoders (blackbox_io.c), for building test logs
+type bblenc struct {
+	bytes.Buffer
+}
+
+func (e *bblenc) uvb(v uint32) {
+	for v >= 0x80 {
+		e.WriteByte(byte(v | 0x80))
+		v >>= 7
+	}
+	e.WriteByte(byte(v))
+}
+
+func (e *bblenc) svb(v int32) {
+	e.uvb(uint32((v << 1) ^ (v >> 31)))
+}
+
+func (e *bblenc) neg14(v int32) {
+	e.uvb(uint32(-v) & 0x3fff)
+}
+
+func in_range(v int32, bits uint) bool {
+	return v >= -(1<<(bits-1)) && v < 1<<(bits-1)
+}
+
+func absmax(vals []int32) int32 {
+	m := int32(0)
+	for _, v := range vals {
+		if v < 0 {
+			v = -v
+		}
+		if v > m {
+			m = v
+		}
+	}
+	return m
+}
+
+func (e *bblenc) tag2_2bit(v [3]int32) {
+	e.WriteByte(byte(v[0]&3)<<4 | byte(v[1]&3)<<2 | byte(v[2]&3))
+}
+
+func (e *bblenc) tag2_32bit(v [3]int32) {
+	var sizes [3]int
+	lead := byte(3 << 6)
+	for i, x := range v {
+		switch {
+		case in_range(x, 8):
+			sizes[i] = 0
+		case in_range(x, 16):
+			sizes[i] = 1
+		case in_range(x, 24):
+			sizes[i] = 2
+		default:
+			sizes[i] = 3
+		}
+		lead |= byte(sizes[i] << (2 * i))
+	}
+	e.WriteByte(lead)
+	for i, x := range v {
+		for j := 0; j <= sizes[i]; j++ {
+			e.WriteByte(byte(x >> (8 * j)))
+		}
+	}
+}
+
+func (e *bblenc) tag2_3s32(v [3]int32) {
+	switch m := absmax(v[:]); {
+	case m <= 1:
+		e.tag2_2bit(v)
+	case m <= 7:
+		e.WriteByte(1<<6 | byte(v[0]&0x0f))
+		e.WriteByte(byte(v[1]&0x0f)<<4 | byte(v[2]&0x0f))
+	case m <= 31:
+		e.WriteByte(2<<6 | byte(v[0]&0x3f))
+		e.WriteByte(byte(v[1] & 0x3f))
+		e.WriteByte(byte(v[2] & 0x3f))
+	default:
+		e.tag2_32bit(v)
+	}
+}
+
+func (e *bblenc) tag2_3svariable(v [3]int32) {
+	switch {
+	case absmax(v[:]) <= 1:
+		e.tag2_2bit(v)
+	case in_range(v[0], 5) && in_range(v[1], 5) && in_range(v[2], 4):
+		e.WriteByte(1<<6 | byte(v[0]&0x1f)<<1 | byte(v[1]&0x1f)>>4)
+		e.WriteByte(byte(v[1]&0x0f)<<4 | byte(v[2]&0x0f))
+	case in_range(v[0], 8) && in_range(v[1], 7) && in_range(v[2], 7):
+		e.WriteByte(2<<6 | byte(v[0])>>2)
+		e.WriteByte(byte(v[0]&3)<<6 | byte(v[1]&0x7f)>>1)
+		e.WriteByte(byte(v[1]&1)<<7 | byte(v[2]&0x7f))
+	default:
+		e.tag2_32bit(v)
+	}
+}
+
+func (e *bblenc) tag8_4s16(v [4]int32) {
+	var sizes [4]int
+	var sel byte
+	for i, x := range v {
+		switch {
+		case x == 0:
+			sizes[i] = 0
+		case in_range(x, 4):
+			sizes[i] = 1
+		case in_range(x, 8):
+			sizes[i] = 2
+		default:
+			sizes[i] = 3
+		}
+		sel |= byte(sizes[i] << (2 * i))
+	}
+	e.WriteByte(sel)
+	nibble := false
+	var buf byte
+	for i, x := range v {
+		switch sizes[i] {
+		case 1:
+			if !nibble {
+				buf = byte(x) << 4
+			} else {
+				e.WriteByte(buf | byte(x)&0x0f)
+			}
+			nibble = !nibble
+		case 2:
+			if !nibble {
+				e.WriteByte(byte(x))
+			} else {
+				e.WriteByte(buf | byte(x)>>4)
+				buf = byte(x) << 4
+			}
+		case 3:
+			if !nibble {
+				e.WriteByte(byte(x >> 8))
+				e.WriteByte(byte(x))
+			} else {
+				e.WriteByte(buf | byte(x>>12)&0x0f)
+				e.WriteByte(byte(x >> 4))
+				buf = byte(x) << 4
+			}
+		}
+	}
+	if nibble {
+		e.WriteByte(buf)
+	}
+}
+
+func (e *bblenc) tag8_8svb(v ...int32) {
+	if len(v) == 1 {
+		e.svb(v[0])
+		return
+	}
+	var hdr byte
+	for i, x := range v {
+		if x != 0 {
+			hdr |= 1 << i
+		}
+	}
+	e.WriteByte(hdr)
+	for _, x := range v {
+		if x != 0 {
+			e.svb(x)
+		}
+	}
+}
+
+// INAV style field definitions: every encoding and most predictors, with a
+// P frame every second loop iteration
+const synth_headers = `H Product:Blackbox flight data recorder by Nicholas Sherlock
+H Data version:2
+H I interval:8
+H P interval:1/2
+H Firmware type:Cleanflight
+H Firmware revision:INAV 7.1.0 (e7ab2f8c) MATEKF405
+H Log start datetime:2024-05-01T10:20:30.000Z
+H Field I name:loopIteration,time,axisP[0],axisP[1],axisP[2],gyroADC[0],gyroADC[1],gyroADC[2],accSmooth[0],attitude[0],attitude[1],attitude[2],motor[0],motor[1],vbat,amperage
+H Field I signed:0,0,1,1,1,1,1,1,1,1,1,1,0,0,0,1
+H Field I predictor:0,0,0,0,0,0,0,0,0,0,0,0,11,5,9,0
+H Field I encoding:1,1,0,0,0,0,0,0,0,0,0,0,1,0,3,0
+H Field P predictor:6,2,1,1,1,3,3,3,1,1,1,1,1,1,1,1
+H Field P encoding:9,0,7,7,7,8,8,8,8,10,10,10,6,6,6,6
+H Field S name:flightModeFlags,stateFlags,failsafePhase
+H Field S signed:0,0,0
+H Field S predictor:0,0,0
+H Field S encoding:1,1,1
+H Field G name:time,GPS_numSat,GPS_coord[0],GPS_coord[1],GPS_altitude,GPS_speed,GPS_ground_course
+H Field G signed:0,0,1,1,1,0,0
+H Field G predictor:10,0,7,7,0,0,0
+H Field G encoding:1,1,0,0,0,1,1
+H Field H name:GPS_home[0],GPS_home[1]
+H Field H signed:1,1
+H Field H predictor:0,0
+H Field H encoding:0,0
+H vbatref:1650
+H motorOutput:1000,2000
+`
+
+// The frames of the synthetic log. The comments give the decoded values;
+// the encoded values are the residuals from the predictors.
+func synth_log() []byte {
+	e := &bblenc{}
+	e.WriteString(synth_headers)
+	e.WriteByte('E') // sync beep
+	e.WriteByte(0)
+	e.uvb(1000)
+	e.WriteByte('H') // 51.5, -0.12
+	e.svb(515000000)
+	e.svb(-1200000)
+	e.WriteByte('S') // ANGLE, GPS_FIX_HOME|GPS_FIX, IDLE
+	e.uvb(1)
+	e.uvb(3)
+	e.uvb(0)
+
+	// iteration 0
+	e.WriteByte('I')
+	e.uvb(0)
+	e.uvb(1000000)
+	for _, v := range []int32{10, -5, 3, 100, -200, 50, 4096, 15, -20, 900} {
+		e.svb(v)
+	}
+	e.uvb(200) // motor[0] 1200
+	e.svb(10)  // motor[1] 1210
+	e.neg14(-30)
+	e.svb(1500)
+
+	e.WriteByte('G') // after the I frame time, 12 sats, home + 100, 105m, 2.5m/s, 180°
+	e.uvb(500)
+	e.uvb(12)
+	e.svb(100)
+	e.svb(100)
+	e.svb(105)
+	e.uvb(250)
+	e.uvb(1800)
+
+	// iteration 2: axisP 11,-6,3; gyro 103,-207,52; acc 4090; attitude 25,-32,905;
+	// motors 1250,1240; vbat 1619; amperage 1520
+	e.WriteByte('P')
+	e.svb(2000)
+	e.tag2_3s32([3]int32{1, -1, 0})
+	e.tag8_4s16([4]int32{3, -7, 2, -6})
+	e.tag2_3svariable([3]int32{10, -12, 5})
+	e.tag8_8svb(50, 30, -1, 20)
+
+	// iteration 4: axisP 16,-9,10; gyro 96,-103,-949; acc 4090; attitude -75,18,845;
+	// motors 1250,1245; vbat 1619; amperage 1517
+	e.WriteByte('P')
+	e.svb(0)
+	e.tag2_3s32([3]int32{5, -3, 7})
+	e.tag8_4s16([4]int32{-5, 100, -1000, 0})
+	e.tag2_3svariable([3]int32{-100, 50, -60})
+	e.tag8_8svb(0, 5, 0, -3)
+
+	e.WriteByte('E') // flight mode change
+	e.WriteByte(30)
+	e.uvb(17)
+	e.uvb(1)
+
+	// Junk and a corrupt P frame; the P frame after it is ignored, as
+	// there is no valid history until the next I frame
+	e.Write([]byte{0x01, 0x02})
+	e.Write([]byte{'P', 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09})
+	e.Write([]byte{'P', 0x00, 0x00, 0x00, 0x00, 0x00})
+
+	e.WriteByte('G') // 14 sats, home + 1000, 120m, 12.34m/s, 90.5°
+	e.uvb(100)
+	e.uvb(14)
+	e.svb(1000)
+	e.svb(1000)
+	e.svb(120)
+	e.uvb(1234)
+	e.uvb(905)
+
+	// iteration 8
+	e.WriteByte('I')
+	e.uvb(8)
+	e.uvb(1008000)
+	for _, v := range []int32{20, -10, 12, 90, -100, -940, 4100, -70, 20, 850} {
+		e.svb(v)
+	}
+	e.uvb(300) // motor[0] 1300
+	e.svb(-10) // motor[1] 1290
+	e.neg14(-35)
+	e.svb(1650)
+
+	e.WriteByte('S') // ANGLE|NAVRTH, GPS_FIX_HOME|GPS_FIX, RETURN_TO_HOME
+	e.uvb(17)
+	e.uvb(3)
+	e.uvb(3)
+
+	// iteration 10: axisP 40,-30,43; gyro 93,-100,-942; acc 4400;
+	// attitude 930,18,70850; others unchanged
+	e.WriteByte('P')
+	e.svb(2000)
+	e.tag2_3s32([3]int32{20, -20, 31})
+	e.tag8_4s16([4]int32{3, 0, -2, 300})
+	e.tag2_3svariable([3]int32{1000, -2, 70000})
+	e.tag8_8svb(0, 0, 0, 0)
+
+	// iteration 12: axisP 140,-1030,20000043; gyro 91,-100,-941;
+	// attitude 931,18,70849; motor[0] 1250
+	e.WriteByte('P')
+	e.svb(0)
+	e.tag2_3s32([3]int32{100, -1000, 20000000})
+	e.tag8_4s16([4]int32{0, 0, 0, 0})
+	e.tag2_3svariable([3]int32{1, 0, -1})
+	e.tag8_8svb(-50, 0, 0, 0)
+
+	e.WriteByte('E')
+	e.WriteByte(255)
+	e.WriteString("End of log\x00")
+	return e.Bytes()
+}
+
+var synth_records = [][]string{
+	{"loopIteration", "time (us)", "axisP[0]", "axisP[1]", "axisP[2]", "gyroADC[0]", "gyroADC[1]",
+		"gyroADC[2]", "accSmooth[0]", "attitude[0]", "attitude[1]", "attitude[2]", "motor[0]",
+		"motor[1]", "vbat (V)", "amperage (A)", "energyCumulative (mAh)", "flightModeFlags (flags)",
+		"stateFlags (flags)", "failsafePhase (flags)", "GPS_numSat", "GPS_coord[0]", "GPS_coord[1]",
+		"GPS_altitude", "GPS_speed (m/s)", "GPS_ground_course", "GPS_home_lat", "GPS_home_lon", "dateTime"},
+	{"0", "1000000", "10", "-5", "3", "100", "-200", "50", "4096", "15", "-20", "900", "1200", "1210",
+		"16.200", "15.000", "0.000", "ANGLE", "GPS_FIX_HOME|GPS_FIX", "IDLE", "", "", "", "", "", "",
+		"51.5000000", "-0.1200000", "2024-05-01T10:20:30Z"},
+	{"2", "1002000", "11", "-6", "3", "103", "-207", "52", "4090", "25", "-32", "905", "1250", "1240",
+		"16.190", "15.200", "0.008", "ANGLE", "GPS_FIX_HOME|GPS_FIX", "IDLE", "12", "51.5000100",
+		"-0.1199900", "105", "2.50", "180.0", "51.5000000", "-0.1200000", "2024-05-01T10:20:30.002Z"},
+	{"4", "1004000", "16", "-9", "10", "96", "-103", "-949", "4090", "-75", "18", "845", "1250", "1245",
+		"16.190", "15.170", "0.017", "ANGLE", "GPS_FIX_HOME|GPS_FIX", "IDLE", "12", "51.5000100",
+		"-0.1199900", "105", "2.50", "180.0", "51.5000000", "-0.1200000", "2024-05-01T10:20:30.004Z"},
+	{"8", "1008000", "20", "-10", "12", "90", "-100", "-940", "4100", "-70", "20", "850", "1300", "1290",
+		"16.150", "16.500", "0.035", "ANGLE", "GPS_FIX_HOME|GPS_FIX", "IDLE", "14", "51.5001000",
+		"-0.1199000", "120", "12.34", "90.5", "51.5000000", "-0.1200000", "2024-05-01T10:20:30.008Z"},
+	{"10", "1010000", "40", "-30", "43", "93", "-100", "-942", "4400", "930", "18", "70850", "1300", "1290",
+		"16.150", "16.500", "0.044", "ANGLE|NAVRTH", "GPS_FIX_HOME|GPS_FIX", "RETURN_TO_HOME", "14",
+		"51.5001000", "-0.1199000", "120", "12.34", "90.5", "51.5000000", "-0.1200000",
+		"2024-05-01T10:20:30.01Z"},
+	{"12", "1012000", "140", "-1030", "20000043", "91", "-100", "-941", "4400", "931", "18", "70849",
+		"1250", "1290", "16.150", "16.500", "0.054", "ANGLE|NAVRTH", "GPS_FIX_HOME|GPS_FIX",
+		"RETURN_TO_HOME", "14", "51.5001000", "-0.1199000", "120", "12.34", "90.5", "51.5000000",
+		"-0.1200000", "2024-05-01T10:20:30.012Z"},
+}
+
+// A synthetic log (twice, as two segments), covering the field encodings,
+// G/H/S/E frames, merged GPS, the P frame ratio and resynchronisation
+// after corrupt data
+func TestNativeSynthetic(t *testing.T) {
+	log := synth_log()
+	fn := filepath.Join(t.TempDir(), "synth.TXT")
+	if err := os.WriteFile(fn, append(log, log...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	bf, err := read_bbl_file(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx := 1; idx <= 2; idx++ {
+		d, err := new_bbl_decoder(bf, idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare_records(t, decode_all(t, d), synth_records)
+		d.Close()
+	}
+	if _, err := new_bbl_decoder(bf, 3); err != ErrBBLNoSegment {
+		t.Errorf("index 3: %v, want %v", err, ErrBBLNoSegment)
+	}
+	if dur, err := native_duration(bf, 1); err != nil || dur.Microseconds() != 12000 {
+		t.Errorf("duration %v (%v), want 12ms", dur, err)
+	}
+}
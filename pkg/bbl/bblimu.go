@@ -0,0 +1,87 @@
+package bbl
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+import (
+	"types"
+)
+
+var imu_keys = [...]string{"gyroADC[0]", "gyroADC[1]", "gyroADC[2]", "accSmooth[0]", "accSmooth[1]", "accSmooth[2]"}
+
+// Full rate (i.e. not decimated to -interval) gyro and accelerometer
+// samples, for vibration analysis.
+func (lg *BBLOG) IMUSamples(meta types.FlightMeta) ([]types.IMUSample, error) {
+	r, err := open_bbl(lg, meta.Index)
+	if err != nil {
+		return nil, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, err)
+	}
+	defer r.Close()
+
+	acc1g := float64(meta.Acc1G)
+	if acc1g == 0 {
+		acc1g = 4096
+	}
+
+	var bs bblsession
+	var idx [len(imu_keys)]int
+	var samples []types.IMUSample
+	for i := 0; ; i++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if i == 0 {
+			if err == nil {
+				bs.hdrs, err = build_headers(record)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, err)
+			}
+			for j, k := range imu_keys {
+				n, ok := bs.hdrs[k]
+				if !ok {
+					return nil, fmt.Errorf("%s / %d: no \"%s\" field", lg.name, meta.Index, k)
+				}
+				idx[j] = n
+			}
+			continue
+		}
+		if err != nil {
+			// Keep what we have
+			break
+		}
+		var s types.IMUSample
+		if v, ok := bs.get_rec_value(record, "time (us)"); ok {
+			s.Stamp, _ = strconv.ParseUint(v, 10, 64)
+		}
+		for j, n := range idx {
+			if n >= len(record) {
+				continue
+			}
+			v, _ := strconv.ParseFloat(record[n], 64)
+			if j < 3 {
+				s.Gyro[j] = v
+			} else {
+				s.Acc[j-3] = v / acc1g
+			}
+		}
+		if v, ok := bs.get_rec_value(record, "rcData[3]"); ok {
+			thr, _ := strconv.Atoi(v)
+			s.Throttle = (thr - 1000) / 10
+			if s.Throttle < 0 {
+				s.Throttle = 0
+			} else if s.Throttle > 100 {
+				s.Throttle = 100
+			}
+		}
+		samples = append(samples, s)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, types.ErrCorruptSegment)
+	}
+	return samples, nil
+}
@@ -2,6 +2,7 @@ package bbl
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -23,33 +24,68 @@ import (
 	"types"
 )
 
-var inav_vers int
-var hdrs map[string]int
-
 type BBLOG struct {
 	name string
 	meta []types.FlightMeta
+	cfg  *options.Configuration
+	bf   *bblfile
+}
+
+// Per-segment decoding state
+type bblsession struct {
+	hdrs      map[string]int
+	inav_vers int
 }
 
 func NewBBLReader(fn string) BBLOG {
 	var l BBLOG
 	l.name = fn
 	l.meta = nil
+	l.cfg = &options.Config
 	return l
 }
 
+func (o *BBLOG) SetConfig(cfg *options.Configuration) {
+	o.cfg = cfg
+}
+
+// Reads the file for the native decoder, once per BBLOG
+func (o *BBLOG) bbl_file() (*bblfile, error) {
+	if o.bf == nil {
+		bf, err := read_bbl_file(o.name)
+		if err != nil {
+			return nil, err
+		}
+		o.bf = bf
+	}
+	return o.bf, nil
+}
+
+func init() {
+	types.RegisterReader(types.LogReader{Ftype: types.IS_BBL, Name: "Blackbox",
+		Detect: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte("H Product:Blackbox"))
+		},
+		New: func(fn string) types.FlightLog {
+			l := NewBBLReader(fn)
+			return &l
+		}})
+}
+
 func (o *BBLOG) GetMetas() ([]types.FlightMeta, error) {
 	m, err := types.ReadMetaCache(o.name)
 	if err != nil {
-		m, err = metas(o.name)
-		types.WriteMetaCache(o.name, m)
+		m, err = metas(o)
+		if err == nil {
+			types.WriteMetaCache(o.name, m)
+		}
 	}
 	o.meta = m
 	return m, err
 }
 
 func (o *BBLOG) GetDurations() {
-	get_durations(o.name, o.meta)
+	get_durations(o)
 }
 
 func (o *BBLOG) LogType() byte {
@@ -57,40 +93,103 @@ func (o *BBLOG) LogType() byte {
 }
 
 func (o *BBLOG) Dump() {
-	get_headers(o.name)
-	dump_headers()
+	hdrs, err := get_headers(o)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", o.name, err)
+		return
+	}
+	dump_headers(hdrs)
+}
+
+// The record source is either the native decoder or blackbox_decode
+type bblsource interface {
+	Read() ([]string, error)
+	Close()
 }
 
-func get_headers(fn string) {
-	cmd := exec.Command(options.Config.Blackbox_decode,
-		"--datetime", "--merge-gps", "--stdout", "--index", "1", fn)
+type extdecoder struct {
+	cmd *exec.Cmd
+	out io.ReadCloser
+	r   *csv.Reader
+}
+
+func (e *extdecoder) Read() ([]string, error) {
+	return e.r.Read()
+}
+
+func (e *extdecoder) Close() {
+	e.out.Close()
+	e.cmd.Wait()
+}
+
+func open_external(fn string, idx int, cfg *options.Configuration) (*extdecoder, error) {
+	if _, err := exec.LookPath(cfg.Blackbox_decode); err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrDecoderMissing, err)
+	}
+	cmd := exec.Command(cfg.Blackbox_decode,
+		"--datetime", "--merge-gps", "--stdout", "--index", strconv.Itoa(idx), fn)
 	types.SetSilentProcess(cmd)
 	out, err := cmd.StdoutPipe()
-	defer cmd.Wait()
-	defer out.Close()
+	if err != nil {
+		return nil, err
+	}
 	r := csv.NewReader(out)
 	r.TrimLeadingSpace = true
-	err = cmd.Start()
+	if err = cmd.Start(); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &extdecoder{cmd: cmd, out: out, r: r}, nil
+}
+
+// Prefers the native decoder; blackbox_decode is used if requested, or as
+// a fallback for logs the native decoder can't handle (if it's installed).
+func open_bbl(o *BBLOG, idx int) (bblsource, error) {
+	cfg := o.cfg
+	if !cfg.BBLExternal {
+		bf, err := o.bbl_file()
+		if err != nil {
+			return nil, err
+		}
+		d, err := new_bbl_decoder(bf, idx)
+		if err == nil {
+			return d, nil
+		}
+		if !errors.Is(err, ErrBBLUnsupported) {
+			return nil, err
+		}
+		if _, xerr := exec.LookPath(cfg.Blackbox_decode); xerr != nil {
+			return nil, fmt.Errorf("%w (%s): %v", types.ErrDecoderMissing, cfg.Blackbox_decode, err)
+		}
+	}
+	return open_external(o.name, idx, cfg)
+}
+
+func get_headers(o *BBLOG) (map[string]int, error) {
+	src, err := open_bbl(o, 1)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start err=%v", err)
-		os.Exit(1)
+		return nil, err
 	}
-	record, err := r.Read()
-	build_headers(record)
+	defer src.Close()
+	record, err := src.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: no headers: %v", types.ErrCorruptSegment, err)
+	}
+	return build_headers(record)
 }
 
-func build_headers(record []string) {
-	hdrs = make(map[string]int)
+func build_headers(record []string) (map[string]int, error) {
+	hdrs := make(map[string]int)
 	for i, s := range record {
 		hdrs[s] = i
 	}
 	if _, ok := hdrs["dateTime"]; !ok {
-		fmt.Fprintln(os.Stderr, "No \"datetime\" header, probably blackbox_decode too old or broken")
-		os.Exit(1)
+		return nil, fmt.Errorf("%w: no \"dateTime\" header, probably blackbox_decode too old or broken", types.ErrDecoderMissing)
 	}
+	return hdrs, nil
 }
 
-func dump_headers() {
+func dump_headers(hdrs map[string]int) {
 	n := map[int][]string{}
 	var a []int
 	for k, v := range hdrs {
@@ -107,9 +206,12 @@ func dump_headers() {
 	}
 }
 
-func metas(fn string) ([]types.FlightMeta, error) {
+func metas(o *BBLOG) ([]types.FlightMeta, error) {
 	var bes []types.FlightMeta
-	get_headers(fn)
+	if _, err := get_headers(o); err != nil {
+		return nil, err
+	}
+	fn := o.name
 	r, err := os.Open(fn)
 	if err == nil {
 		var nbes int
@@ -308,19 +410,27 @@ func metas(fn string) ([]types.FlightMeta, error) {
 			*/
 		}
 	} else {
-		err = errors.New("No records in BBL")
+		err = fmt.Errorf("No records in BBL: %w", err)
 	}
 	return bes, err
 }
 
-func get_durations(fn string, meta []types.FlightMeta) {
-	for i := 0; i < len(meta); i++ {
-		meta[i].Duration = get_bb_duration(fn, fmt.Sprintf("%d", i+1))
+func get_durations(o *BBLOG) {
+	for i := 0; i < len(o.meta); i++ {
+		o.meta[i].Duration = get_bb_duration(o, fmt.Sprintf("%d", i+1))
 	}
 }
 
-func get_bb_duration(bbfile string, idx string) time.Duration {
-	cmd := exec.Command(options.Config.Blackbox_decode, "--stdout", "--index", idx, bbfile)
+func get_bb_duration(o *BBLOG, idx string) time.Duration {
+	if !o.cfg.BBLExternal {
+		n, _ := strconv.Atoi(idx)
+		if bf, err := o.bbl_file(); err == nil {
+			if d, err := native_duration(bf, n); err == nil {
+				return d
+			}
+		}
+	}
+	cmd := exec.Command(o.cfg.Blackbox_decode, "--stdout", "--index", idx, o.name)
 	out, err := cmd.StdoutPipe()
 	defer cmd.Wait()
 	defer out.Close()
@@ -356,9 +466,9 @@ func get_bb_duration(bbfile string, idx string) time.Duration {
 	return diff
 }
 
-func get_rec_value(r []string, key string) (string, bool) {
+func (bs *bblsession) get_rec_value(r []string, key string) (string, bool) {
 	var s string
-	i, ok := hdrs[key]
+	i, ok := bs.hdrs[key]
 	if ok {
 		if i < len(r) {
 			s = r[i]
@@ -369,62 +479,66 @@ func get_rec_value(r []string, key string) (string, bool) {
 	return s, ok
 }
 
-func dataCapability() uint16 {
+func (bs *bblsession) dataCapability() uint16 {
 	var ret uint16 = 0
-	if _, ok := hdrs["amperage (A)"]; ok {
+	if _, ok := bs.hdrs["amperage (A)"]; ok {
 		ret |= types.CAP_AMPS
 	}
-	if _, ok := hdrs["vbat (V)"]; ok {
+	if _, ok := bs.hdrs["vbat (V)"]; ok {
 		ret |= types.CAP_VOLTS
 	}
-	if _, ok := hdrs["energyCumulative (mAh)"]; ok {
+	if _, ok := bs.hdrs["energyCumulative (mAh)"]; ok {
 		ret |= types.CAP_ENERGY
 	}
 
-	if _, ok := hdrs["GPS_speed (m/s)"]; ok {
+	if _, ok := bs.hdrs["GPS_speed (m/s)"]; ok {
 		ret |= types.CAP_SPEED
 	}
 
-	if _, ok := hdrs["navPos[2]"]; ok {
+	if _, ok := bs.hdrs["navPos[2]"]; ok {
 		ret |= types.CAP_ALTITUDE
 	}
 
-	if _, ok := hdrs["activeWpNumber"]; ok {
+	if _, ok := bs.hdrs["activeWpNumber"]; ok {
 		ret |= types.CAP_WPNO
 	}
+
+	if _, ok := bs.hdrs["airSpeed"]; ok {
+		ret |= types.CAP_AIRSPEED
+	}
 	return ret
 }
 
-func get_bbl_line(r []string, have_origin bool) types.LogItem {
+func (bs *bblsession) get_bbl_line(r []string, have_origin bool) types.LogItem {
 	status := types.Is_ARMED
 	b := types.LogItem{}
 
-	s, ok := get_rec_value(r, "GPS_numSat")
+	s, ok := bs.get_rec_value(r, "GPS_numSat")
 	if ok {
 		i64, _ := strconv.Atoi(s)
 		b.Numsat = uint8(i64)
 	}
 
-	if s, ok = get_rec_value(r, "GPS_hdop"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_hdop"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Hdop = uint16(i64)
 	}
 
-	if s, ok = get_rec_value(r, "vbat (V)"); ok {
+	if s, ok = bs.get_rec_value(r, "vbat (V)"); ok {
 		b.Volts, _ = strconv.ParseFloat(s, 64)
-	} else if s, ok = get_rec_value(r, "vbatLatest (V)"); ok {
+	} else if s, ok = bs.get_rec_value(r, "vbatLatest (V)"); ok {
 		b.Volts, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "navPos[2]"); ok {
+	if s, ok = bs.get_rec_value(r, "navPos[2]"); ok {
 		b.Alt, _ = strconv.ParseFloat(s, 64)
 		b.Alt = b.Alt / 100.0
-	} else if s, ok = get_rec_value(r, "BaroAlt (cm)"); ok {
+	} else if s, ok = bs.get_rec_value(r, "BaroAlt (cm)"); ok {
 		b.Alt, _ = strconv.ParseFloat(s, 64)
 		b.Alt = b.Alt / 100.0
 	}
 
-	if s, ok = get_rec_value(r, "GPS_fixType"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_fixType"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Fix = uint8(i64)
 	} else {
@@ -437,51 +551,56 @@ func get_bbl_line(r []string, have_origin bool) types.LogItem {
 		}
 	}
 
-	if s, ok = get_rec_value(r, "GPS_coord[0]"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_coord[0]"); ok {
 		b.Lat, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "GPS_coord[1]"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_coord[1]"); ok {
 		b.Lon, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "GPS_altitude"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_altitude"); ok {
 		b.GAlt, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "GPS_speed (m/s)"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_speed (m/s)"); ok {
 		b.Spd, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "time (us)"); ok {
+	if s, ok = bs.get_rec_value(r, "airSpeed"); ok {
+		b.Airspd, _ = strconv.ParseFloat(s, 64)
+		b.Airspd = b.Airspd / 100.0
+	}
+
+	if s, ok = bs.get_rec_value(r, "time (us)"); ok {
 		i64, _ := strconv.ParseInt(s, 10, 64)
 		b.Stamp = uint64(i64)
 	}
 
-	if s, ok = get_rec_value(r, "activeWpNumber"); ok {
+	if s, ok = bs.get_rec_value(r, "activeWpNumber"); ok {
 		i64, _ := strconv.ParseInt(s, 10, 64)
 		b.ActiveWP = uint8(i64)
 	}
 
 	md := uint8(0)
-	s0, sok := get_rec_value(r, "flightModeFlags (flags)")
-	if s, ok = get_rec_value(r, "navState"); ok {
+	s0, sok := bs.get_rec_value(r, "flightModeFlags (flags)")
+	if s, ok = bs.get_rec_value(r, "navState"); ok {
 		i64, _ := strconv.ParseInt(s, 10, 64)
-		if inav.IsCruise3d(inav_vers, int(i64)) {
+		if inav.IsCruise3d(bs.inav_vers, int(i64)) {
 			md = types.FM_CRUISE3D
-		} else if inav.IsCruise2d(inav_vers, int(i64)) {
+		} else if inav.IsCruise2d(bs.inav_vers, int(i64)) {
 			md = types.FM_CRUISE2D
-		} else if inav.IsRTH(inav_vers, int(i64)) {
+		} else if inav.IsRTH(bs.inav_vers, int(i64)) {
 			md = types.FM_RTH
-		} else if inav.IsWP(inav_vers, int(i64)) {
+		} else if inav.IsWP(bs.inav_vers, int(i64)) {
 			md = types.FM_WP
-		} else if inav.IsLaunch(inav_vers, int(i64)) {
+		} else if inav.IsLaunch(bs.inav_vers, int(i64)) {
 			md = types.FM_LAUNCH
-		} else if inav.IsPH(inav_vers, int(i64)) {
+		} else if inav.IsPH(bs.inav_vers, int(i64)) {
 			md = types.FM_PH
-		} else if inav.IsAH(inav_vers, int(i64)) {
+		} else if inav.IsAH(bs.inav_vers, int(i64)) {
 			md = types.FM_AH
-		} else if inav.IsEmerg(inav_vers, int(i64)) {
+		} else if inav.IsEmerg(bs.inav_vers, int(i64)) {
 			md = types.FM_EMERG
 		} else {
 			if strings.Contains(s0, "MANUAL") {
@@ -492,7 +611,7 @@ func get_bbl_line(r []string, have_origin bool) types.LogItem {
 				md = types.FM_HORIZON
 			}
 		}
-		b.NavMode = inav.NavMode(inav_vers, int(i64))
+		b.NavMode = inav.NavMode(bs.inav_vers, int(i64))
 	}
 	// fallback for old inav bug
 	if sok && strings.Contains(s0, "NAVRTH") {
@@ -502,7 +621,7 @@ func get_bbl_line(r []string, have_origin bool) types.LogItem {
 	b.Fmode = md
 	b.Fmtext = types.Mnames[md]
 
-	if s, ok = get_rec_value(r, "failsafePhase (flags)"); ok {
+	if s, ok = bs.get_rec_value(r, "failsafePhase (flags)"); ok {
 		if !strings.Contains(s, "IDLE") {
 			status |= types.Is_FAIL
 		}
@@ -515,143 +634,143 @@ func get_bbl_line(r []string, have_origin bool) types.LogItem {
 		b.Hlon = 0
 		b.Vrange = -1
 		b.Bearing = -1
-		if s, ok = get_rec_value(r, "GPS_home_lat"); ok {
+		if s, ok = bs.get_rec_value(r, "GPS_home_lat"); ok {
 			b.Hlat, _ = strconv.ParseFloat(s, 64)
 		}
-		if s, ok = get_rec_value(r, "GPS_home_lon"); ok {
+		if s, ok = bs.get_rec_value(r, "GPS_home_lon"); ok {
 			b.Hlon, _ = strconv.ParseFloat(s, 64)
 			b.Bearing = -2
 		} else {
-			if s, ok = get_rec_value(r, "homeDirection"); ok {
+			if s, ok = bs.get_rec_value(r, "homeDirection"); ok {
 				i64, _ := strconv.Atoi(s)
 				b.Bearing = int32(i64)
 			} else {
-				if s, ok = get_rec_value(r, "Azimuth"); ok {
+				if s, ok = bs.get_rec_value(r, "Azimuth"); ok {
 					i64, _ := strconv.Atoi(s)
 					b.Bearing = int32((i64 + 180) % 360)
 				}
 			}
 
 			if b.Bearing != -1 {
-				if s, ok = get_rec_value(r, "Distance (m)"); ok {
+				if s, ok = bs.get_rec_value(r, "Distance (m)"); ok {
 					b.Vrange, _ = strconv.ParseFloat(s, 64)
 				}
 			}
 		}
 	} else {
-		if s, ok = get_rec_value(r, "GPS_home_lat"); ok {
+		if s, ok = bs.get_rec_value(r, "GPS_home_lat"); ok {
 			b.Hlat, _ = strconv.ParseFloat(s, 64)
 		}
-		if s, ok = get_rec_value(r, "GPS_home_lon"); ok {
+		if s, ok = bs.get_rec_value(r, "GPS_home_lon"); ok {
 			b.Hlon, _ = strconv.ParseFloat(s, 64)
 		}
 	}
 
-	if s, ok = get_rec_value(r, "rcData[0]"); ok {
+	if s, ok = bs.get_rec_value(r, "rcData[0]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Ail = int16(i64)
-		if s, ok = get_rec_value(r, "rcData[1]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcData[1]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Ele = int16(i64)
 		}
-		if s, ok = get_rec_value(r, "rcData[2]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcData[2]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Rud = int16(i64)
 		}
-		if s, ok = get_rec_value(r, "rcData[3]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcData[3]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Thr = int16(i64)
 		}
-	} else if s, ok = get_rec_value(r, "rcCommand[0]"); ok {
+	} else if s, ok = bs.get_rec_value(r, "rcCommand[0]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Ail = int16(i64) + 1500
-		if s, ok = get_rec_value(r, "rcCommand[1]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcCommand[1]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Ele = int16(i64) + 1500
 		}
-		if s, ok = get_rec_value(r, "rcCommand[2]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcCommand[2]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Rud = -1*int16(i64) + 1500
 		}
-		if s, ok = get_rec_value(r, "rcCommand[3]"); ok {
+		if s, ok = bs.get_rec_value(r, "rcCommand[3]"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Thr = int16(i64)
 		}
 	}
 
-	if s, ok = get_rec_value(r, "attitude[0]"); ok {
+	if s, ok = bs.get_rec_value(r, "attitude[0]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Roll = int16(i64 / 10)
 	}
 
-	if s, ok = get_rec_value(r, "attitude[1]"); ok {
+	if s, ok = bs.get_rec_value(r, "attitude[1]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Pitch = int16(i64 / 10)
 	}
 
-	if s, ok = get_rec_value(r, "attitude[2]"); ok {
+	if s, ok = bs.get_rec_value(r, "attitude[2]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Cse = uint32(i64 / 10)
 	}
 
-	if s, ok = get_rec_value(r, "GPS_ground_course"); ok {
+	if s, ok = bs.get_rec_value(r, "GPS_ground_course"); ok {
 		v, _ := strconv.ParseFloat(s, 64)
 		b.Cog = uint32(v)
 	}
 
-	if s, ok = get_rec_value(r, "rssi"); ok {
+	if s, ok = bs.get_rec_value(r, "rssi"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Rssi = uint8(i64 * 100 / 1023)
 	}
 
-	if s, ok = get_rec_value(r, "dateTime"); ok {
+	if s, ok = bs.get_rec_value(r, "dateTime"); ok {
 		b.Utc, _ = time.Parse(time.RFC3339Nano, s)
 	}
 
-	if s, ok = get_rec_value(r, "amperage (A)"); ok {
+	if s, ok = bs.get_rec_value(r, "amperage (A)"); ok {
 		b.Amps, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, ok = get_rec_value(r, "energyCumulative (mAh)"); ok {
+	if s, ok = bs.get_rec_value(r, "energyCumulative (mAh)"); ok {
 		b.Energy, _ = strconv.ParseFloat(s, 64)
 		if b.Energy < 0 {
 			b.Energy = 0
 		}
 	}
 
-	if s, ok = get_rec_value(r, "rcData[3]"); ok {
+	if s, ok = bs.get_rec_value(r, "rcData[3]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Throttle = int(i64)
 		b.Throttle = (b.Throttle - 1000) / 10
 	}
 
-	if s, ok = get_rec_value(r, "gyroADC[0]"); ok {
+	if s, ok = bs.get_rec_value(r, "gyroADC[0]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Gyro_x = int16(i64)
 	}
-	if s, ok = get_rec_value(r, "gyroADC[1]"); ok {
+	if s, ok = bs.get_rec_value(r, "gyroADC[1]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Gyro_y = int16(i64)
 	}
-	if s, ok = get_rec_value(r, "gyroADC[2]"); ok {
+	if s, ok = bs.get_rec_value(r, "gyroADC[2]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Gyro_z = int16(i64)
 	}
 
-	if s, ok = get_rec_value(r, "accSmooth[0]"); ok {
+	if s, ok = bs.get_rec_value(r, "accSmooth[0]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Acc_x = int16(i64)
 	}
-	if s, ok = get_rec_value(r, "accSmooth[1]"); ok {
+	if s, ok = bs.get_rec_value(r, "accSmooth[1]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Acc_y = int16(i64)
 	}
-	if s, ok = get_rec_value(r, "accSmooth[2]"); ok {
+	if s, ok = bs.get_rec_value(r, "accSmooth[2]"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Acc_z = int16(i64)
 	}
 
-	if s, ok = get_rec_value(r, "hwHealthStatus"); ok {
+	if s, ok = bs.get_rec_value(r, "hwHealthStatus"); ok {
 		b.HWfail = false
 		val, _ := strconv.Atoi(s)
 		for n := 0; n < 7; n++ {
@@ -677,14 +796,13 @@ func proc_start(w1 *os.File, args ...string) (p *os.Process, err error) {
 	}
 	return nil, err
 }
-func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSegment, bool) {
-	cmd := exec.Command(options.Config.Blackbox_decode,
-		"--datetime", "--merge-gps", "--stdout", "--index",
-		strconv.Itoa(meta.Index), lg.name)
-	types.SetSilentProcess(cmd)
-	out, err := cmd.StdoutPipe()
-	defer cmd.Wait()
-	defer out.Close()
+func (lg *BBLOG) Reader(meta types.FlightMeta, stream *types.LogStream) (types.LogSegment, error) {
+	ls := types.LogSegment{}
+	r, err := open_bbl(lg, meta.Index)
+	if err != nil {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, err)
+	}
+	defer r.Close()
 
 	var homes types.HomeRec
 	var rec types.LogRec
@@ -692,15 +810,6 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 
 	fb := geo.Getfrobnication()
 
-	r := csv.NewReader(out)
-	r.TrimLeadingSpace = true
-
-	err = cmd.Start()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to start err=%v", err)
-		os.Exit(1)
-	}
-
 	stats := types.LogStats{}
 
 	var llat, llon float64
@@ -708,7 +817,7 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 	var basetime time.Time
 	have_origin := false
 
-	inav_vers = 0
+	var bs bblsession
 	fwvers := strings.Split(meta.Firmware, " ")
 	if len(fwvers) == 4 {
 		parts := strings.Split(fwvers[1], ".")
@@ -716,13 +825,13 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 			mask := (1 << 16)
 			for _, p := range parts {
 				v, _ := strconv.Atoi(p)
-				inav_vers = inav_vers + (v * mask)
+				bs.inav_vers = bs.inav_vers + (v * mask)
 				mask = mask >> 8
 			}
 		}
 	}
 
-	ndelay := 1000 * uint64(options.Config.Intvl)
+	ndelay := 1000 * uint64(lg.cfg.Intvl)
 
 	leffic := 0.0
 	lwhkm := 0.0
@@ -734,18 +843,24 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 			break
 		}
 		if i == 0 {
-			build_headers(record)
-			rec.Cap = dataCapability()
+			if err == nil {
+				bs.hdrs, err = build_headers(record)
+			}
+			if err != nil {
+				return ls, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, err)
+			}
+			rec.Cap = bs.dataCapability()
 			continue
 		}
 
-		b := get_bbl_line(record, have_origin)
+		b := bs.get_bbl_line(record, have_origin)
 
 		if !have_origin {
 			if b.Fix > 1 && b.Numsat > 5 {
 				have_origin = true
 				if fb != nil {
 					fb.Set_origin(b.Lat, b.Lon, b.GAlt)
+					ls.R = types.RebaseOrigin{Lat: b.Lat, Lon: b.Lon, Alt: b.GAlt, Valid: true}
 					b.Lat, b.Lon, b.GAlt = fb.Relocate(b.Lat, b.Lon, b.GAlt)
 					ttmp := time.Now().Add(time.Hour * 24 * 42)
 					froboff = ttmp.Sub(b.Utc)
@@ -776,8 +891,8 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 				if fb != nil && (homes.Flags&types.HOME_SAFE != 0) {
 					homes.SafeLat, homes.SafeLon, _ = fb.Relocate(homes.SafeLat, homes.SafeLon, b.GAlt)
 				}
-				if ch != nil {
-					ch <- homes
+				if stream != nil && !stream.Home(homes) {
+					return ls, stream.Err()
 				}
 			}
 			if b.Utc.IsZero() {
@@ -834,8 +949,10 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 						rec.Cap |= types.CAP_RSSI_VALID
 					}
 
-					if ch != nil {
-						ch <- b
+					if stream != nil {
+						if !stream.Item(b) {
+							return ls, stream.Err()
+						}
 					} else {
 						rec.Items = append(rec.Items, b)
 					}
@@ -860,22 +977,27 @@ func (lg *BBLOG) Reader(meta types.FlightMeta, ch chan interface{}) (types.LogSe
 			}
 		}
 		if err != nil {
+			if homes.Flags == 0 {
+				return ls, fmt.Errorf("%s / %d: %w: %v", lg.name, meta.Index, types.ErrCorruptSegment, err)
+			}
+			// Keep what we have
 			log.Printf("bblreader: %+v\n", err)
 			break
 		}
 	}
 	srec := stats.Summary(lt - st)
-	ls := types.LogSegment{}
-	if ch != nil {
-		ch <- srec
-		return ls, true
-	} else {
-		ok := homes.Flags != 0 && len(rec.Items) > 0
-		if ok {
-			ls.L = rec
-			ls.H = homes
-			ls.M = srec
-		}
-		return ls, ok
-	}
+	if stream != nil {
+		ls.M = srec
+		ls.S = stats
+	} else if homes.Flags != 0 && len(rec.Items) > 0 {
+		ls.L = rec
+		ls.H = homes
+		ls.M = srec
+		ls.S = stats
+	}
+	// A fix on the last record sets the home, but leaves no items
+	if homes.Flags == 0 || (stream == nil && len(rec.Items) == 0) {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, meta.Index, types.ErrNoGPSFix)
+	}
+	return ls, nil
 }
@@ -0,0 +1,233 @@
+package bbl
+
+import (
+	"io"
+)
+
+// Blackbox field encodings
+const (
+	ENC_SIGNED_VB       = 0
+	ENC_UNSIGNED_VB     = 1
+	ENC_NEG_14BIT       = 3
+	ENC_TAG8_8SVB       = 6
+	ENC_TAG2_3S32       = 7
+	ENC_TAG8_4S16       = 8
+	ENC_NULL            = 9
+	ENC_TAG2_3SVARIABLE = 10
+)
+
+// Blackbox field predictors
+const (
+	PRED_ZERO                 = 0
+	PRED_PREVIOUS             = 1
+	PRED_STRAIGHT_LINE        = 2
+	PRED_AVERAGE_2            = 3
+	PRED_MINTHROTTLE          = 4
+	PRED_MOTOR_0              = 5
+	PRED_INCREMENT            = 6
+	PRED_HOME_COORD           = 7
+	PRED_1500                 = 8
+	PRED_VBATREF              = 9
+	PRED_LAST_MAIN_FRAME_TIME = 10
+	PRED_MINMOTOR             = 11
+)
+
+type bblstream struct {
+	data []byte
+	pos  int
+	eof  bool
+}
+
+func (s *bblstream) read_byte() byte {
+	if s.pos < len(s.data) {
+		c := s.data[s.pos]
+		s.pos++
+		return c
+	}
+	s.eof = true
+	return 0
+}
+
+func (s *bblstream) peek_byte() (byte, error) {
+	if s.pos < len(s.data) {
+		return s.data[s.pos], nil
+	}
+	return 0, io.EOF
+}
+
+func (s *bblstream) read_unsigned_vb() uint32 {
+	var res uint32
+	for shift := uint(0); shift < 35; shift += 7 {
+		c := s.read_byte()
+		if s.eof {
+			return 0
+		}
+		res |= uint32(c&0x7f) << shift
+		if c < 0x80 {
+			return res
+		}
+	}
+	// 5 bytes with continuation, corrupt
+	return 0
+}
+
+func zigzag_decode(u uint32) int32 {
+	return int32(u>>1) ^ -int32(u&1)
+}
+
+func (s *bblstream) read_signed_vb() int32 {
+	return zigzag_decode(s.read_unsigned_vb())
+}
+
+func sign_extend(v uint32, bits uint) int32 {
+	shift := 32 - bits
+	return int32(v<<shift) >> shift
+}
+
+func (s *bblstream) read_neg_14bit() int32 {
+	return -sign_extend(s.read_unsigned_vb(), 14)
+}
+
+func (s *bblstream) read_tag2_3s32(values []int64) {
+	lead := uint32(s.read_byte())
+	switch lead >> 6 {
+	case 0:
+		values[0] = int64(sign_extend((lead>>4)&0x03, 2))
+		values[1] = int64(sign_extend((lead>>2)&0x03, 2))
+		values[2] = int64(sign_extend(lead&0x03, 2))
+	case 1:
+		values[0] = int64(sign_extend(lead&0x0f, 4))
+		lead = uint32(s.read_byte())
+		values[1] = int64(sign_extend(lead>>4, 4))
+		values[2] = int64(sign_extend(lead&0x0f, 4))
+	case 2:
+		values[0] = int64(sign_extend(lead&0x3f, 6))
+		lead = uint32(s.read_byte())
+		values[1] = int64(sign_extend(lead&0x3f, 6))
+		lead = uint32(s.read_byte())
+		values[2] = int64(sign_extend(lead&0x3f, 6))
+	case 3:
+		selector := lead
+		for i := 0; i < 3; i++ {
+			switch selector & 0x03 {
+			case 0:
+				values[i] = int64(sign_extend(uint32(s.read_byte()), 8))
+			case 1:
+				b1 := uint32(s.read_byte())
+				b2 := uint32(s.read_byte())
+				values[i] = int64(sign_extend(b1|b2<<8, 16))
+			case 2:
+				b1 := uint32(s.read_byte())
+				b2 := uint32(s.read_byte())
+				b3 := uint32(s.read_byte())
+				values[i] = int64(sign_extend(b1|b2<<8|b3<<16, 24))
+			case 3:
+				b1 := uint32(s.read_byte())
+				b2 := uint32(s.read_byte())
+				b3 := uint32(s.read_byte())
+				b4 := uint32(s.read_byte())
+				values[i] = int64(int32(b1 | b2<<8 | b3<<16 | b4<<24))
+			}
+			selector >>= 2
+		}
+	}
+}
+
+func (s *bblstream) read_tag2_3svariable(values []int64) {
+	lead := uint32(s.read_byte())
+	switch lead >> 6 {
+	case 0:
+		values[0] = int64(sign_extend((lead>>4)&0x03, 2))
+		values[1] = int64(sign_extend((lead>>2)&0x03, 2))
+		values[2] = int64(sign_extend(lead&0x03, 2))
+	case 1:
+		// 5,5,4 bits
+		b1 := uint32(s.read_byte())
+		values[0] = int64(sign_extend((lead&0x3e)>>1, 5))
+		values[1] = int64(sign_extend(((lead&0x01)<<4)|((b1&0xf0)>>4), 5))
+		values[2] = int64(sign_extend(b1&0x0f, 4))
+	case 2:
+		// 8,7,7 bits
+		b1 := uint32(s.read_byte())
+		b2 := uint32(s.read_byte())
+		values[0] = int64(sign_extend(((lead&0x3f)<<2)|((b1&0xc0)>>6), 8))
+		values[1] = int64(sign_extend(((b1&0x3f)<<1)|((b2&0x80)>>7), 7))
+		values[2] = int64(sign_extend(b2&0x7f, 7))
+	case 3:
+		s.pos--
+		s.read_tag2_3s32(values)
+	}
+}
+
+func (s *bblstream) read_tag8_4s16(values []int64) {
+	selector := s.read_byte()
+	nibble := 0
+	var buffer uint32
+	for i := 0; i < 4; i++ {
+		switch selector & 0x03 {
+		case 0:
+			values[i] = 0
+		case 1:
+			if nibble == 0 {
+				buffer = uint32(s.read_byte())
+				values[i] = int64(sign_extend(buffer>>4, 4))
+				nibble = 1
+			} else {
+				values[i] = int64(sign_extend(buffer&0x0f, 4))
+				nibble = 0
+			}
+		case 2:
+			if nibble == 0 {
+				values[i] = int64(sign_extend(uint32(s.read_byte()), 8))
+			} else {
+				c1 := (buffer << 4) & 0xff
+				buffer = uint32(s.read_byte())
+				c1 |= buffer >> 4
+				values[i] = int64(sign_extend(c1, 8))
+			}
+		case 3:
+			if nibble == 0 {
+				c1 := uint32(s.read_byte())
+				c2 := uint32(s.read_byte())
+				values[i] = int64(sign_extend((c1<<8)|c2, 16))
+			} else {
+				c1 := uint32(s.read_byte())
+				c2 := uint32(s.read_byte())
+				values[i] = int64(sign_extend(((buffer<<12)|(c1<<4)|(c2>>4))&0xffff, 16))
+				buffer = c2
+			}
+		}
+		selector >>= 2
+	}
+}
+
+func (s *bblstream) read_tag8_8svb(values []int64, n int) {
+	if n == 1 {
+		values[0] = int64(s.read_signed_vb())
+		return
+	}
+	header := s.read_byte()
+	for i := 0; i < 8; i++ {
+		if header&0x01 != 0 {
+			values[i] = int64(s.read_signed_vb())
+		} else {
+			values[i] = 0
+		}
+		header >>= 1
+	}
+}
+
+// Reads a NUL terminated string, as found in the "End of log" event
+func (s *bblstream) read_cstring() string {
+	start := s.pos
+	for s.pos < len(s.data) {
+		if s.data[s.pos] == 0 {
+			str := string(s.data[start:s.pos])
+			s.pos++
+			return str
+		}
+		s.pos++
+	}
+	s.eof = true
+	return string(s.data[start:])
+}
@@ -0,0 +1,96 @@
+package bbl
+
+import (
+	"testing"
+)
+
+/*
+ * Field encodings, against byte sequences worked from the firmware's
+ * encoders (blackbox_io.c)
+ */
+
+var vb_tests = []struct {
+	data []byte
+	enc  int
+	want int64
+}{
+	{[]byte{0x00}, ENC_UNSIGNED_VB, 0},
+	{[]byte{0xac, 0x02}, ENC_UNSIGNED_VB, 300},
+	{[]byte{0xc0, 0x84, 0x3d}, ENC_UNSIGNED_VB, 1000000},
+	{[]byte{0x0a}, ENC_SIGNED_VB, 5},
+	{[]byte{0x05}, ENC_SIGNED_VB, -3},
+	{[]byte{0xcf, 0x0f}, ENC_SIGNED_VB, -1000},
+	{[]byte{0xac, 0x02}, ENC_NEG_14BIT, -300},
+	{[]byte{0xfb, 0x7f}, ENC_NEG_14BIT, 5},
+}
+
+func TestVB(t *testing.T) {
+	for _, vt := range vb_tests {
+		s := bblstream{data: vt.data}
+		var v int64
+		switch vt.enc {
+		case ENC_UNSIGNED_VB:
+			v = int64(s.read_unsigned_vb())
+		case ENC_SIGNED_VB:
+			v = int64(s.read_signed_vb())
+		case ENC_NEG_14BIT:
+			v = int64(s.read_neg_14bit())
+		}
+		if v != vt.want || s.pos != len(vt.data) || s.eof {
+			t.Errorf("% x (enc %d): %d (%d bytes), want %d", vt.data, vt.enc, v, s.pos, vt.want)
+		}
+	}
+}
+
+var tag_tests = []struct {
+	name string
+	enc  int
+	data []byte
+	want []int64
+}{
+	{"3s32 2 bit", ENC_TAG2_3S32, []byte{0x1c}, []int64{1, -1, 0}},
+	{"3s32 4 bit", ENC_TAG2_3S32, []byte{0x45, 0xd7}, []int64{5, -3, 7}},
+	{"3s32 6 bit", ENC_TAG2_3S32, []byte{0x94, 0x2c, 0x1f}, []int64{20, -20, 31}},
+	{"3s32 8/16/24 bit", ENC_TAG2_3S32, []byte{0xe4, 0x64, 0x18, 0xfc, 0xa0, 0x86, 0x01},
+		[]int64{100, -1000, 100000}},
+	{"3s32 32 bit", ENC_TAG2_3S32, []byte{0xf4, 0x64, 0x18, 0xfc, 0x00, 0x2d, 0x31, 0x01},
+		[]int64{100, -1000, 20000000}},
+	{"3svariable 2 bit", ENC_TAG2_3SVARIABLE, []byte{0x1c}, []int64{1, -1, 0}},
+	{"3svariable 5/5/4 bit", ENC_TAG2_3SVARIABLE, []byte{0x55, 0x45}, []int64{10, -12, 5}},
+	{"3svariable 8/7/7 bit", ENC_TAG2_3SVARIABLE, []byte{0xa7, 0x19, 0x44}, []int64{-100, 50, -60}},
+	{"3svariable 32 bit", ENC_TAG2_3SVARIABLE, []byte{0xe1, 0xe8, 0x03, 0xfe, 0x70, 0x11, 0x01},
+		[]int64{1000, -2, 70000}},
+	{"4s16 zero", ENC_TAG8_4S16, []byte{0x00}, []int64{0, 0, 0, 0}},
+	{"4s16 4/0/4/16 bit", ENC_TAG8_4S16, []byte{0xd1, 0x3e, 0x01, 0x2c}, []int64{3, 0, -2, 300}},
+	{"4s16 unaligned 8/16 bit", ENC_TAG8_4S16, []byte{0x39, 0xb6, 0x4f, 0xc1, 0x80},
+		[]int64{-5, 100, -1000, 0}},
+	{"8svb single", ENC_TAG8_8SVB, []byte{0x05}, []int64{-3}},
+	{"8svb group", ENC_TAG8_8SVB, []byte{0x06, 0x0a, 0x05}, []int64{0, 5, -3}},
+	{"8svb group zero", ENC_TAG8_8SVB, []byte{0x00}, []int64{0, 0, 0, 0}},
+}
+
+func TestTagEncodings(t *testing.T) {
+	for _, tt := range tag_tests {
+		s := bblstream{data: tt.data}
+		var values [8]int64
+		switch tt.enc {
+		case ENC_TAG2_3S32:
+			s.read_tag2_3s32(values[:])
+		case ENC_TAG2_3SVARIABLE:
+			s.read_tag2_3svariable(values[:])
+		case ENC_TAG8_4S16:
+			s.read_tag8_4s16(values[:])
+		case ENC_TAG8_8SVB:
+			s.read_tag8_8svb(values[:], len(tt.want))
+		}
+		if s.pos != len(tt.data) || s.eof {
+			t.Errorf("%s: read %d bytes, want %d", tt.name, s.pos, len(tt.data))
+		}
+		for j, w := range tt.want {
+			if values[j] != w {
+				t.Errorf("%s: %v, want %v", tt.name, values[:len(tt.want)], tt.want)
+				break
+			}
+		}
+	}
+}
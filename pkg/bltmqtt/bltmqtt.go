@@ -348,19 +348,6 @@ func make_bullet_mode(mode string, ncells int, hwfail bool) string {
 	return sb.String()
 }
 
-func get_cells(vbat float64) int {
-	ncell := 0
-	for i := 1; i < 10; i++ {
-		vmin := 3.0 * float64(i)
-		vmax := 4.22 * float64(i)
-		if vbat < vmax && vbat > vmin {
-			ncell = i
-			break
-		}
-	}
-	return ncell
-}
-
 func output_message(c *MQTTClient, wfh *os.File, msg string, et time.Time) {
 	if c != nil {
 		c.publish(msg)
@@ -375,6 +362,7 @@ func MQTTGen(s types.LogSegment, meta types.FlightMeta) {
 	ncells := 0
 	var wfh *os.File
 	tgt := 0
+	wpst := inav.NewWPState(options.Config.Intvl)
 	var name string
 	if meta.Flags&types.Has_Craft != 0 {
 		name = meta.Craft
@@ -460,7 +448,7 @@ func MQTTGen(s types.LogSegment, meta types.FlightMeta) {
 		stat := b.Status >> 2
 
 		if ncells == 0 {
-			ncells = get_cells(b.Volts)
+			ncells = types.CellCount(b.Volts)
 		}
 
 		if b.Fmode != laststat {
@@ -552,7 +540,7 @@ func MQTTGen(s types.LogSegment, meta types.FlightMeta) {
 		}
 
 		if b.Fmode == types.FM_WP && ms != nil {
-			tgt, _ = inav.WP_state(ms, b, tgt)
+			tgt, _ = wpst.WP_state(ms, b, tgt)
 		}
 		msg := make_bullet_msg(b, s.H.HomeAlt, et, ncells, tgt)
 		output_message(c, wfh, msg, b.Utc)
@@ -1,6 +1,7 @@
 package bltlog
 
 import (
+	"bytes"
 	"fmt"
 	//"io"
 	//"math"
@@ -21,26 +22,44 @@ import (
 	"types"
 )
 
-var (
+// Per-read state, the home and any mission found in the log
+type bltstate struct {
 	homes types.HomeRec
 	ms    mission.Mission
 	mok   bool
-)
+}
 
 var fltmodes = [...]uint8{0, types.FM_MANUAL, types.FM_RTH, types.FM_PH, types.FM_PH, types.FM_CRUISE3D, types.FM_CRUISE3D, types.FM_WP, types.FM_AH, types.FM_ANGLE, types.FM_HORIZON, types.FM_ACRO}
 
 type BLTLOG struct {
 	name string
 	meta []types.FlightMeta
+	cfg  *options.Configuration
 }
 
 func NewBLTReader(fn string) BLTLOG {
 	var l BLTLOG
 	l.name = fn
 	l.meta = nil
+	l.cfg = &options.Config
 	return l
 }
 
+func (o *BLTLOG) SetConfig(cfg *options.Configuration) {
+	o.cfg = cfg
+}
+
+func init() {
+	types.RegisterReader(types.LogReader{Ftype: types.IS_BLT, Name: "BulletGCSS",
+		Detect: func(sig []byte) bool {
+			return bytes.Contains(sig, []byte("|Connected to"))
+		},
+		New: func(fn string) types.FlightLog {
+			l := NewBLTReader(fn)
+			return &l
+		}})
+}
+
 func (o *BLTLOG) LogType() byte {
 	return 'G'
 }
@@ -48,7 +67,9 @@ func (o *BLTLOG) GetMetas() ([]types.FlightMeta, error) {
 	m, err := types.ReadMetaCache(o.name)
 	if err != nil {
 		m, err = metas(o.name)
-		types.WriteMetaCache(o.name, m)
+		if err == nil {
+			types.WriteMetaCache(o.name, m)
+		}
 	}
 	o.meta = m
 	return m, err
@@ -121,7 +142,7 @@ func metas(logfile string) ([]types.FlightMeta, error) {
 	return metas, err
 }
 
-func parse_bullet(line string, b *types.LogItem) uint16 {
+func (bs *bltstate) parse_bullet(line string, b *types.LogItem) uint16 {
 	cap := uint16(0)
 	if parts := strings.Split(line, "|"); len(parts) == 2 {
 		lasttm, _ := strconv.ParseInt(parts[0], 10, 64)
@@ -189,20 +210,20 @@ func parse_bullet(line string, b *types.LogItem) uint16 {
 				case "nvs":
 					b.NavMode = byte(tmp)
 				case "hla":
-					homes.HomeLat = float64(tmp) / 1e7
-					homes.Flags |= types.HOME_ARM
+					bs.homes.HomeLat = float64(tmp) / 1e7
+					bs.homes.Flags |= types.HOME_ARM
 				case "hlo":
-					homes.HomeLon = float64(tmp) / 1e7
-					homes.Flags |= types.HOME_ARM
+					bs.homes.HomeLon = float64(tmp) / 1e7
+					bs.homes.Flags |= types.HOME_ARM
 				case "hal":
-					homes.HomeAlt = float64(tmp) / 100.0
-					homes.Flags |= types.HOME_ALT
+					bs.homes.HomeAlt = float64(tmp) / 100.0
+					bs.homes.Flags |= types.HOME_ALT
 				case "cud":
 					b.Amps = float64(tmp) / 100.0
 					cap |= types.CAP_AMPS
 				case "wpno":
-					if mok == false {
-						parse_mission(vals)
+					if bs.mok == false {
+						bs.parse_mission(vals)
 					}
 
 					// not used (here, for now)
@@ -234,7 +255,7 @@ func parse_bullet(line string, b *types.LogItem) uint16 {
 	return cap
 }
 
-func parse_mission(vals []string) {
+func (bs *bltstate) parse_mission(vals []string) {
 	mi := mission.MissionItem{}
 	for _, kvs := range vals {
 		kv := strings.Split(kvs, ":")
@@ -252,7 +273,7 @@ func parse_mission(vals []string) {
 			case "al":
 				mi.Alt = int32(tmp) / 100
 			case "ac":
-				mi.Action = ms.Decode_action(byte(tmp))
+				mi.Action = bs.ms.Decode_action(byte(tmp))
 			case "p1":
 				mi.P1 = int16(tmp)
 			case "p2":
@@ -261,13 +282,13 @@ func parse_mission(vals []string) {
 				mi.P3 = int16(tmp)
 			case "f":
 				if mi.No != 0 {
-					mok = true
+					bs.mok = true
 				}
 			}
 		}
 	}
 	if mi.No != 0 {
-		ms.MissionItems = append(ms.MissionItems, mi)
+		bs.ms.MissionItems = append(bs.ms.MissionItems, mi)
 	}
 }
 
@@ -280,15 +301,15 @@ func parse_flight_mode(fmode uint8) uint8 {
 	}
 }
 
-func (lg *BLTLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegment, bool) {
+func (lg *BLTLOG) Reader(m types.FlightMeta, stream *types.LogStream) (types.LogSegment, error) {
 	var stats types.LogStats
+	var bs bltstate
 	ls := types.LogSegment{}
 	var lt, st time.Time
 
 	fh, err := os.Open(lg.name)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "log file %s\n", err)
-		return ls, false
+		return ls, err
 	}
 	defer fh.Close()
 
@@ -303,17 +324,19 @@ func (lg *BLTLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 	for scanner.Scan() {
 		line := scanner.Text()
 		if i >= m.Start && i <= m.End {
-			cap := parse_bullet(line, &b)
+			cap := bs.parse_bullet(line, &b)
 			rec.Cap |= cap
-			if ch != nil {
-				if !hseen && (homes.Flags&types.HOME_ARM) != 0 {
+			if stream != nil {
+				if !hseen && (bs.homes.Flags&types.HOME_ARM) != 0 {
 					hseen = true
-					ch <- homes
+					if !stream.Home(bs.homes) {
+						return ls, stream.Err()
+					}
 				}
 			}
 			if b.Utc != lt && b.Fix != 0 {
 				tdiff := b.Utc.Sub(lt)
-				if tdiff.Nanoseconds()/(1000*1000) >= int64(options.Config.Intvl) {
+				if tdiff.Nanoseconds()/(1000*1000) >= int64(lg.cfg.Intvl) {
 					if st.IsZero() {
 						st = b.Utc
 						lt = st
@@ -359,8 +382,10 @@ func (lg *BLTLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 					}
 
 					lt = b.Utc
-					if ch != nil {
-						ch <- b
+					if stream != nil {
+						if !stream.Item(b) {
+							return ls, stream.Err()
+						}
 					} else {
 						rec.Items = append(rec.Items, b)
 					}
@@ -372,21 +397,23 @@ func (lg *BLTLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 	}
 
 	srec := stats.Summary(uint64(lt.Sub(st).Nanoseconds() / 1000))
-	if mok {
-		options.Config.Mission = filepath.Join(options.Config.Tmpdir, "tmpmission.xml")
-		ms.To_MWXML(options.Config.Mission)
+	if bs.mok {
+		lg.cfg.Mission = filepath.Join(lg.cfg.Tmpdir, "tmpmission.xml")
+		bs.ms.To_MWXML(lg.cfg.Mission)
 	}
 
-	if ch != nil {
-		ch <- srec
-		return ls, true
-	} else {
-		ok := homes.Flags != 0 && len(rec.Items) > 0
-		if ok {
-			ls.L = rec
-			ls.H = homes
-			ls.M = srec
-		}
-		return ls, ok
+	if stream != nil {
+		ls.M = srec
+		ls.S = stats
+	} else if bs.homes.Flags != 0 && len(rec.Items) > 0 {
+		ls.L = rec
+		ls.H = bs.homes
+		ls.M = srec
+		ls.S = stats
+	}
+	// A fix on the last record sets the home, but leaves no items
+	if bs.homes.Flags == 0 || (stream == nil && len(rec.Items) == 0) {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, m.Index, types.ErrNoGPSFix)
 	}
+	return ls, nil
 }
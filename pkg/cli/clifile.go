@@ -39,6 +39,35 @@ const (
 	TYPE_INC = 1
 )
 
+const (
+	ACTION_NONE    = 0
+	ACTION_AVOID   = 1
+	ACTION_POSHOLD = 2
+	ACTION_RTH     = 3
+)
+
+func (g *GeoZone) TypeName() string {
+	if g.Gtype == TYPE_INC {
+		return "inclusive"
+	}
+	return "exclusive"
+}
+
+func (g *GeoZone) ActionName() string {
+	switch g.Action {
+	case ACTION_NONE:
+		return "none"
+	case ACTION_AVOID:
+		return "avoid"
+	case ACTION_POSHOLD:
+		return "poshold"
+	case ACTION_RTH:
+		return "RTH"
+	default:
+		return fmt.Sprintf("action %d", g.Action)
+	}
+}
+
 var (
 	Safehome_distance float64 = (200.0 / 1852.0)
 	Fwapproach_length float64 = (350.0 / 1852.0)
@@ -4,25 +4,30 @@ import (
 	"compress/gzip"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
+// Per tile locks, as concurrent jobs share the DEM cache
+var dlocks sync.Map
+
 func get_uri(fname string) string {
 	return fmt.Sprintf("https://s3.amazonaws.com/elevation-tiles-prod/skadi/%s/%s", fname[0:3], fname)
 }
 
-func download(fname, dir string) {
-	gzname := fname + ".gz"
-	uri := get_uri(gzname)
-	gzname = filepath.Join(dir, gzname)
-
-	file, err := os.Create(gzname)
-	if err != nil {
-		log.Fatal(err)
+func download(fname, dir string) error {
+	hgtname := filepath.Join(dir, fname)
+	mu, _ := dlocks.LoadOrStore(hgtname, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	if _, err := os.Stat(hgtname); err == nil {
+		// fetched by another job while waiting
+		return nil
 	}
+
+	uri := get_uri(fname + ".gz")
 	client := http.Client{
 		CheckRedirect: func(r *http.Request, via []*http.Request) error {
 			r.URL.Opaque = r.URL.Path
@@ -31,34 +36,36 @@ func download(fname, dir string) {
 	}
 	resp, err := client.Get(uri)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	_, err = io.Copy(file, resp.Body)
-	resp.Body.Close()
-	file.Close()
-	if err == nil {
-		unpack(gzname)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("DEM: %s: %s", uri, resp.Status)
 	}
+	return unpack(resp.Body, hgtname)
 }
 
-func unpack(fname string) {
-	gzfh, err := os.Open(fname)
+// Decompresses to a temporary file, renamed to fname when complete, so a
+// partial tile is never seen by a lookup
+func unpack(r io.Reader, fname string) error {
+	gzrd, err := gzip.NewReader(r)
 	if err != nil {
-		return
+		return err
 	}
-	defer gzfh.Close()
-	gzrd, err := gzip.NewReader(gzfh)
-	n := len(fname) - 3
-	ofname := fname[:n]
-	outfh, err := os.Create(ofname)
+	defer gzrd.Close()
+	outfh, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".*")
 	if err != nil {
-		return
+		return err
 	}
-	defer outfh.Close()
 	_, err = io.Copy(outfh, gzrd)
-	gzrd.Close()
+	if cerr := outfh.Close(); err == nil {
+		err = cerr
+	}
 	if err == nil {
-		os.Remove(fname)
+		err = os.Rename(outfh.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(outfh.Name())
 	}
+	return err
 }
@@ -14,6 +14,11 @@ func InitDem(demdir string) (d *DEMMgr) {
 	return d
 }
 
+// Closes the open HGT files
+func (d *DEMMgr) Close() {
+	d.dem.Close()
+}
+
 func (d *DEMMgr) Get_Elevation(lat, lon float64) (float64, error) {
 	return d.lookup_and_check(lat, lon)
 }
@@ -25,7 +30,9 @@ func (d *DEMMgr) lookup_and_check(lat, lon float64) (float64, error) {
 		if e == DEM_NODATA {
 			if j == 0 {
 				fname, _, _ := get_file_name(lat, lon)
-				download(fname, d.dem.dir)
+				if err := download(fname, d.dem.dir); err != nil {
+					return e, err
+				}
 			} else {
 				return e, fmt.Errorf("DEM: No data for %f %f", lat, lon)
 				break
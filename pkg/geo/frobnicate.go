@@ -7,6 +7,7 @@ import (
 
 import (
 	"options"
+	"types"
 )
 
 type Point struct {
@@ -81,6 +82,22 @@ func (f *Frob) Relocate(lat, lon, alt float64) (float64, float64, float64) {
 	return xlat, xlon, xalt
 }
 
+// Returns a private copy of the rebase state (or nil), as each user sets
+// its own origin
 func Getfrobnication() *Frob {
-	return fb
+	if fb == nil {
+		return nil
+	}
+	f := *fb
+	return &f
+}
+
+// The rebase state of a log segment, with the origin set by its reader,
+// for relocating anything shown with the segment (missions, CLI).
+func SegmentFrob(o types.RebaseOrigin) *Frob {
+	f := Getfrobnication()
+	if f != nil && o.Valid {
+		f.Set_origin(o.Lat, o.Lon, o.Alt)
+	}
+	return f
 }
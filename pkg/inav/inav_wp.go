@@ -7,11 +7,20 @@ import (
 import (
 	"geo"
 	"mission"
-	"options"
 	"types"
 )
 
-var phtime time.Time
+// Mission progress tracking state, one per replay; intvl is the sampling
+// interval (ms)
+type WPState struct {
+	intvl   int
+	phtime  time.Time
+	isTimed bool
+}
+
+func NewWPState(intvl int) *WPState {
+	return &WPState{intvl: intvl}
+}
 
 func get_next_wp(ms *mission.Mission, k int) int {
 	tgt := 0
@@ -44,19 +53,17 @@ func get_next_wp(ms *mission.Mission, k int) int {
 	return tgt
 }
 
-var isTimed bool
-
-func WP_state(ms *mission.Mission, b types.LogItem, tgt int) (int, int) {
+func (w *WPState) WP_state(ms *mission.Mission, b types.LogItem, tgt int) (int, int) {
 	k := 0
 	if b.ActiveWP > 0 {
 		tgt = int(b.ActiveWP)
 	}
 	k = tgt - 1
 
-	if isTimed {
-		if b.Utc.After(phtime) {
+	if w.isTimed {
+		if b.Utc.After(w.phtime) {
 			tgt = get_next_wp(ms, k)
-			isTimed = false
+			w.isTimed = false
 		} else {
 			b.NavMode = 4
 		}
@@ -68,7 +75,7 @@ func WP_state(ms *mission.Mission, b types.LogItem, tgt int) (int, int) {
 				return tgt, 1
 			}
 
-			cdist := 1.25 * b.Spd * float64(options.Config.Intvl/1000.0)
+			cdist := 1.25 * b.Spd * float64(w.intvl/1000.0)
 			if cdist < 30 {
 				cdist = 30
 			}
@@ -86,13 +93,13 @@ func WP_state(ms *mission.Mission, b types.LogItem, tgt int) (int, int) {
 						if ms.MissionItems[k].Action == "POSHOLD_TIME" {
 							var phwait time.Duration
 							mwaitms := int(ms.MissionItems[k].P1) * 1000
-							if mwaitms > options.Config.Intvl/2000 {
-								phwait = time.Duration(mwaitms-options.Config.Intvl/2) * time.Millisecond
+							if mwaitms > w.intvl/2000 {
+								phwait = time.Duration(mwaitms-w.intvl/2) * time.Millisecond
 							} else {
 								phwait = time.Duration(ms.MissionItems[k].P1) * time.Second
 							}
-							phtime = b.Utc.Add(phwait)
-							isTimed = true
+							w.phtime = b.Utc.Add(phwait)
+							w.isTimed = true
 							b.NavMode = 4
 						} else {
 							tgt = get_next_wp(ms, k)
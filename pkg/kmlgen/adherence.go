@@ -0,0 +1,108 @@
+package kmlgen
+
+import (
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"github.com/twpayne/go-kml/icon"
+	"image/color"
+)
+
+import (
+	"analysis"
+	"geo"
+	"mission"
+	"options"
+	"types"
+)
+
+/*
+ * Mission adherence; for each leg, the error vectors from the logged
+ * position to the nearest point on the planned leg (at the planned
+ * altitude), and the waypoints that were never reached.
+ */
+
+var (
+	adh_reached = color.RGBA{R: 0xff, G: 0x8c, B: 0x00, A: 0xff}
+	adh_missed  = color.RGBA{R: 0xe0, G: 0x10, B: 0x10, A: 0xff}
+)
+
+// The mission against which adherence is measured; the -mission-index
+// mission, or the first in the file, rebased with the segment
+func AdherenceMission(cfg *options.Configuration, org types.RebaseOrigin) *mission.Mission {
+	ml := load_missions(cfg, geo.SegmentFrob(org))
+	if len(ml) == 0 {
+		return nil
+	}
+	return ml[0].ms
+}
+
+func leg_description(l analysis.MissionLeg) string {
+	desc := fmt.Sprintf("Leg %.0f m, flown %.0f m in %.0f s<br/>Closest approach %.0f m<br/>Cross-track mean %.1f m, max %.1f m<br/>",
+		l.Length, l.Dist, l.End-l.Start, l.Closest, l.MeanXTE, l.MaxXTE)
+	if l.HasAlt {
+		desc += fmt.Sprintf("Altitude error mean %.1f m, max %.1f m<br/>", l.MeanAlt, l.MaxAlt)
+	}
+	if l.Overshoot > 0 {
+		desc += fmt.Sprintf("Overshoot %.1f m<br/>", l.Overshoot)
+	}
+	return desc
+}
+
+func getMissionAdherence(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec,
+	ms *mission.Mission, mr analysis.MissionReport) kml.Element {
+	var altoff float64
+	altmode := kml.AltitudeModeRelativeToGround
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		altoff = hpos.HomeAlt
+		altmode = kml.AltitudeModeAbsolute
+	}
+	f := kml.Folder(kml.Name("Mission adherence")).Add(kml.Visibility(false)).Add(
+		kml.Description(fmt.Sprintf("%d of %d legs reached<br/>Cross-track mean %.1f m, max %.1f m<br/>",
+			mr.Reached, len(mr.Legs), mr.MeanXTE, mr.MaxXTE)))
+	for _, l := range mr.Legs {
+		name := fmt.Sprintf("WP %d", l.No)
+		col := adh_reached
+		if !l.Reached {
+			name += " (not reached)"
+			col = adh_missed
+		}
+		mg := kml.MultiGeometry()
+		for _, s := range l.Samples {
+			r := rec.Items[s.Index]
+			mg.Add(kml.LineString(
+				kml.AltitudeMode(altmode),
+				kml.Coordinates(
+					kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: altoff + r.Alt},
+					kml.Coordinate{Lon: s.TLon, Lat: s.TLat, Alt: altoff + s.TAlt},
+				),
+			))
+		}
+		f.Add(kml.Placemark(
+			kml.Name(name),
+			kml.Visibility(false),
+			kml.Description(leg_description(l)),
+			kml.Style(
+				kml.LineStyle(kml.Color(col), kml.Width(1.5)),
+			).Add(balloon_style(BS_NAME_DESC)),
+			mg,
+		))
+	}
+	for _, no := range mr.Missed {
+		for _, mi := range ms.MissionItems {
+			if mi.No != no {
+				continue
+			}
+			f.Add(kml.Placemark(
+				kml.Name(fmt.Sprintf("Missed WP %d", no)),
+				kml.Visibility(false),
+				kml.Description(fmt.Sprintf("%s %d<br/>Position %s<br/>", mi.Action, no,
+					geo.PositionFormat(mi.Lat, mi.Lon, cfg.Dms))),
+				kml.Style(
+					kml.IconStyle(kml.Icon(kml.Href(icon.PaddleHref("red-stars")))),
+				).Add(balloon_style(BS_NAME_DESC)),
+				kml.Point(kml.Coordinates(kml.Coordinate{Lon: mi.Lon, Lat: mi.Lat})),
+			))
+		}
+	}
+	return f
+}
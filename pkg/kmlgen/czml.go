@@ -0,0 +1,277 @@
+package kmlgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"math"
+	"os"
+	"time"
+)
+
+import (
+	"geo"
+	"options"
+	"types"
+)
+
+/*
+ * CZML (CesiumJS) output. A CZML document is a JSON array of "packets";
+ * the first is the document (clock) packet. The aircraft has time
+ * dynamic position and orientation, and a trailing path. The flown track
+ * is also drawn as a polyline per flight mode segment, coloured as the
+ * KML flight mode points.
+ *
+ * Cesium heights are ellipsoidal, we use AMSL, so the track may be offset
+ * vertically by the local geoid separation.
+ */
+
+const czml_time = "2006-01-02T15:04:05.000Z"
+
+type czpacket map[string]interface{}
+
+func cz_rgba(c color.Color) []int {
+	r, g, b, a := c.RGBA()
+	return []int{int(r >> 8), int(g >> 8), int(b >> 8), int(a >> 8)}
+}
+
+func cz_solid(c color.Color) czpacket {
+	return czpacket{"solidColor": czpacket{"color": czpacket{"rgba": cz_rgba(c)}}}
+}
+
+func cz_interval(t0, t1 time.Time) string {
+	return t0.UTC().Format(czml_time) + "/" + t1.UTC().Format(czml_time)
+}
+
+type quat struct {
+	x, y, z, w float64
+}
+
+func qaxis(x, y, z, a float64) quat {
+	s := math.Sin(a / 2)
+	return quat{x * s, y * s, z * s, math.Cos(a / 2)}
+}
+
+func (p quat) mul(q quat) quat {
+	return quat{
+		p.w*q.x + p.x*q.w + p.y*q.z - p.z*q.y,
+		p.w*q.y - p.x*q.z + p.y*q.w + p.z*q.x,
+		p.w*q.z + p.x*q.y - p.y*q.x + p.z*q.w,
+		p.w*q.w - p.x*q.x - p.y*q.y - p.z*q.z,
+	}
+}
+
+// Earth fixed orientation for a body at lat, lon (degrees) with the
+// given heading, pitch and roll (degrees). As Cesium's
+// Transforms.headingPitchRollQuaternion(), but with heading from north
+// (Cesium's heading is from east, i.e. model +X).
+func cz_orientation(lat, lon, cse, pitch, roll float64) quat {
+	d2r := math.Pi / 180.0
+	enu := qaxis(0, 0, 1, lon*d2r+math.Pi/2).mul(qaxis(1, 0, 0, math.Pi/2-lat*d2r))
+	hpr := qaxis(0, 0, 1, -(cse-90)*d2r).mul(qaxis(0, 1, 0, -pitch*d2r)).mul(qaxis(1, 0, 0, roll*d2r))
+	return enu.mul(hpr)
+}
+
+// INAV pitch is +ve nose down (as gx_angle), Cesium's is +ve nose up;
+// roll is +ve right wing down for both.
+func cz_attitude(b types.LogItem) quat {
+	return cz_orientation(b.Lat, b.Lon, float64(b.Cse), -float64(b.Pitch), float64(b.Roll))
+}
+
+func cz_alt(hpos types.HomeRec, b types.LogItem) float64 {
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		return hpos.HomeAlt + b.Alt
+	}
+	return b.GAlt
+}
+
+func cz_point(id, name, desc string, lat, lon, alt float64, c color.Color) czpacket {
+	return czpacket{
+		"id":          id,
+		"name":        name,
+		"description": desc,
+		"position":    czpacket{"cartographicDegrees": []float64{lon, lat, alt}},
+		"point": czpacket{"pixelSize": 10, "color": czpacket{"rgba": cz_rgba(c)},
+			"outlineColor": czpacket{"rgba": []int{0, 0, 0, 255}}, "outlineWidth": 1},
+		"label": czpacket{"text": name, "font": "12pt sans-serif", "pixelOffset": czpacket{"cartesian2": []int{0, -20}},
+			"fillColor": czpacket{"rgba": []int{255, 255, 255, 255}}},
+	}
+}
+
+func cz_homes(cfg *options.Configuration, hpos types.HomeRec) []czpacket {
+	var pkts []czpacket
+	halt := 0.0
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		halt = hpos.HomeAlt
+	}
+	htext := "Home"
+	if (hpos.Flags & types.HOME_SAFE) == types.HOME_SAFE {
+		htext = "Armed"
+	}
+	pkts = append(pkts, cz_point("home", htext,
+		fmt.Sprintf("Location %s", geo.PositionFormat(hpos.HomeLat, hpos.HomeLon, cfg.Dms)),
+		hpos.HomeLat, hpos.HomeLon, halt, color.RGBA{R: 0xff, G: 0xa0, B: 0, A: 0xff}))
+	if (hpos.Flags & types.HOME_SAFE) == types.HOME_SAFE {
+		pkts = append(pkts, cz_point("safehome", "Home",
+			fmt.Sprintf("Location %s", geo.PositionFormat(hpos.SafeLat, hpos.SafeLon, cfg.Dms)),
+			hpos.SafeLat, hpos.SafeLon, halt, color.RGBA{R: 0, G: 0xc0, B: 0, A: 0xff}))
+	}
+	return pkts
+}
+
+func cz_missions(cfg *options.Configuration, hpos types.HomeRec, fb *geo.Frob) []czpacket {
+	var pkts []czpacket
+	addalt := 0.0
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		addalt = hpos.HomeAlt
+	}
+	for _, km := range load_missions(cfg, fb) {
+		mid := fmt.Sprintf("mission%d", km.idx)
+		pkts = append(pkts, czpacket{"id": mid, "name": fmt.Sprintf("Mission %d", km.idx)})
+		var line []float64
+		for _, mi := range km.ms.MissionItems {
+			if !mi.Is_GeoPoint() {
+				continue
+			}
+			alt := float64(mi.Alt)
+			if mi.P3 == 0 {
+				alt += addalt
+			}
+			p := cz_point(fmt.Sprintf("%s-wp%d", mid, mi.No), fmt.Sprintf("WP %d", mi.No), mi.Action,
+				mi.Lat, mi.Lon, alt, color.RGBA{R: 0, G: 0xff, B: 0xff, A: 0xff})
+			p["parent"] = mid
+			pkts = append(pkts, p)
+			line = append(line, mi.Lon, mi.Lat, alt)
+		}
+		if len(line) > 3 {
+			pkts = append(pkts, czpacket{"id": mid + "-route", "parent": mid,
+				"polyline": czpacket{"positions": czpacket{"cartographicDegrees": line}, "width": 2,
+					"material": czpacket{"polylineDash": czpacket{"color": czpacket{"rgba": []int{0, 0xff, 0xff, 0xc0}}}}}})
+		}
+	}
+	return pkts
+}
+
+// Flight mode for colouring, failsafe overrides the mode
+func cz_fmode(b types.LogItem) uint8 {
+	if (b.Status & types.Is_FAIL) == types.Is_FAIL {
+		return types.FM_FS
+	}
+	return b.Fmode
+}
+
+func cz_fmtext(b types.LogItem) string {
+	if (b.Status & types.Is_FAIL) == types.Is_FAIL {
+		return b.Fmtext + " FAILSAFE"
+	}
+	return b.Fmtext
+}
+
+// Index ranges [start, end) of the flight mode segments
+func cz_mode_ranges(items []types.LogItem) [][2]int {
+	var r [][2]int
+	st := 0
+	for j := 1; j <= len(items); j++ {
+		if j == len(items) || cz_fmtext(items[j]) != cz_fmtext(items[st]) {
+			r = append(r, [2]int{st, j})
+			st = j
+		}
+	}
+	return r
+}
+
+// A polyline per flight mode segment; each segment includes the first
+// point of the next so the line is continuous.
+func cz_mode_segments(hpos types.HomeRec, items []types.LogItem, mr [][2]int) []czpacket {
+	var pkts []czpacket
+	for n, r := range mr {
+		var line []float64
+		for j := r[0]; j <= r[1] && j < len(items); j++ {
+			line = append(line, items[j].Lon, items[j].Lat, cz_alt(hpos, items[j]))
+		}
+		if len(line) < 6 {
+			continue
+		}
+		b := items[r[0]]
+		pkts = append(pkts, czpacket{"id": fmt.Sprintf("track-%d", n), "parent": "track", "name": cz_fmtext(b),
+			"polyline": czpacket{"positions": czpacket{"cartographicDegrees": line}, "width": 3,
+				"material": cz_solid(getflightColour(cz_fmode(b)))}})
+	}
+	return pkts
+}
+
+func GenerateCZML(cfg *options.Configuration, hpos types.HomeRec, rec types.LogRec, org types.RebaseOrigin,
+	outfn string, meta types.FlightMeta, smap types.MapRec, gv func() string) error {
+	items := rec.Items
+	if len(items) == 0 {
+		return fmt.Errorf("%s: no data", meta.LogName())
+	}
+	t0 := items[0].Utc
+	t1 := items[len(items)-1].Utc
+	ival := cz_interval(t0, t1)
+
+	var desc string
+	for k, v := range meta.Summary() {
+		desc = desc + fmt.Sprintf("%s: %s<br/>", k, v)
+	}
+	for k, v := range smap {
+		desc = desc + fmt.Sprintf("%s: %s<br/>", k, v)
+	}
+	if s, ok := meta.ShowDisarm(); ok {
+		desc = desc + fmt.Sprintf("Disarm: %s<br/>", s)
+	}
+	desc = desc + fmt.Sprintf("Generator: %s", gv())
+
+	pkts := []czpacket{
+		{"id": "document", "name": meta.LogName(), "version": "1.0",
+			"clock": czpacket{"interval": ival, "currentTime": t0.UTC().Format(czml_time),
+				"multiplier": 1, "range": "LOOP_STOP", "step": "SYSTEM_CLOCK_MULTIPLIER"}},
+	}
+
+	pos := make([]float64, 0, 4*len(items))
+	ori := make([]float64, 0, 5*len(items))
+	for _, b := range items {
+		ts := b.Utc.Sub(t0).Seconds()
+		pos = append(pos, ts, b.Lon, b.Lat, cz_alt(hpos, b))
+		q := cz_attitude(b)
+		ori = append(ori, ts, q.x, q.y, q.z, q.w)
+	}
+
+	// Time dynamic colour for the aircraft and its trail
+	mr := cz_mode_ranges(items)
+	var mcol []czpacket
+	for _, r := range mr {
+		te := t1
+		if r[1] < len(items) {
+			te = items[r[1]].Utc
+		}
+		mcol = append(mcol, czpacket{"interval": cz_interval(items[r[0]].Utc, te),
+			"rgba": cz_rgba(getflightColour(cz_fmode(items[r[0]])))})
+	}
+
+	pkts = append(pkts, czpacket{
+		"id":           "aircraft",
+		"name":         meta.LogName(),
+		"description":  desc,
+		"availability": ival,
+		"position": czpacket{"epoch": t0.UTC().Format(czml_time), "cartographicDegrees": pos,
+			"interpolationAlgorithm": "LAGRANGE", "interpolationDegree": 1},
+		"orientation": czpacket{"epoch": t0.UTC().Format(czml_time), "unitQuaternion": ori},
+		"point":       czpacket{"pixelSize": 8, "color": mcol, "outlineColor": czpacket{"rgba": []int{0, 0, 0, 255}}, "outlineWidth": 1},
+		"path": czpacket{"width": 2, "leadTime": 0, "trailTime": t1.Sub(t0).Seconds(), "resolution": 1,
+			"material": czpacket{"solidColor": czpacket{"color": mcol}}},
+	})
+
+	pkts = append(pkts, czpacket{"id": "track", "name": "Flight modes"})
+	pkts = append(pkts, cz_mode_segments(hpos, items, mr)...)
+	pkts = append(pkts, cz_homes(cfg, hpos)...)
+	pkts = append(pkts, cz_missions(cfg, hpos, geo.SegmentFrob(org))...)
+
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	enc := json.NewEncoder(fh)
+	return enc.Encode(pkts)
+}
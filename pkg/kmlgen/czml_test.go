@@ -0,0 +1,40 @@
+package kmlgen
+
+import (
+	"math"
+	"testing"
+)
+
+import (
+	"types"
+)
+
+// Rotates v by q
+func (q quat) rotate(v [3]float64) [3]float64 {
+	p := q.mul(quat{v[0], v[1], v[2], 0}).mul(quat{-q.x, -q.y, -q.z, q.w})
+	return [3]float64{p.x, p.y, p.z}
+}
+
+// 30° nose down, heading north at 0,0, where ECEF +X is up, +Y east and
+// +Z north. The model's nose (+X) should point north and down, with the
+// wings (+Y is the left wing) level. KML tilt is +ve nose down.
+func TestNoseDownAttitude(t *testing.T) {
+	b := types.LogItem{Lat: 0, Lon: 0, Cse: 0, Pitch: 30}
+	q := cz_attitude(b)
+	want := [][2][3]float64{
+		{{1, 0, 0}, {-0.5, 0, math.Sqrt(3) / 2}},
+		{{0, 1, 0}, {0, -1, 0}},
+	}
+	for _, w := range want {
+		v := q.rotate(w[0])
+		for j := range v {
+			if math.Abs(v[j]-w[1][j]) > 1e-9 {
+				t.Errorf("CZML: %v rotates to %v, want %v", w[0], v, w[1])
+				break
+			}
+		}
+	}
+	if a := gx_angle(b); a.Tilt != 30 || a.Heading != 0 || a.Roll != 0 {
+		t.Errorf("KML: %+v, want tilt 30", a)
+	}
+}
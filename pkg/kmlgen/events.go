@@ -0,0 +1,65 @@
+package kmlgen
+
+import (
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"github.com/twpayne/go-kml/icon"
+)
+
+import (
+	"geo"
+	"options"
+	"types"
+)
+
+var event_icons = map[types.EventType]string{
+	types.EVT_TAKEOFF:      "grn-circle",
+	types.EVT_LANDING:      "blu-circle",
+	types.EVT_MODE:         "wht-circle",
+	types.EVT_FAILSAFE:     "red-stars",
+	types.EVT_FAILSAFE_END: "grn-stars",
+	types.EVT_GPS_LOST:     "ylw-square",
+	types.EVT_GPS_OK:       "ltblu-square",
+	types.EVT_HWFAIL:       "red-square",
+	types.EVT_HWFAIL_END:   "grn-square",
+	types.EVT_LOW_VOLTS:    "ylw-stars",
+	types.EVT_MAX_ALT:      "purple-diamond",
+	types.EVT_MAX_RANGE:    "purple-diamond",
+	types.EVT_MAX_SPEED:    "purple-diamond",
+}
+
+func getEvents(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, evs []types.FlightEvent) kml.Element {
+	f := kml.Folder(kml.Name("Events")).Add(kml.Visibility(true))
+	for _, e := range evs {
+		r := rec.Items[e.Index]
+		alt := r.Alt
+		altmode := kml.AltitudeModeRelativeToGround
+		if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+			alt += hpos.HomeAlt
+			altmode = kml.AltitudeModeAbsolute
+		}
+		desc := fmt.Sprintf("Time %s (%s)<br/>Position %s<br/>Elevation %.0f m<br/>Speed %.1f m/s<br/>Mode %s<br/>",
+			e.ShowTime(), r.Utc.Format("15:04:05"), geo.PositionFormat(r.Lat, r.Lon, cfg.Dms), r.Alt, r.Spd, r.Fmtext)
+		if r.Volts > 0 {
+			desc += fmt.Sprintf("Voltage %.1f V<br/>", r.Volts)
+		}
+		k := kml.Placemark(
+			kml.Name(e.Text),
+			kml.Description(desc),
+			kml.TimeStamp(kml.When(r.Utc)),
+			kml.Style(
+				kml.IconStyle(
+					kml.Icon(
+						kml.Href(icon.PaddleHref(event_icons[e.Type])),
+					),
+				),
+			).Add(balloon_style(BS_NAME_DESC)),
+			kml.Point(
+				kml.AltitudeMode(altmode),
+				kml.Coordinates(kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: alt}),
+			),
+		)
+		f.Add(k)
+	}
+	return f
+}
@@ -3,12 +3,17 @@ package kmlgen
 import (
 	"fmt"
 	kml "github.com/twpayne/go-kml"
+	"github.com/twpayne/go-kml/icon"
+	"image/color"
 )
 
 import (
+	"analysis"
 	"cli"
 	"geo"
+	"options"
 	"styles"
+	"types"
 )
 
 func get_style(t int) string {
@@ -103,3 +108,68 @@ func Gen_geozones(gzones []cli.GeoZone, fb *geo.Frob) kml.Element {
 	}
 	return d
 }
+
+// The -cli file geozones, rebased to the segment's origin (as drawn) if
+// required. For a circle, Points[1].Lat is the radius (m).
+func LoadGeozones(cfg *options.Configuration, org types.RebaseOrigin) []cli.GeoZone {
+	if len(cfg.Cli) == 0 {
+		return nil
+	}
+	_, _, gzone := cli.Read_clifile(cfg.Cli)
+	if fb := geo.SegmentFrob(org); fb != nil {
+		for k := range gzone {
+			pts := gzone[k].Points
+			if gzone[k].Shape == cli.SHAPE_CIRCLE && len(pts) > 1 {
+				pts = pts[:1]
+			}
+			for j := range pts {
+				pts[j].Lat, pts[j].Lon, _ = fb.Relocate(pts[j].Lat, pts[j].Lon, 0)
+			}
+		}
+	}
+	return gzone
+}
+
+var zone_violation = color.RGBA{R: 0xff, G: 0x00, B: 0xff, A: 0xff}
+
+// The track while in violation of a geozone, and a marker at the start
+// of each violation
+func getGeozoneViolations(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, gr analysis.GeozoneReport) kml.Element {
+	var altoff float64
+	altmode := kml.AltitudeModeRelativeToGround
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		altoff = hpos.HomeAlt
+		altmode = kml.AltitudeModeAbsolute
+	}
+	f := kml.Folder(kml.Name("Geozone violations")).Add(kml.Visibility(true))
+	for _, z := range gr.Intervals {
+		if !z.Violation {
+			continue
+		}
+		r := rec.Items[z.First]
+		desc := fmt.Sprintf("%s<br/>Entry %s, exit %s<br/>Action %s<br/>Position %s<br/>Elevation %.0f m<br/>",
+			z.Text(), r.Utc.Format("15:04:05"), rec.Items[z.Last].Utc.Format("15:04:05"),
+			z.Action, geo.PositionFormat(r.Lat, r.Lon, cfg.Dms), r.Alt)
+		name := fmt.Sprintf("Zone %d", z.Zid)
+		if z.Zid == analysis.ZONE_OUTSIDE_INC {
+			name = "Outside inclusive zones"
+		}
+		f.Add(kml.Placemark(
+			kml.Name(name),
+			kml.Description(desc),
+			kml.TimeSpan(kml.Begin(r.Utc), kml.End(rec.Items[z.Last].Utc)),
+			kml.Style(
+				kml.IconStyle(kml.Icon(kml.Href(icon.PaddleHref("pink-stars")))),
+				kml.LineStyle(kml.Color(zone_violation), kml.Width(4)),
+			).Add(balloon_style(BS_NAME_DESC)),
+			kml.MultiGeometry(
+				kml.Point(
+					kml.AltitudeMode(altmode),
+					kml.Coordinates(kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: altoff + r.Alt}),
+				),
+				track_segment(rec.Items[z.First:z.Last+1], altmode, altoff),
+			),
+		))
+	}
+	return f
+}
@@ -0,0 +1,210 @@
+package kmlgen
+
+import (
+	"encoding/xml"
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+import (
+	"options"
+	"types"
+)
+
+/*
+ * Compact output. Rather than a Placemark (with an HTML description) per
+ * point, each layer is a small number of Placemarks, one per colour
+ * style, each being a gx:MultiTrack of the gx:Track segments drawn in
+ * that style. Each segment starts at the last point of the previous
+ * segment, so the track is continuous. The per-point values are carried
+ * as gx:SimpleArrayData (in the flight mode layer only), which Google
+ * Earth shows in the balloon and the elevation profile.
+ */
+
+const TRACK_SCHEMA = "fl2xTrack"
+
+type trackfield struct {
+	name  string
+	dname string
+	ftype string
+	get   func(r *types.LogItem) string
+}
+
+func track_fields(cfg *options.Configuration, rec types.LogRec) []trackfield {
+	flds := []trackfield{
+		{"mode", "Mode", "string", func(r *types.LogItem) string {
+			if (r.Status & types.Is_FAIL) == types.Is_FAIL {
+				return r.Fmtext + " FAILSAFE"
+			}
+			return r.Fmtext
+		}},
+		{"elevation", "Elevation (m)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.0f", r.Alt) }},
+		{"galt", "GPS Altitude (m)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.0f", r.GAlt) }},
+		{"speed", "Speed (m/s)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.1f", r.Spd) }},
+		{"course", "Heading / CoG", "string", func(r *types.LogItem) string { return fmt.Sprintf("%d° / %d°", r.Cse, r.Cog) }},
+		{"sats", "Satellites", "int", func(r *types.LogItem) string { return fmt.Sprintf("%d", r.Numsat) }},
+		{"range", "Range (m)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.0f", r.Vrange) }},
+		{"bearing", "Bearing", "int", func(r *types.LogItem) string { return fmt.Sprintf("%d", r.Bearing) }},
+		{"rssi", "RSSI (%)", "int", func(r *types.LogItem) string { return fmt.Sprintf("%d", r.Rssi) }},
+		{"distance", "Distance (m)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.0f", r.Tdist) }},
+		{"volts", "Voltage (V)", "float", func(r *types.LogItem) string { return fmt.Sprintf("%.1f", r.Volts) }},
+	}
+	if (rec.Cap & types.CAP_AMPS) == types.CAP_AMPS {
+		flds = append(flds, trackfield{"amps", "Current (A)", "float",
+			func(r *types.LogItem) string { return fmt.Sprintf("%.1f", r.Amps) }})
+		if (rec.Cap & types.CAP_ENERGY) == types.CAP_ENERGY {
+			if cfg.Engunit == "wh" {
+				flds = append(flds, trackfield{"energy", "Energy (Wh)", "float",
+					func(r *types.LogItem) string { return fmt.Sprintf("%.2f", r.WhAcc) }},
+					trackfield{"effic", "Efficiency (Wh/km)", "float",
+						func(r *types.LogItem) string { return fmt.Sprintf("%.2f", r.Whkm) }})
+			} else {
+				flds = append(flds, trackfield{"energy", "Energy (mAh)", "float",
+					func(r *types.LogItem) string { return fmt.Sprintf("%.0f", r.Energy) }},
+					trackfield{"effic", "Efficiency (mAh/km)", "float",
+						func(r *types.LogItem) string { return fmt.Sprintf("%.1f", r.Effic) }})
+			}
+		}
+	}
+	if (rec.Cap & types.CAP_LQ) == types.CAP_LQ {
+		flds = append(flds, trackfield{"lq", "Link quality (%)", "int",
+			func(r *types.LogItem) string { return fmt.Sprintf("%d", r.LQ) }})
+	}
+	if (rec.Cap & types.CAP_AGL) == types.CAP_AGL {
+		flds = append(flds, trackfield{"agl", "Terrain clearance (m)", "float",
+			func(r *types.LogItem) string {
+				if math.IsNaN(r.Agl) {
+					return ""
+				}
+				return fmt.Sprintf("%.0f", r.Agl)
+			}})
+	}
+	return flds
+}
+
+// The Schema must be a child of the Document
+func track_schema(cfg *options.Configuration, rec types.LogRec) kml.Element {
+	s := kml.Schema(TRACK_SCHEMA, TRACK_SCHEMA)
+	for _, f := range track_fields(cfg, rec) {
+		s.Add(kml.GxSimpleArrayField(f.name, f.ftype).Add(kml.DisplayName(f.dname)))
+	}
+	return s
+}
+
+func array_data(name string, vals []kml.Element) *kml.CompoundElement {
+	sd := kml.GxSimpleArrayData(vals...)
+	sd.Attr = append(sd.Attr, xml.Attr{Name: xml.Name{Local: "name"}, Value: name})
+	return sd
+}
+
+// INAV pitch is +ve nose down, roll +ve right wing down. KML rotations are
+// clockwise about the axis, i.e. +ve tilt is nose down, +ve roll is right
+// wing up.
+func gx_angle(r types.LogItem) kml.GxAngle {
+	return kml.GxAngle{Heading: float64(r.Cse), Tilt: float64(r.Pitch), Roll: float64(-r.Roll)}
+}
+
+// The tail elements (Model, ExtendedData) follow the per-point values
+func gx_track(cfg *options.Configuration, hpos types.HomeRec, items []types.LogItem, tail ...kml.Element) kml.Element {
+	var altoff float64
+	altmode := kml.AltitudeModeRelativeToGround
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		altoff = hpos.HomeAlt
+		altmode = kml.AltitudeModeAbsolute
+	}
+	t := kml.GxTrack(kml.AltitudeMode(altmode))
+	if cfg.Extrude {
+		t.Add(kml.Extrude(true))
+	}
+	for _, r := range items {
+		t.Add(kml.When(r.Utc))
+	}
+	for _, r := range items {
+		t.Add(kml.GxCoord(kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: altoff + r.Alt}))
+	}
+	for _, r := range items {
+		t.Add(kml.GxAngles(gx_angle(r)))
+	}
+	return t.Add(tail...)
+}
+
+func track_data(cfg *options.Configuration, rec types.LogRec, items []types.LogItem) kml.Element {
+	sd := kml.SchemaData("#" + TRACK_SCHEMA)
+	for _, f := range track_fields(cfg, rec) {
+		vals := make([]kml.Element, len(items))
+		for j := range items {
+			vals[j] = kml.GxValue(f.get(&items[j]))
+		}
+		sd.Add(array_data(f.name, vals))
+	}
+	return kml.ExtendedData(sd)
+}
+
+func getTracks(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, urls []string, ismode bool, viz bool) []kml.Element {
+	items := rec.Items
+
+	// segments, grouped by style, in order of first use
+	type tgroup struct {
+		url   string
+		names map[string]bool
+		segs  [][2]int
+	}
+	var groups []*tgroup
+	gmap := make(map[string]*tgroup)
+	st := 0
+	for j := 1; j <= len(items); j++ {
+		if j == len(items) || urls[j] != urls[st] {
+			g, ok := gmap[urls[st]]
+			if !ok {
+				g = &tgroup{url: urls[st], names: make(map[string]bool)}
+				gmap[urls[st]] = g
+				groups = append(groups, g)
+			}
+			s0 := st
+			if s0 > 0 {
+				s0--
+			}
+			g.segs = append(g.segs, [2]int{s0, j})
+			g.names[items[st].Fmtext] = true
+			st = j
+		}
+	}
+
+	var pt []kml.Element
+	for _, g := range groups {
+		var name string
+		if ismode {
+			var nl []string
+			for k := range g.names {
+				nl = append(nl, k)
+			}
+			sort.Strings(nl)
+			name = strings.Join(nl, ", ")
+		} else if g.url == "#"+nodata_style {
+			name = "No data"
+		} else {
+			pct, _ := strconv.Atoi(g.url[len(g.url)-3:])
+			name = fmt.Sprintf("%d%%", pct)
+		}
+		mt := kml.GxMultiTrack(kml.GxInterpolate(false))
+		for _, sg := range g.segs {
+			seg := items[sg[0]:sg[1]]
+			if ismode {
+				mt.Add(gx_track(cfg, hpos, seg, track_data(cfg, rec, seg)))
+			} else {
+				mt.Add(gx_track(cfg, hpos, seg))
+			}
+		}
+		k := kml.Placemark(kml.Name(name), kml.StyleURL(g.url))
+		if cfg.Visibility != -1 {
+			k.Add(kml.Visibility(cfg.Visibility == 1 || viz))
+		}
+		k.Add(mt)
+		pt = append(pt, k)
+	}
+	return pt
+}
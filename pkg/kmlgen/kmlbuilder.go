@@ -2,12 +2,11 @@ package kmlgen
 
 import (
 	"fmt"
-	"github.com/bmizerany/perks/quantile"
 	kml "github.com/twpayne/go-kml"
 	"github.com/twpayne/go-kml/icon"
 	kmz "github.com/twpayne/go-kmz"
 	"image/color"
-	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
@@ -23,12 +22,6 @@ import (
 const (
 	BS_NAME_DESC = iota
 	BS_DESC_ONLY
-	COL_STYLE_MODE
-	COL_STYLE_RSSI
-	COL_STYLE_EFFIC
-	COL_STYLE_SPEED
-	COL_STYLE_ALTITUDE
-	COL_STYLE_BATTERY
 )
 
 func getflightColour(mode uint8) color.Color {
@@ -56,39 +49,36 @@ func getflightColour(mode uint8) color.Color {
 	return c
 }
 
-func getStyleURL(r types.LogItem, colmode uint8) string {
+func getStyleURL(r types.LogItem) string {
 	var s string
-	if colmode == COL_STYLE_RSSI {
-		s = fmt.Sprintf("#styleGrad%03d", 5*(r.Rssi/5))
-	} else if colmode == COL_STYLE_EFFIC {
-		s = fmt.Sprintf("#styleGrad%03d", 5*(int(r.Qval)/5))
-	} else if colmode == COL_STYLE_SPEED {
-		s = fmt.Sprintf("#styleGrad%03d", 5*(int(r.Sval)/5))
-	} else if colmode == COL_STYLE_ALTITUDE {
-		s = fmt.Sprintf("#styleGrad%03d", 5*(int(r.Aval)/5))
-	} else if colmode == COL_STYLE_BATTERY {
-		s = fmt.Sprintf("#styleGrad%03d", 5*(int(r.Bval)/5))
-	} else {
-		switch r.Fmode {
-		case types.FM_LAUNCH:
-			s = "#styleLaunch"
-		case types.FM_RTH:
-			s = "#styleRTH"
-		case types.FM_WP:
-			s = "#styleWP"
-		case types.FM_CRUISE3D, types.FM_CRUISE2D:
-			s = "#styleCRS"
-		case types.FM_PH:
-			s = "#stylePH"
-		case types.FM_EMERG:
-			s = "#styleEMERG"
-		default:
-			s = "#styleNormal"
-		}
+	switch r.Fmode {
+	case types.FM_LAUNCH:
+		s = "#styleLaunch"
+	case types.FM_RTH:
+		s = "#styleRTH"
+	case types.FM_WP:
+		s = "#styleWP"
+	case types.FM_CRUISE3D, types.FM_CRUISE2D:
+		s = "#styleCRS"
+	case types.FM_PH:
+		s = "#stylePH"
+	case types.FM_EMERG:
+		s = "#styleEMERG"
+	default:
+		s = "#styleNormal"
 	}
 	return s
 }
 
+// Flight mode style URL for each item
+func mode_styles_urls(rec types.LogRec) []string {
+	urls := make([]string, len(rec.Items))
+	for j, r := range rec.Items {
+		urls[j] = getStyleURL(r)
+	}
+	return urls
+}
+
 func makeqval(val, vmin, vmax float64, invert bool) float64 {
 	var rval float64
 
@@ -112,54 +102,14 @@ func makeqval(val, vmin, vmax float64, invert bool) float64 {
 	return rval
 }
 
-func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []kml.Element {
+func getPoints(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, urls []string, viz bool) []kml.Element {
 	var pt []kml.Element
-	var qval0, qval1 float64
-	if colmode == COL_STYLE_EFFIC {
-		q := quantile.NewTargeted(0.05, 0.95)
-		for _, r := range rec.Items {
-			if options.Config.Engunit == "wh" {
-				q.Insert(r.Whkm)
-			} else {
-				q.Insert(r.Effic)
-			}
-		}
-		qval0 = q.Query(0.05)
-		qval1 = q.Query(0.95)
-	} else if colmode == COL_STYLE_SPEED {
-		q := quantile.NewTargeted(0.05, 0.95)
-		for _, r := range rec.Items {
-			q.Insert(r.Spd)
-		}
-		qval0 = q.Query(0.05)
-		qval1 = q.Query(0.95)
-	} else if colmode == COL_STYLE_ALTITUDE {
-		q := quantile.NewTargeted(0.05, 0.95)
-		for _, r := range rec.Items {
-			q.Insert(r.Alt)
-		}
-		qval0 = q.Query(0.05)
-		qval1 = q.Query(0.95)
-	} else if colmode == COL_STYLE_BATTERY {
-		q := quantile.NewTargeted(0.05, 0.95)
-		for _, r := range rec.Items {
-			q.Insert(r.Volts)
-		}
-		qval0 = q.Query(0.05)
-		qval1 = q.Query(0.95)
-	}
 
 	tpts := len(rec.Items)
-	effic := 0.0
 
 	startt := rec.Items[0].Stamp
 
 	for np, r := range rec.Items {
-		if options.Config.Engunit == "wh" {
-			effic = r.Whkm
-		} else {
-			effic = r.Effic
-		}
 
 		tfmt := r.Utc.Format("2006‑01‑02T15:04:05.99MST")
 		fmtxt := r.Fmtext
@@ -175,16 +125,6 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 			alt = r.Alt
 			altmode = kml.AltitudeModeRelativeToGround
 		}
-		if colmode == COL_STYLE_EFFIC {
-			r.Qval = makeqval(effic, qval0, qval1, true)
-		} else if colmode == COL_STYLE_SPEED {
-			r.Sval = makeqval(r.Spd, qval0, qval1, options.Config.RedIsFast)
-		} else if colmode == COL_STYLE_ALTITUDE {
-			r.Aval = makeqval(r.Alt, qval0, qval1, !options.Config.RedIsLow)
-		} else if colmode == COL_STYLE_BATTERY {
-			r.Bval = makeqval(r.Volts, qval0, qval1, false)
-		}
-
 		et := float64(r.Stamp-startt) / 1e6
 
 		var sb strings.Builder
@@ -193,9 +133,12 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 		sb.Write([]byte(`<table style="border="1px" silver; border="1" silver; rules="all";;">`))
 
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%s</td></tr>", "Time", tfmt)))
-		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%s</td></tr>", "Position", geo.PositionFormat(r.Lat, r.Lon, options.Config.Dms))))
+		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%s</td></tr>", "Position", geo.PositionFormat(r.Lat, r.Lon, cfg.Dms))))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.0f m</td></tr>", "Elevation", r.Alt)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.0f m</td></tr>", "GPS Altitude", alt)))
+		if (rec.Cap&types.CAP_AGL) == types.CAP_AGL && !math.IsNaN(r.Agl) {
+			sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.0f m</td></tr>", "Terrain clearance", r.Agl)))
+		}
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%d° / %d°</td></tr>", "Heading / CoG", r.Cse, r.Cog)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.1f m/s</td></tr>", "Speed", r.Spd)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%d</td></tr>", "Satellites", r.Numsat)))
@@ -203,6 +146,9 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.0f m</td></tr>", "Range", r.Vrange)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%d°</td></tr>", "Bearing", r.Bearing)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%d %%</td></tr>", "RSSI", r.Rssi)))
+		if (rec.Cap & types.CAP_LQ) == types.CAP_LQ {
+			sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%d %%</td></tr>", "Link quality", r.LQ)))
+		}
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%s</td></tr>", "Mode", fmtxt)))
 		sb.Write([]byte(fmt.Sprintf("<tr><td><b>%s</b></td><td>%.0f m</td></tr>", "Cumulative Distance", r.Tdist)))
 		if r.Volts > 0 {
@@ -228,10 +174,10 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 		k := kml.Placemark(
 			kml.Description(sb.String()),
 			kml.TimeStamp(kml.When(r.Utc)),
-			kml.StyleURL(getStyleURL(r, colmode)),
+			kml.StyleURL(urls[np]),
 		)
-		if options.Config.Visibility != -1 {
-			if options.Config.Visibility == 1 {
+		if cfg.Visibility != -1 {
+			if cfg.Visibility == 1 {
 				k.Add(kml.Visibility(true))
 			} else {
 				k.Add(kml.Visibility(viz))
@@ -239,7 +185,7 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 		}
 		se := kml.Style()
 
-		if options.Config.Extrude {
+		if cfg.Extrude {
 			po.Add(
 				kml.Extrude(true),
 				kml.Tessellate(false),
@@ -261,7 +207,7 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 			)
 		}
 
-		if options.Config.Extrude || (r.Status&types.Is_FAIL) == types.Is_FAIL {
+		if cfg.Extrude || (r.Status&types.Is_FAIL) == types.Is_FAIL {
 			k.Add(se)
 		}
 		k.Add(po)
@@ -270,7 +216,7 @@ func getPoints(rec types.LogRec, hpos types.HomeRec, colmode uint8, viz bool) []
 	return pt
 }
 
-func getHomes(hpos types.HomeRec) []kml.Element {
+func getHomes(cfg *options.Configuration, hpos types.HomeRec) []kml.Element {
 	var htext, hdesc string
 
 	if (hpos.Flags & types.HOME_SAFE) == types.HOME_SAFE {
@@ -279,7 +225,7 @@ func getHomes(hpos types.HomeRec) []kml.Element {
 		htext = "Home"
 	}
 	hdesc = fmt.Sprintf("Location %s<br/>",
-		geo.PositionFormat(hpos.HomeLat, hpos.HomeLon, options.Config.Dms))
+		geo.PositionFormat(hpos.HomeLat, hpos.HomeLon, cfg.Dms))
 	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
 		hdesc = hdesc + fmt.Sprintf("GPS Altitude: %.0fm<br/>", hpos.HomeAlt)
 	}
@@ -303,7 +249,7 @@ func getHomes(hpos types.HomeRec) []kml.Element {
 		k = kml.Placemark(
 			kml.Name("Home"),
 			kml.Description(fmt.Sprintf("Location %s<br/>",
-				geo.PositionFormat(hpos.SafeLat, hpos.SafeLon, options.Config.Dms))),
+				geo.PositionFormat(hpos.SafeLat, hpos.SafeLon, cfg.Dms))),
 			kml.Point(
 				kml.Coordinates(kml.Coordinate{Lon: hpos.SafeLon, Lat: hpos.SafeLat}),
 			),
@@ -330,132 +276,61 @@ func balloon_style(bs uint8) *kml.CompoundElement {
 	}
 }
 
-func generate_shared_styles(style uint8) []kml.Element {
-	switch style {
-	default:
-		return []kml.Element{
-			kml.SharedStyle(
-				"styleNormal",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_ACRO)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleLaunch",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_LAUNCH)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleWP",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_WP)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleRTH",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_RTH)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleCRS",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_CRUISE3D)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"stylePH",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_PH)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleAH",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_AH)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleFS",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_FS)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-			kml.SharedStyle(
-				"styleEMERG",
-				kml.IconStyle(
-					kml.Scale(0.5),
-					kml.Color(getflightColour(types.FM_EMERG)),
-					kml.Icon(
-						kml.Href(icon.PaletteHref(2, 18)),
-					),
-				),
-			).Add(balloon_style(BS_DESC_ONLY)),
-		}
-	case COL_STYLE_RSSI:
-		{
-			gidx := 0
-
-			switch options.Config.Gradset {
-			case "rdgn":
-				gidx = GRAD_RGN
-			case "yor":
-				gidx = GRAD_YOR
-			default:
-				gidx = GRAD_RED
-			}
-			gcols := Get_gradset(gidx)
-			icons := []kml.Element{}
-			for j, c := range gcols {
-				sname := fmt.Sprintf("styleGrad%03d", j*5)
-				el := kml.SharedStyle(
-					sname,
-					kml.IconStyle(
-						kml.Scale(0.5),
-						kml.Color(color.RGBA{R: c.R, G: c.G, B: c.B, A: c.A}),
-						kml.Icon(
-							kml.Href(icon.PaletteHref(2, 18)),
-						),
-					),
-				).Add(balloon_style(BS_DESC_ONLY))
-				icons = append(icons, el)
-			}
-			return icons
-		}
+var mode_styles = []struct {
+	id   string
+	mode uint8
+}{
+	{"styleNormal", types.FM_ACRO},
+	{"styleLaunch", types.FM_LAUNCH},
+	{"styleWP", types.FM_WP},
+	{"styleRTH", types.FM_RTH},
+	{"styleCRS", types.FM_CRUISE3D},
+	{"stylePH", types.FM_PH},
+	{"styleAH", types.FM_AH},
+	{"styleFS", types.FM_FS},
+	{"styleEMERG", types.FM_EMERG},
+}
+
+// Point (icon) style; for compact (gx:Track) output, the track line is
+// the same colour.
+func point_style(cfg *options.Configuration, id string, c color.Color) kml.Element {
+	el := kml.SharedStyle(
+		id,
+		kml.IconStyle(
+			kml.Scale(0.5),
+			kml.Color(c),
+			kml.Icon(
+				kml.Href(icon.PaletteHref(2, 18)),
+			),
+		),
+	)
+	if cfg.Compact {
+		el.Add(kml.LineStyle(kml.Width(4), kml.Color(c)))
 	}
+	el.Add(balloon_style(BS_DESC_ONLY))
+	return el
+}
+
+func generate_shared_styles(cfg *options.Configuration) []kml.Element {
+	styles := []kml.Element{}
+	for _, ms := range mode_styles {
+		styles = append(styles, point_style(cfg, ms.id, getflightColour(ms.mode)))
+	}
+	return styles
+}
+
+func gradient_styles(cfg *options.Configuration, gname string) []kml.Element {
+	if gname == "" {
+		gname = cfg.Gradset
+	}
+	gcols, _ := get_gradient(gname)
+	icons := []kml.Element{}
+	for j, c := range gcols {
+		sname := fmt.Sprintf("%s%03d", grad_style_name(cfg, gname), j*5)
+		el := point_style(cfg, sname, color.RGBA{R: c.R, G: c.G, B: c.B, A: c.A})
+		icons = append(icons, el)
+	}
+	return icons
 }
 
 func add_ground_track(rec types.LogRec) kml.Element {
@@ -479,7 +354,7 @@ func add_ground_track(rec types.LogRec) kml.Element {
 	return f
 }
 
-func GenerateCliOnly(outfn string, gv func() string) {
+func GenerateCliOnly(outfn string, gv func() string) error {
 	fb := geo.Getfrobnication()
 	kname := filepath.Base(options.Config.Cli)
 	desc := fmt.Sprintf("Generator: %s", gv())
@@ -488,10 +363,10 @@ func GenerateCliOnly(outfn string, gv func() string) {
 	for _, s := range sfx {
 		d.Add(s)
 	}
-	write_kml(outfn, d)
+	return write_kml(outfn, nil, d)
 }
 
-func GenerateMissionOnly(outfn string, gv func() string) {
+func GenerateMissionOnly(outfn string, gv func() string) error {
 	kname := filepath.Base(options.Config.Mission)
 	desc := fmt.Sprintf("Generator: %s", gv())
 	d := kml.Folder(kml.Name(kname)).Add(kml.Description(desc)).Add(kml.Open(true))
@@ -532,61 +407,103 @@ func GenerateMissionOnly(outfn string, gv func() string) {
 				d.Add(s)
 			}
 		}
-		write_kml(outfn, d)
+		err = write_kml(outfn, nil, d)
 	}
+	return err
 }
 
-func GenerateKML(hpos types.HomeRec, rec types.LogRec, outfn string,
-	meta types.FlightMeta, smap types.MapRec, gv func() string) {
-
-	defviz := !(options.Config.Rssi && rec.Items[0].Rssi > 0)
-	ts0 := rec.Items[0].Utc
-	ts1 := rec.Items[len(rec.Items)-1].Utc
-
-	f0 := kml.Folder(kml.Name("Flight modes")).Add(kml.Visibility(defviz)).
-		Add(generate_shared_styles(0)...).
-		Add(getPoints(rec, hpos, COL_STYLE_MODE, defviz)...)
-
-	desc := fmt.Sprintf("Generator: %s", gv())
-	d := kml.Folder(kml.Name(meta.LogName())).Add(kml.Description(desc)).Add(kml.Open(true))
-	d.Add(add_ground_track(rec))
+type kmission struct {
+	ms  *mission.Mission
+	idx int
+}
 
-	fb := geo.Getfrobnication()
-	if len(options.Config.Mission) > 0 {
-		_, mm, err := mission.Read_Mission_File(options.Config.Mission)
-		if err == nil {
-			isviz := true
-			for nm, _ := range mm.Segment {
-				nmx := nm + 1
-				if options.Config.MissionIndex == 0 || nmx == options.Config.MissionIndex {
-					ms := mm.To_mission(nmx)
-					if fb != nil {
-						if ms.Metadata.Homey != 0 && ms.Metadata.Homex != 0 {
-							fb.Set_origin(ms.Metadata.Homey, ms.Metadata.Homex, 0)
-							ms.Metadata.Homey, ms.Metadata.Homex, _ = fb.Get_rebase()
-							ms.Metadata.Cy, ms.Metadata.Cx, _ = fb.Relocate(ms.Metadata.Cy, ms.Metadata.Cx, 0)
-						}
-						for k, mi := range ms.MissionItems {
-							if mi.Is_GeoPoint() {
-								ms.MissionItems[k].Lat, ms.MissionItems[k].Lon, _ = fb.Relocate(ms.MissionItems[k].Lat, ms.MissionItems[k].Lon, 0)
-							}
-						}
+// The selected mission segment(s) from cfg.Mission, rebased if required.
+// A mission with a home position is rebased from that, without changing
+// fb.
+func load_missions(cfg *options.Configuration, fb *geo.Frob) []kmission {
+	var mlist []kmission
+	if len(cfg.Mission) == 0 {
+		return mlist
+	}
+	_, mm, err := mission.Read_Mission_File(cfg.Mission)
+	if err != nil {
+		return mlist
+	}
+	if fb != nil {
+		f := *fb
+		fb = &f
+	}
+	for nm, _ := range mm.Segment {
+		nmx := nm + 1
+		if cfg.MissionIndex == 0 || nmx == cfg.MissionIndex {
+			ms := mm.To_mission(nmx)
+			if fb != nil {
+				if ms.Metadata.Homey != 0 && ms.Metadata.Homex != 0 {
+					fb.Set_origin(ms.Metadata.Homey, ms.Metadata.Homex, 0)
+					ms.Metadata.Homey, ms.Metadata.Homex, _ = fb.Get_rebase()
+					ms.Metadata.Cy, ms.Metadata.Cx, _ = fb.Relocate(ms.Metadata.Cy, ms.Metadata.Cx, 0)
+				}
+				for k, mi := range ms.MissionItems {
+					if mi.Is_GeoPoint() {
+						ms.MissionItems[k].Lat, ms.MissionItems[k].Lon, _ = fb.Relocate(ms.MissionItems[k].Lat, ms.MissionItems[k].Lon, 0)
 					}
-					mf := ms.To_kml(hpos, options.Config.Dms, false, nmx, isviz)
-					d.Add(mf)
-					isviz = false
 				}
 			}
+			mlist = append(mlist, kmission{ms, nmx})
 		}
 	}
+	return mlist
+}
 
-	if len(options.Config.Cli) > 0 {
-		sfx := Generate_cli_kml(options.Config.Cli, fb)
-		for _, s := range sfx {
-			d.Add(s)
-		}
+func GenerateKML(cfg *options.Configuration, f *Flight, outfn string, gv func() string) error {
+	if len(f.Seg.L.Items) == 0 {
+		return fmt.Errorf("%s: no data", f.Meta.LogName())
+	}
+	fb := geo.SegmentFrob(f.Seg.R)
+	var extra []kml.Element
+	isviz := true
+	for _, km := range load_missions(cfg, fb) {
+		mf := km.ms.To_kml(f.Seg.H, cfg.Dms, false, km.idx, isviz)
+		extra = append(extra, mf)
+		isviz = false
+	}
+	if len(cfg.Cli) > 0 {
+		extra = append(extra, Generate_cli_kml(cfg.Cli, fb)...)
 	}
 
+	files := make(map[string][]byte)
+	desc := fmt.Sprintf("Generator: %s", gv())
+	d := flight_folder(cfg, f, outfn, desc, extra, "", files)
+
+	if cfg.Compact {
+		return write_kml(outfn, files, track_schema(cfg, f.Seg.L), d)
+	}
+	return write_kml(outfn, files, d)
+}
+
+// The folder for a flight (log segment), with its layers. Auxiliary
+// files (legends, models) are added to files; legends are named with the
+// tag, which is non-empty for a merged document, where the shared styles
+// are defined once at the top level rather than in each flight. The
+// flight must have items.
+func flight_folder(cfg *options.Configuration, f *Flight, outfn string, desc string, extra []kml.Element,
+	tag string, files map[string][]byte) *kml.CompoundElement {
+
+	hpos, rec, meta, smap := f.Seg.H, f.Seg.L, f.Meta, f.Seg.M
+	defviz := !(cfg.Rssi && rec.Items[0].Rssi > 0)
+	ts0 := rec.Items[0].Utc
+	ts1 := rec.Items[len(rec.Items)-1].Utc
+
+	f0 := kml.Folder(kml.Name("Flight modes")).Add(kml.Visibility(defviz))
+	if tag == "" {
+		f0.Add(generate_shared_styles(cfg)...)
+	}
+	f0.Add(getLayer(cfg, rec, hpos, mode_styles_urls(rec), true, defviz)...)
+
+	d := kml.Folder(kml.Name(meta.LogName())).Add(kml.Description(desc)).Add(kml.Open(tag == ""))
+	d.Add(add_ground_track(rec))
+	d.Add(extra...)
+
 	e := kml.ExtendedData(kml.Data(kml.Name("Log"), kml.Value(meta.LogName())))
 
 	for k, v := range meta.Summary() {
@@ -598,73 +515,138 @@ func GenerateKML(hpos types.HomeRec, rec types.LogRec, outfn string,
 	if s, ok := meta.ShowDisarm(); ok {
 		e.Add(kml.Data(kml.Name("Disarm"), kml.Value(s)))
 	}
+	if f.Battery != nil {
+		for k, v := range f.Battery.Summary() {
+			e.Add(kml.Data(kml.Name(k), kml.Value(v)))
+		}
+	}
 	d.Add(e)
 
 	d.Add(kml.TimeSpan(kml.Begin(ts0), kml.End(ts1)))
-	d.Add(getHomes(hpos)...)
+	d.Add(getHomes(cfg, hpos)...)
+	d.Add(getEvents(cfg, rec, hpos, f.Events))
+	if len(f.Wind) > 0 {
+		d.Add(getWind(cfg, rec, hpos, f.Wind, outfn, files))
+	}
+	if f.Terrain != nil {
+		d.Add(getTerrainClearance(cfg, rec, hpos, *f.Terrain))
+	}
+	if f.Geozones != nil && f.Geozones.Violations > 0 {
+		d.Add(getGeozoneViolations(cfg, rec, hpos, *f.Geozones))
+	}
+	if f.Mission != nil {
+		d.Add(getMissionAdherence(cfg, rec, hpos, f.Mission, f.Adherence))
+	}
 	d.Add(f0)
-	if rec.Cap&types.CAP_RSSI_VALID != 0 || options.Config.Aflags != 0 {
-		d.Add(generate_shared_styles(COL_STYLE_RSSI)...)
+	layers := flight_layers(cfg, f)
+	if tag == "" {
+		d.Add(layer_gradient_styles(cfg, layers)...)
 	}
-
-	if rec.Cap&types.CAP_RSSI_VALID != 0 {
-		f1 := kml.Folder(kml.Name("RSSI")).Add(kml.Visibility(!defviz)).
-			Add(getPoints(rec, hpos, COL_STYLE_RSSI, !defviz)...)
+	lx := LEG_X0
+	for j, ly := range layers {
+		// RSSI is visible where it is the default view
+		lviz := (j == 0 && rec.Cap&types.CAP_RSSI_VALID != 0 && !defviz)
+		urls, vmin, vmax := layer_styles(cfg, rec, ly)
+		f1 := kml.Folder(kml.Name(ly.Name)).Add(kml.Visibility(lviz)).
+			Add(getLayer(cfg, rec, hpos, urls, false, !defviz)...)
+		gname := ly.Gradient
+		if gname == "" {
+			gname = cfg.Gradset
+		}
+		gcols, _ := get_gradient(gname)
+		if png, w, err := legend_png(ly, vmin, vmax, gcols); err == nil {
+			href := aux_href(outfn, fmt.Sprintf("%slegend%d.png", tag, j))
+			files[href] = png
+			f1.Add(legend_overlay(ly, href, lx))
+			lx += w + LEG_MARGIN
+		}
 		d.Add(f1)
 	}
-
-	if (rec.Cap & types.CAP_ENERGY) == types.CAP_ENERGY {
-		if (options.Config.Aflags & types.AFlags_EFFIC) == types.AFlags_EFFIC {
-			f1 := kml.Folder(kml.Name("Efficiency")).Add(kml.Visibility(false)).
-				Add(getPoints(rec, hpos, COL_STYLE_EFFIC, !defviz)...)
-			d.Add(f1)
+	if rec.Cap&types.CAP_RSSI_VALID != 0 {
+		if hm, ok := getRssiHeatmap(cfg, rec, outfn, tag, lx, files); ok {
+			d.Add(hm)
 		}
 	}
 
-	if (rec.Cap & types.CAP_SPEED) == types.CAP_SPEED {
-		if (options.Config.Aflags & types.AFlags_SPEED) == types.AFlags_SPEED {
-			f1 := kml.Folder(kml.Name("Speed")).Add(kml.Visibility(false)).
-				Add(getPoints(rec, hpos, COL_STYLE_SPEED, !defviz)...)
-			d.Add(f1)
-		}
+	if mtype := model_type(cfg, meta); mtype != "" {
+		href := aux_href(outfn, mtype+".dae")
+		files[href] = model_data(mtype)
+		d.Add(getModel(cfg, rec, hpos, href))
+	}
+	if cfg.Tour {
+		d.Add(getTour(cfg, rec, hpos))
 	}
+	return d
+}
 
-	if (rec.Cap & types.CAP_ALTITUDE) == types.CAP_ALTITUDE {
-		if (options.Config.Aflags & types.AFlags_ALTITUDE) == types.AFlags_ALTITUDE {
-			f1 := kml.Folder(kml.Name("Altitude")).Add(kml.Visibility(false)).
-				Add(getPoints(rec, hpos, COL_STYLE_ALTITUDE, !defviz)...)
-			d.Add(f1)
-		}
+// RSSI (where valid) and attribute layers
+func flight_layers(cfg *options.Configuration, f *Flight) []options.Layer {
+	rec := f.Seg.L
+	layers := attribute_layers(cfg, rec, f.Battery)
+	if rec.Cap&types.CAP_AGL != 0 {
+		layers = append([]options.Layer{agl_layer(cfg)}, layers...)
+	}
+	if rec.Cap&types.CAP_LQ != 0 {
+		layers = append([]options.Layer{lq_layer()}, layers...)
 	}
+	if rec.Cap&types.CAP_RSSI_VALID != 0 {
+		layers = append([]options.Layer{rssi_layer()}, layers...)
+	}
+	return layers
+}
 
-	if (rec.Cap & types.CAP_VOLTS) == types.CAP_VOLTS {
-		if (options.Config.Aflags & types.AFlags_BATTERY) == types.AFlags_BATTERY {
-			f1 := kml.Folder(kml.Name("Battery")).Add(kml.Visibility(false)).
-				Add(getPoints(rec, hpos, COL_STYLE_BATTERY, !defviz)...)
-			d.Add(f1)
+// Gradient styles, once for each distinct gradient of the layers
+func layer_gradient_styles(cfg *options.Configuration, layers []options.Layer) []kml.Element {
+	el := []kml.Element{point_style(cfg, nodata_style, nodata_colour)}
+	gdone := make(map[string]bool)
+	for _, ly := range layers {
+		if sname := grad_style_name(cfg, ly.Gradient); !gdone[sname] {
+			el = append(el, gradient_styles(cfg, ly.Gradient)...)
+			gdone[sname] = true
 		}
 	}
-	write_kml(outfn, d)
+	return el
 }
 
-func write_kml(outfn string, d *kml.CompoundElement) {
-	var err error
+// A layer of the track, coloured by flight mode or attribute
+func getLayer(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, urls []string, ismode bool, viz bool) []kml.Element {
+	if cfg.Compact {
+		return getTracks(cfg, rec, hpos, urls, ismode, viz)
+	}
+	return getPoints(cfg, rec, hpos, urls, viz)
+}
+
+// Multiple roots (e.g. a Schema) are wrapped in a Document. Any files
+// (e.g. models) are added to a KMZ, or written alongside a KML.
+func write_kml(outfn string, files map[string][]byte, roots ...kml.Element) error {
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
 	if strings.HasSuffix(outfn, ".kmz") {
-		z := kmz.NewKMZ(d)
-		w, err0 := os.Create(outfn)
-		err = err0
-		if err == nil {
-			err = z.WriteIndent(w, "", "  ")
+		z := kmz.NewKMZ(roots...)
+		for fn, data := range files {
+			z.AddFile(fn, data)
 		}
+		err = z.WriteIndent(fh, "", "  ")
 	} else {
-		k := kml.KML(d)
-		output, err0 := os.Create(outfn)
-		err = err0
-		if err == nil {
-			err = k.WriteIndent(output, "", "  ")
+		var k *kml.CompoundElement
+		if len(roots) > 1 {
+			k = kml.GxKML(kml.Document(roots...))
+		} else if len(files) > 0 {
+			k = kml.GxKML(roots[0])
+		} else {
+			k = kml.KML(roots[0])
+		}
+		err = k.WriteIndent(fh, "", "  ")
+		for fn, data := range files {
+			if err == nil {
+				err = os.WriteFile(filepath.Join(filepath.Dir(outfn), fn), data, 0644)
+			}
 		}
 	}
-	if err != nil {
-		log.Fatalf("kmlbuilder: %+v\n", err)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
 	}
+	return err
 }
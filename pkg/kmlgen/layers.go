@@ -0,0 +1,232 @@
+package kmlgen
+
+import (
+	"fmt"
+	"github.com/bmizerany/perks/quantile"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+import (
+	"analysis"
+	"options"
+	"types"
+)
+
+/*
+ * Attribute (gradient coloured) layers. A layer colours the track by a
+ * value, either a LogItem field or a simple arithmetic expression of
+ * fields (e.g. "volts*amps"). The value is scaled (0-100) between the
+ * layer's min and max or, where these are not set (equal), the 5% and 95%
+ * quantiles of the value over the log. The built-in layers (efficiency,
+ * speed, altitude, battery, sag) are selected by -attributes; further layers
+ * may be defined in the configuration file ("layers").
+ */
+
+type item_value func(items []types.LogItem, j int) float64
+
+// The numeric fields, named as the CSV output, and vspeed
+var item_fields = numeric_fields()
+
+func numeric_fields() map[string]item_value {
+	m := map[string]item_value{"vspeed": vspeed}
+	for _, f := range types.ItemFields {
+		if _, ok := f.Number(&types.LogItem{}); ok {
+			num := f.Number
+			m[f.Name] = func(items []types.LogItem, j int) float64 {
+				v, _ := num(&items[j])
+				return v
+			}
+		}
+	}
+	return m
+}
+
+// Vertical speed (m/s) from the previous item
+func vspeed(items []types.LogItem, j int) float64 {
+	if j == 0 || items[j].Stamp <= items[j-1].Stamp {
+		return 0
+	}
+	dt := float64(items[j].Stamp-items[j-1].Stamp) / 1e6
+	return (items[j].Alt - items[j-1].Alt) / dt
+}
+
+// Compiles a (Go syntax) expression of fields, numbers, + - * / and abs()
+func compile_expr(e ast.Expr) (item_value, error) {
+	switch x := e.(type) {
+	case *ast.Ident:
+		if f, ok := item_fields[strings.ToLower(x.Name)]; ok {
+			return f, nil
+		}
+		return nil, fmt.Errorf("unknown field \"%s\"", x.Name)
+	case *ast.BasicLit:
+		if x.Kind == token.INT || x.Kind == token.FLOAT {
+			v, err := strconv.ParseFloat(x.Value, 64)
+			if err != nil {
+				return nil, err
+			}
+			return func(items []types.LogItem, j int) float64 { return v }, nil
+		}
+	case *ast.ParenExpr:
+		return compile_expr(x.X)
+	case *ast.UnaryExpr:
+		f, err := compile_expr(x.X)
+		if err != nil {
+			return nil, err
+		}
+		switch x.Op {
+		case token.ADD:
+			return f, nil
+		case token.SUB:
+			return func(items []types.LogItem, j int) float64 { return -f(items, j) }, nil
+		}
+	case *ast.BinaryExpr:
+		l, err := compile_expr(x.X)
+		if err != nil {
+			return nil, err
+		}
+		r, err := compile_expr(x.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch x.Op {
+		case token.ADD:
+			return func(items []types.LogItem, j int) float64 { return l(items, j) + r(items, j) }, nil
+		case token.SUB:
+			return func(items []types.LogItem, j int) float64 { return l(items, j) - r(items, j) }, nil
+		case token.MUL:
+			return func(items []types.LogItem, j int) float64 { return l(items, j) * r(items, j) }, nil
+		case token.QUO:
+			return func(items []types.LogItem, j int) float64 { return l(items, j) / r(items, j) }, nil
+		}
+	case *ast.CallExpr:
+		if fn, ok := x.Fun.(*ast.Ident); ok && fn.Name == "abs" && len(x.Args) == 1 {
+			f, err := compile_expr(x.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			return func(items []types.LogItem, j int) float64 { return math.Abs(f(items, j)) }, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+func layer_value(ly options.Layer) (item_value, error) {
+	e, err := parser.ParseExpr(ly.Field)
+	if err != nil {
+		return nil, err
+	}
+	return compile_expr(e)
+}
+
+// Checks user defined layers
+func ValidateLayers(layers []options.Layer) error {
+	for _, ly := range layers {
+		if ly.Name == "" {
+			return fmt.Errorf("layer (%s) has no name", ly.Field)
+		}
+		if _, err := layer_value(ly); err != nil {
+			return fmt.Errorf("layer \"%s\": %s: %v", ly.Name, ly.Field, err)
+		}
+		if _, ok := get_gradient(ly.Gradient); ly.Gradient != "" && !ok {
+			return fmt.Errorf("layer \"%s\": unknown gradient \"%s\"", ly.Name, ly.Gradient)
+		}
+	}
+	return nil
+}
+
+// The built-in layers (per -attributes and the log's capabilities),
+// followed by any user defined layers
+func attribute_layers(cfg *options.Configuration, rec types.LogRec, bs *analysis.BatteryStats) []options.Layer {
+	effic, eunits := "effic", "mAh/km"
+	if cfg.Engunit == "wh" {
+		effic, eunits = "whkm", "Wh/km"
+	}
+	builtin := []struct {
+		aflag int
+		cap   uint16
+		ly    options.Layer
+	}{
+		{types.AFlags_EFFIC, types.CAP_ENERGY, options.Layer{Name: "Efficiency", Field: effic, Invert: true, Units: eunits}},
+		{types.AFlags_SPEED, types.CAP_SPEED, options.Layer{Name: "Speed", Field: "spd", Invert: cfg.RedIsFast, Units: "m/s"}},
+		{types.AFlags_ALTITUDE, types.CAP_ALTITUDE, options.Layer{Name: "Altitude", Field: "alt", Invert: !cfg.RedIsLow, Units: "m"}},
+		{types.AFlags_BATTERY, types.CAP_VOLTS, options.Layer{Name: "Battery", Field: "volts", Units: "V"}},
+	}
+	var layers []options.Layer
+	for _, b := range builtin {
+		if (rec.Cap&b.cap) == b.cap && (cfg.Aflags&b.aflag) == b.aflag {
+			layers = append(layers, b.ly)
+		}
+	}
+	// Voltage sag, from the estimated internal resistance
+	if (cfg.Aflags & types.AFlags_SAG) == types.AFlags_SAG {
+		if bs != nil && bs.IR > 0 {
+			layers = append(layers, options.Layer{Name: "Voltage sag",
+				Field: fmt.Sprintf("amps*%g", bs.IR/float64(bs.Cells)), Units: "V/cell"})
+		}
+	}
+	return append(layers, cfg.Layers...)
+}
+
+func rssi_layer() options.Layer {
+	return options.Layer{Name: "RSSI", Field: "rssi", Min: 0, Max: 100, Units: "%"}
+}
+
+func lq_layer() options.Layer {
+	return options.Layer{Name: "Link quality", Field: "lq", Min: 0, Max: 100, Units: "%"}
+}
+
+func agl_layer(cfg *options.Configuration) options.Layer {
+	return options.Layer{Name: "Terrain clearance", Field: "agl", Invert: !cfg.RedIsLow, Units: "m"}
+}
+
+// 5% and 95% quantiles
+func get_qrange(vals []float64) (float64, float64) {
+	q := quantile.NewTargeted(0.05, 0.95)
+	for _, v := range vals {
+		if !math.IsNaN(v) {
+			q.Insert(v)
+		}
+	}
+	return q.Query(0.05), q.Query(0.95)
+}
+
+// Points with no value for a layer (e.g. no DEM data)
+const nodata_style = "styleNoData"
+
+var nodata_colour = color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}
+
+// Gradient style URL (in 5% steps) for each item, and the value range
+func layer_styles(cfg *options.Configuration, rec types.LogRec, ly options.Layer) ([]string, float64, float64) {
+	fv, err := layer_value(ly)
+	if err != nil {
+		fv = func(items []types.LogItem, j int) float64 { return 0 }
+	}
+	vals := make([]float64, len(rec.Items))
+	for j := range rec.Items {
+		vals[j] = fv(rec.Items, j)
+	}
+	vmin, vmax := ly.Min, ly.Max
+	if vmin == vmax {
+		vmin, vmax = get_qrange(vals)
+	}
+	sname := grad_style_name(cfg, ly.Gradient)
+	urls := make([]string, len(vals))
+	for j, v := range vals {
+		if math.IsNaN(v) {
+			urls[j] = "#" + nodata_style
+			continue
+		}
+		q := makeqval(v, vmin, vmax, ly.Invert)
+		if math.IsNaN(q) {
+			q = 0
+		}
+		urls[j] = fmt.Sprintf("#%s%03d", sname, 5*(int(q)/5))
+	}
+	return urls, vmin, vmax
+}
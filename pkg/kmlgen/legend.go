@@ -0,0 +1,107 @@
+package kmlgen
+
+import (
+	"bytes"
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+import (
+	"options"
+)
+
+/*
+ * Legend for a gradient layer; a PNG of the colour bar, labelled with
+ * the values (and units) at the ends and mid-point, shown as a
+ * ScreenOverlay in the layer's folder (so it is only displayed when the
+ * layer is visible). Each layer's legend is placed to the right of the
+ * previous one, so that visible legends don't overlap.
+ */
+
+const (
+	LEG_MARGIN = 6
+	LEG_BARW   = 20
+	LEG_STEP   = 8
+	LEG_X0     = 10 // px, screen position of the first legend
+)
+
+func legend_label(v float64, units string) string {
+	s := fmt.Sprintf("%.4g", v)
+	if units != "" {
+		s = s + " " + units
+	}
+	return s
+}
+
+// Returns the PNG and its width (px)
+func legend_png(ly options.Layer, vmin, vmax float64, gcols []GradSet) ([]byte, int, error) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Face: face, Src: image.NewUniform(color.Black)}
+
+	// q = 0 (bottom) is vmin, unless inverted
+	lo, hi := vmin, vmax
+	if ly.Invert {
+		lo, hi = vmax, vmin
+	}
+	labels := []string{legend_label(hi, ly.Units), legend_label((lo+hi)/2, ly.Units), legend_label(lo, ly.Units)}
+
+	lw := 0
+	for _, l := range labels {
+		if w := d.MeasureString(l).Ceil(); w > lw {
+			lw = w
+		}
+	}
+	fh := face.Metrics().Height.Ceil()
+	barh := LEG_STEP * len(gcols)
+	w := 2*LEG_MARGIN + LEG_BARW + LEG_MARGIN + lw
+	if tw := 2*LEG_MARGIN + d.MeasureString(ly.Name).Ceil(); tw > w {
+		w = tw
+	}
+	h := 3*LEG_MARGIN + fh + barh + fh/2
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xc0}), image.Point{}, draw.Src)
+	d.Dst = img
+
+	d.Dot = fixed.P(LEG_MARGIN, LEG_MARGIN+face.Metrics().Ascent.Ceil())
+	d.DrawString(ly.Name)
+
+	y0 := 2*LEG_MARGIN + fh
+	for j, c := range gcols {
+		// top of the bar is the 100% end
+		y := y0 + (len(gcols)-1-j)*LEG_STEP
+		r := image.Rect(LEG_MARGIN, y, LEG_MARGIN+LEG_BARW, y+LEG_STEP)
+		draw.Draw(img, r, image.NewUniform(color.RGBA{R: c.R, G: c.G, B: c.B, A: 0xff}), image.Point{}, draw.Src)
+	}
+
+	asc := face.Metrics().Ascent.Ceil()
+	for j, l := range labels {
+		y := y0 + j*(barh-1)/2
+		d.Dot = fixed.P(2*LEG_MARGIN+LEG_BARW, y+asc/2)
+		d.DrawString(l)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), w, nil
+}
+
+// Legend shown at the bottom of the screen, x pixels from the left
+func legend_overlay(ly options.Layer, href string, x int) kml.Element {
+	return kml.ScreenOverlay(
+		kml.Name(ly.Name+" legend"),
+		kml.Icon(kml.Href(href)),
+		kml.OverlayXY(kml.Vec2{X: 0, Y: 0, XUnits: kml.UnitsFraction, YUnits: kml.UnitsFraction}),
+		kml.ScreenXY(kml.Vec2{X: float64(x), Y: 30, XUnits: kml.UnitsPixels, YUnits: kml.UnitsPixels}),
+		kml.Size(kml.Vec2{X: 0, Y: 0, XUnits: kml.UnitsPixels, YUnits: kml.UnitsPixels}),
+	)
+}
@@ -0,0 +1,118 @@
+package kmlgen
+
+import (
+	"bytes"
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+import (
+	"options"
+	"types"
+)
+
+/*
+ * RSSI heatmap; the mean RSSI over a grid of the flown area, as a
+ * GroundOverlay PNG (transparent where there is no data), coloured by
+ * the -gradient.
+ */
+
+const (
+	HEAT_MIN_CELL = 25.0 // m
+	HEAT_MAX_GRID = 64   // cells, longest side
+	HEAT_PIXELS   = 8    // per cell
+	HEAT_ALPHA    = 0xb0
+)
+
+func getRssiHeatmap(cfg *options.Configuration, rec types.LogRec, outfn string, tag string, lx int,
+	files map[string][]byte) (kml.Element, bool) {
+	north, south := math.Inf(-1), math.Inf(1)
+	east, west := math.Inf(-1), math.Inf(1)
+	n := 0
+	for _, b := range rec.Items {
+		if b.Fix > 1 {
+			north, south = math.Max(north, b.Lat), math.Min(south, b.Lat)
+			east, west = math.Max(east, b.Lon), math.Min(west, b.Lon)
+			n++
+		}
+	}
+	if n == 0 {
+		return nil, false
+	}
+	// cell size (m), and in degrees
+	mlat := 111320.0
+	mlon := mlat * math.Cos((north+south)/2*math.Pi/180)
+	cell := math.Max(HEAT_MIN_CELL, math.Max((north-south)*mlat, (east-west)*mlon)/HEAT_MAX_GRID)
+	dlat, dlon := cell/mlat, cell/mlon
+	nx := int((east-west)/dlon) + 1
+	ny := int((north-south)/dlat) + 1
+	// centre the grid on the data
+	west -= (float64(nx)*dlon - (east - west)) / 2
+	south -= (float64(ny)*dlat - (north - south)) / 2
+	east = west + float64(nx)*dlon
+	north = south + float64(ny)*dlat
+
+	sum := make([]float64, nx*ny)
+	cnt := make([]int, nx*ny)
+	for _, b := range rec.Items {
+		if b.Fix > 1 {
+			x := int((b.Lon - west) / dlon)
+			y := int((north - b.Lat) / dlat)
+			if x >= 0 && x < nx && y >= 0 && y < ny {
+				sum[y*nx+x] += float64(b.Rssi)
+				cnt[y*nx+x]++
+			}
+		}
+	}
+
+	gcols, _ := get_gradient(cfg.Gradset)
+	img := image.NewRGBA(image.Rect(0, 0, nx*HEAT_PIXELS, ny*HEAT_PIXELS))
+	for y := 0; y < ny; y++ {
+		for x := 0; x < nx; x++ {
+			if cnt[y*nx+x] == 0 {
+				continue
+			}
+			q := makeqval(sum[y*nx+x]/float64(cnt[y*nx+x]), 0, 100, false)
+			c := gcols[int(q)/5]
+			col := color.RGBA{R: c.R, G: c.G, B: c.B, A: HEAT_ALPHA}
+			for py := y * HEAT_PIXELS; py < (y+1)*HEAT_PIXELS; py++ {
+				for px := x * HEAT_PIXELS; px < (x+1)*HEAT_PIXELS; px++ {
+					img.SetRGBA(px, py, col)
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, false
+	}
+	href := aux_href(outfn, fmt.Sprintf("%srssimap.png", tag))
+	files[href] = buf.Bytes()
+
+	ly := rssi_layer()
+	ly.Name = "RSSI heatmap"
+	f := kml.Folder(kml.Name(ly.Name)).Add(kml.Visibility(false)).Add(
+		kml.Description(fmt.Sprintf("Mean RSSI in %.0f m cells", cell)),
+		kml.GroundOverlay(
+			kml.Name(ly.Name),
+			kml.Visibility(false),
+			kml.Icon(kml.Href(href)),
+			kml.LatLonBox(
+				kml.North(north),
+				kml.South(south),
+				kml.East(east),
+				kml.West(west),
+			),
+		),
+	)
+	if lpng, _, err := legend_png(ly, 0, 100, gcols); err == nil {
+		lhref := aux_href(outfn, fmt.Sprintf("%srssimap-legend.png", tag))
+		files[lhref] = lpng
+		f.Add(legend_overlay(ly, lhref, lx))
+	}
+	return f, true
+}
@@ -0,0 +1,157 @@
+package kmlgen
+
+import (
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+)
+
+import (
+	"analysis"
+	"geo"
+	"mission"
+	"options"
+	"types"
+)
+
+/*
+ * Merged output; several logs / segments (e.g. a day's flying) in a
+ * single KML/Z. Each flight is a folder, as the top level folder of a
+ * single flight's KML/Z, under a top level folder with the combined
+ * summary. Styles (and for compact output, the track schema) are
+ * shared.
+ */
+
+// A flight (log segment), with the results of the analyses that are
+// shown in the summary as well as the KML/Z, so they are only run once
+type Flight struct {
+	Meta      types.FlightMeta
+	Seg       types.LogSegment
+	Events    []types.FlightEvent
+	Wind      []analysis.WindEstimate
+	Battery   *analysis.BatteryStats // nil if not analysed
+	Mission   *mission.Mission       // with the adherence report, if any
+	Adherence analysis.MissionReport
+	Geozones  *analysis.GeozoneReport // nil if no -cli geozones
+	Terrain   *analysis.TerrainReport // nil if no AGL
+}
+
+// Combined summary of the flights
+func MergeSummary(cfg *options.Configuration, flights []Flight) types.MapRec {
+	var st types.LogStats
+	var energy float64
+	logs := make(map[string]bool)
+	hasenergy := false
+	for _, f := range flights {
+		logs[f.Meta.Logname] = true
+		items := f.Seg.L.Items
+		if len(items) == 0 {
+			continue
+		}
+		st.Duration += items[len(items)-1].Stamp - items[0].Stamp
+		for _, r := range items {
+			if r.Alt > st.Max_alt {
+				st.Max_alt = r.Alt
+			}
+			if r.Vrange > st.Max_range {
+				st.Max_range = r.Vrange
+			}
+			if r.Spd > st.Max_speed {
+				st.Max_speed = r.Spd
+			}
+		}
+		last := items[len(items)-1]
+		st.Distance += last.Tdist
+		if f.Seg.L.Cap&types.CAP_ENERGY == types.CAP_ENERGY {
+			hasenergy = true
+			if cfg.Engunit == "wh" {
+				energy += last.WhAcc
+			} else {
+				energy += last.Energy
+			}
+		}
+	}
+
+	m := make(types.MapRec)
+	m["Flights"] = fmt.Sprintf("%d (%d logs)", len(flights), len(logs))
+	if len(flights) > 0 {
+		m["Start"] = flights[0].Meta.Flight()
+	}
+	m["Duration"] = st.Show_time(st.Duration)
+	m["Distance"] = fmt.Sprintf("%.0f m", st.Distance)
+	m["Altitude"] = fmt.Sprintf("%.1f m", st.Max_alt)
+	m["Speed"] = fmt.Sprintf("%.1f m/s", st.Max_speed)
+	m["Range"] = fmt.Sprintf("%.0f m", st.Max_range)
+	if hasenergy {
+		if cfg.Engunit == "wh" {
+			m["Energy"] = fmt.Sprintf("%.2f Wh", energy)
+		} else {
+			m["Energy"] = fmt.Sprintf("%.0f mAh", energy)
+		}
+	}
+	return m
+}
+
+func GenerateMergedKML(cfg *options.Configuration, flights []Flight, outfn string, gv func() string) error {
+	// Skip any segment without data
+	var fls []Flight
+	for _, f := range flights {
+		if len(f.Seg.L.Items) > 0 {
+			fls = append(fls, f)
+		}
+	}
+	flights = fls
+	if len(flights) == 0 {
+		return nil
+	}
+	desc := fmt.Sprintf("Generator: %s", gv())
+	name := fmt.Sprintf("%s (%d flights)", flights[0].Meta.Date.Format("2006-01-02"), len(flights))
+	d := kml.Folder(kml.Name(name)).Add(kml.Description(desc)).Add(kml.Open(true))
+
+	e := kml.ExtendedData()
+	for k, v := range MergeSummary(cfg, flights) {
+		e.Add(kml.Data(kml.Name(k), kml.Value(v)))
+	}
+	d.Add(e)
+
+	var caps uint16
+	ts0 := flights[0].Seg.L.Items[0].Utc
+	ts1 := ts0
+	var layers []options.Layer
+	for _, f := range flights {
+		items := f.Seg.L.Items
+		caps |= f.Seg.L.Cap
+		if items[0].Utc.Before(ts0) {
+			ts0 = items[0].Utc
+		}
+		if t := items[len(items)-1].Utc; t.After(ts1) {
+			ts1 = t
+		}
+		layers = append(layers, flight_layers(cfg, &f)...)
+	}
+	d.Add(kml.TimeSpan(kml.Begin(ts0), kml.End(ts1)))
+	d.Add(generate_shared_styles(cfg)...)
+	d.Add(layer_gradient_styles(cfg, layers)...)
+
+	// Missions and CLI (for the first flight's home) are common
+	fb := geo.SegmentFrob(flights[0].Seg.R)
+	isviz := true
+	for _, km := range load_missions(cfg, fb) {
+		d.Add(km.ms.To_kml(flights[0].Seg.H, cfg.Dms, false, km.idx, isviz))
+		isviz = false
+	}
+	if len(cfg.Cli) > 0 {
+		d.Add(Generate_cli_kml(cfg.Cli, fb)...)
+	}
+
+	files := make(map[string][]byte)
+	for j := range flights {
+		tag := fmt.Sprintf("f%d-", j+1)
+		d.Add(flight_folder(cfg, &flights[j], outfn, flights[j].Meta.Flight(), nil, tag, files))
+	}
+
+	if cfg.Compact {
+		// the schema has the fields of all the flights
+		return write_kml(outfn, files, track_schema(cfg, types.LogRec{Cap: caps}), d)
+	}
+	return write_kml(outfn, files, d)
+}
@@ -0,0 +1,237 @@
+package kmlgen
+
+import (
+	"encoding/xml"
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+import (
+	"options"
+	"types"
+)
+
+/*
+ * 3D aircraft model layer. The model is a simple COLLADA (.dae) shape,
+ * generated here, and is placed along a gx:Track (so it is animated by
+ * the Google Earth time slider), oriented from the logged heading, pitch
+ * and roll. Model axes are +X right, +Y forward, +Z up (metres), so with
+ * no rotation the model faces north. The left / right wing tips (or
+ * front rotors) are red / green, as navigation lights.
+ */
+
+const (
+	MODEL_FW = "fw"
+	MODEL_MR = "mr"
+)
+
+type vec3 [3]float64
+
+type mpart struct {
+	rgb  vec3
+	tris [][3]vec3
+}
+
+var (
+	col_body  = vec3{0.85, 0.85, 0.85}
+	col_trim  = vec3{1.0, 0.55, 0.0}
+	col_left  = vec3{0.9, 0.0, 0.0}
+	col_right = vec3{0.0, 0.8, 0.0}
+	col_rotor = vec3{0.3, 0.3, 0.4}
+)
+
+func quad(a, b, c, d vec3) [][3]vec3 {
+	return [][3]vec3{{a, b, c}, {a, c, d}}
+}
+
+// Horizontal rectangle, x0..x1, y0..y1 at height z
+func hrect(x0, y0, x1, y1, z float64) [][3]vec3 {
+	return quad(vec3{x0, y0, z}, vec3{x1, y0, z}, vec3{x1, y1, z}, vec3{x0, y1, z})
+}
+
+// Horizontal bar of width w from (x0,y0) to (x1,y1) at height z
+func hbar(x0, y0, x1, y1, z, w float64) [][3]vec3 {
+	l := math.Hypot(x1-x0, y1-y0)
+	px := -(y1 - y0) / l * w / 2
+	py := (x1 - x0) / l * w / 2
+	return quad(vec3{x0 + px, y0 + py, z}, vec3{x1 + px, y1 + py, z},
+		vec3{x1 - px, y1 - py, z}, vec3{x0 - px, y0 - py, z})
+}
+
+func disc(cx, cy, z, r float64) [][3]vec3 {
+	var tris [][3]vec3
+	n := 12
+	for j := 0; j < n; j++ {
+		a0 := 2 * math.Pi * float64(j) / float64(n)
+		a1 := 2 * math.Pi * float64(j+1) / float64(n)
+		tris = append(tris, [3]vec3{{cx, cy, z},
+			{cx + r*math.Cos(a0), cy + r*math.Sin(a0), z},
+			{cx + r*math.Cos(a1), cy + r*math.Sin(a1), z}})
+	}
+	return tris
+}
+
+// Diamond section body from nose to tail
+func fuselage(ny, ty, w, h float64) [][3]vec3 {
+	n := vec3{0, ny, 0}
+	t := vec3{0, ty, 0}
+	r := vec3{w, 0, 0}
+	l := vec3{-w, 0, 0}
+	u := vec3{0, 0, h}
+	d := vec3{0, 0, -h}
+	return [][3]vec3{{n, r, u}, {n, u, l}, {n, l, d}, {n, d, r},
+		{t, u, r}, {t, l, u}, {t, d, l}, {t, r, d}}
+}
+
+func fixedwing_parts() []mpart {
+	wing := hrect(-0.85, -0.12, 0.85, 0.12, 0.02)
+	wing = append(wing, hrect(-0.3, -0.98, 0.3, -0.8, 0.02)...)
+	wing = append(wing, quad(vec3{0, -0.75, 0.02}, vec3{0, -1.0, 0.02}, vec3{0, -1.0, 0.3}, vec3{0, -0.92, 0.3})...)
+	return []mpart{
+		{col_body, fuselage(0.7, -1.0, 0.07, 0.08)},
+		{col_trim, wing},
+		{col_left, hrect(-1.0, -0.12, -0.85, 0.12, 0.02)},
+		{col_right, hrect(0.85, -0.12, 1.0, 0.12, 0.02)},
+	}
+}
+
+func multirotor_parts() []mpart {
+	const a = 0.42
+	var arms [][3]vec3
+	for _, p := range [][2]float64{{a, a}, {-a, a}, {a, -a}, {-a, -a}} {
+		arms = append(arms, hbar(0, 0, p[0], p[1], 0.02, 0.05)...)
+	}
+	body := fuselage(0.18, -0.18, 0.1, 0.06)
+	nose := [][3]vec3{{{-0.06, 0.05, 0.07}, {0.06, 0.05, 0.07}, {0, 0.2, 0.07}}}
+	return []mpart{
+		{col_body, append(body, arms...)},
+		{col_trim, nose},
+		{col_left, disc(-a, a, 0.05, 0.2)},
+		{col_right, disc(a, a, 0.05, 0.2)},
+		{col_rotor, append(disc(a, -a, 0.05, 0.2), disc(-a, -a, 0.05, 0.2)...)},
+	}
+}
+
+func floats(b *strings.Builder, v []float64) {
+	for j, f := range v {
+		if j > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(b, "%.4g", f)
+	}
+}
+
+// COLLADA 1.4.1 document for the model parts; one geometry (with flat
+// normals) and material per part, double sided for Google Earth.
+func collada_model(parts []mpart) []byte {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<COLLADA xmlns="http://www.collada.org/2005/11/COLLADASchema" version="1.4.1">` + "\n")
+	b.WriteString(`<asset><unit meter="1" name="meter"/><up_axis>Z_UP</up_axis></asset>` + "\n")
+
+	b.WriteString("<library_effects>\n")
+	for j, p := range parts {
+		fmt.Fprintf(&b, `<effect id="e%d"><profile_COMMON><technique sid="common"><lambert><diffuse><color>%.2f %.2f %.2f 1</color></diffuse></lambert></technique>`+
+			`<extra><technique profile="GOOGLEEARTH"><double_sided>1</double_sided></technique></extra></profile_COMMON></effect>`+"\n",
+			j, p.rgb[0], p.rgb[1], p.rgb[2])
+	}
+	b.WriteString("</library_effects>\n<library_materials>\n")
+	for j := range parts {
+		fmt.Fprintf(&b, `<material id="m%d"><instance_effect url="#e%d"/></material>`+"\n", j, j)
+	}
+	b.WriteString("</library_materials>\n<library_geometries>\n")
+	for j, p := range parts {
+		var pos, nrm []float64
+		for _, t := range p.tris {
+			u := vec3{t[1][0] - t[0][0], t[1][1] - t[0][1], t[1][2] - t[0][2]}
+			v := vec3{t[2][0] - t[0][0], t[2][1] - t[0][1], t[2][2] - t[0][2]}
+			n := vec3{u[1]*v[2] - u[2]*v[1], u[2]*v[0] - u[0]*v[2], u[0]*v[1] - u[1]*v[0]}
+			l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2])
+			nrm = append(nrm, n[0]/l, n[1]/l, n[2]/l)
+			for _, vx := range t {
+				pos = append(pos, vx[0], vx[1], vx[2])
+			}
+		}
+		fmt.Fprintf(&b, `<geometry id="g%d"><mesh>`+"\n", j)
+		for _, s := range []struct {
+			id string
+			v  []float64
+		}{{"pos", pos}, {"nrm", nrm}} {
+			fmt.Fprintf(&b, `<source id="g%d-%s"><float_array id="g%d-%s-a" count="%d">`, j, s.id, j, s.id, len(s.v))
+			floats(&b, s.v)
+			fmt.Fprintf(&b, `</float_array><technique_common><accessor source="#g%d-%s-a" count="%d" stride="3">`+
+				`<param name="X" type="float"/><param name="Y" type="float"/><param name="Z" type="float"/>`+
+				`</accessor></technique_common></source>`+"\n", j, s.id, len(s.v)/3)
+		}
+		fmt.Fprintf(&b, `<vertices id="g%d-vtx"><input semantic="POSITION" source="#g%d-pos"/></vertices>`+"\n", j, j)
+		fmt.Fprintf(&b, `<triangles material="m%d" count="%d"><input semantic="VERTEX" source="#g%d-vtx" offset="0"/>`+
+			`<input semantic="NORMAL" source="#g%d-nrm" offset="1"/><p>`, j, len(p.tris), j, j)
+		for k := 0; k < 3*len(p.tris); k++ {
+			if k > 0 {
+				b.WriteByte(' ')
+			}
+			fmt.Fprintf(&b, "%d %d", k, k/3)
+		}
+		b.WriteString("</p></triangles>\n</mesh></geometry>\n")
+	}
+	b.WriteString("</library_geometries>\n")
+	b.WriteString(`<library_visual_scenes><visual_scene id="scene">` + "\n")
+	for j := range parts {
+		fmt.Fprintf(&b, `<node id="n%d"><instance_geometry url="#g%d"><bind_material><technique_common>`+
+			`<instance_material symbol="m%d" target="#m%d"/></technique_common></bind_material></instance_geometry></node>`+"\n", j, j, j, j)
+	}
+	b.WriteString("</visual_scene></library_visual_scenes>\n")
+	b.WriteString(`<scene><instance_visual_scene url="#scene"/></scene>` + "\n</COLLADA>\n")
+	return []byte(b.String())
+}
+
+// Model type from the configuration, or for "auto", from the motor
+// count (as fl2ltm)
+func model_type(cfg *options.Configuration, meta types.FlightMeta) string {
+	switch cfg.Model {
+	case MODEL_FW, MODEL_MR:
+		return cfg.Model
+	case "auto":
+		if mr, _ := meta.Multirotor(); mr {
+			return MODEL_MR
+		}
+		return MODEL_FW
+	}
+	return ""
+}
+
+// Auxiliary files (models, images) are in the KMZ, or are written
+// alongside a KML file
+func aux_href(outfn, name string) string {
+	if strings.HasSuffix(outfn, ".kmz") {
+		return "files/" + name
+	}
+	base := filepath.Base(outfn)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "." + name
+}
+
+func model_data(mtype string) []byte {
+	if mtype == MODEL_MR {
+		return collada_model(multirotor_parts())
+	}
+	return collada_model(fixedwing_parts())
+}
+
+// KML <Model> <Scale> (not the <scale> of styles)
+func model_scale(s float64) *kml.CompoundElement {
+	e := &kml.CompoundElement{StartElement: xml.StartElement{Name: xml.Name{Local: "Scale"}}}
+	e.Add(kml.X(s), kml.Y(s), kml.Z(s))
+	return e
+}
+
+func getModel(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, href string) kml.Element {
+	mdl := kml.Model(kml.Link(kml.Href(href)), model_scale(cfg.ModelScale))
+	// no icon or line, just the model
+	k := kml.Placemark(kml.Name("Aircraft"),
+		kml.Style(kml.IconStyle(kml.Scale(0)), kml.LineStyle(kml.Width(0))),
+		gx_track(cfg, hpos, rec.Items, mdl))
+	return kml.Folder(kml.Name("3D Model")).Add(kml.Visibility(true)).Add(k)
+}
@@ -0,0 +1,104 @@
+package kmlgen
+
+import (
+	"fmt"
+	"github.com/mazznoer/colorgrad"
+	"hash/crc32"
+	"strings"
+)
+
+import (
+	"options"
+)
+
+/*
+ * Gradient palettes. The built-in gradients (red, rdgn, yor) are the
+ * static tables in gradgen.go. Any other gradient is either a colorgrad
+ * preset name (e.g. "viridis", "turbo"), or a comma separated list of CSS
+ * colours (e.g. "red,yellow,#00c000"), which are evenly spaced. A "_r"
+ * suffix reverses the gradient. The gradient is sampled in NUM_GRAD
+ * steps, index 0 being the low (0%) end.
+ */
+
+var grad_presets = map[string]func() colorgrad.Gradient{
+	"brbg": colorgrad.BrBG, "prgn": colorgrad.PRGn, "piyg": colorgrad.PiYG,
+	"puor": colorgrad.PuOr, "rdbu": colorgrad.RdBu, "rdgy": colorgrad.RdGy,
+	"rdylbu": colorgrad.RdYlBu, "rdylgn": colorgrad.RdYlGn, "spectral": colorgrad.Spectral,
+	"blues": colorgrad.Blues, "greens": colorgrad.Greens, "greys": colorgrad.Greys,
+	"oranges": colorgrad.Oranges, "purples": colorgrad.Purples, "reds": colorgrad.Reds,
+	"viridis": colorgrad.Viridis, "inferno": colorgrad.Inferno, "magma": colorgrad.Magma,
+	"plasma": colorgrad.Plasma, "bugn": colorgrad.BuGn, "bupu": colorgrad.BuPu,
+	"gnbu": colorgrad.GnBu, "orrd": colorgrad.OrRd, "pubugn": colorgrad.PuBuGn,
+	"pubu": colorgrad.PuBu, "purd": colorgrad.PuRd, "rdpu": colorgrad.RdPu,
+	"ylgnbu": colorgrad.YlGnBu, "ylgn": colorgrad.YlGn, "ylorbr": colorgrad.YlOrBr,
+	"ylorrd": colorgrad.YlOrRd, "sinebow": colorgrad.Sinebow, "turbo": colorgrad.Turbo,
+	"cividis": colorgrad.Cividis, "cubehelix": colorgrad.CubehelixDefault, "warm": colorgrad.Warm,
+	"cool": colorgrad.Cool, "rainbow": colorgrad.Rainbow,
+}
+
+func colorgrad_set(name string) ([]GradSet, error) {
+	spec := strings.ToLower(name)
+	reverse := strings.HasSuffix(spec, "_r")
+	spec = strings.TrimSuffix(spec, "_r")
+
+	var grad colorgrad.Gradient
+	if strings.Contains(spec, ",") {
+		var err error
+		grad, err = colorgrad.NewGradient().HtmlColors(strings.Split(spec, ",")...).Build()
+		if err != nil {
+			return nil, err
+		}
+	} else if fn, ok := grad_presets[spec]; ok {
+		grad = fn()
+	} else {
+		return nil, fmt.Errorf("unknown gradient \"%s\"", name)
+	}
+
+	gs := make([]GradSet, NUM_GRAD+1)
+	for j := range gs {
+		t := float64(j) / NUM_GRAD
+		if reverse {
+			t = 1 - t
+		}
+		r, g, b := grad.At(t).Clamped().RGB255()
+		gs[j] = GradSet{R: r, G: g, B: b, A: 0xff}
+	}
+	return gs, nil
+}
+
+func get_gradient(name string) ([]GradSet, bool) {
+	switch name {
+	case "rdgn":
+		return Get_gradset(GRAD_RGN), true
+	case "yor":
+		return Get_gradset(GRAD_YOR), true
+	case "red":
+		return Get_gradset(GRAD_RED), true
+	}
+	if gs, err := colorgrad_set(name); err == nil {
+		return gs, true
+	}
+	return Get_gradset(GRAD_RED), false
+}
+
+// Checks a -gradient value (empty is the default)
+func ValidateGradient(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := get_gradient(name); !ok {
+		_, err := colorgrad_set(name)
+		return err
+	}
+	return nil
+}
+
+// Style id prefix for a gradient; a layer's gradient defaults to
+// -gradient. Gradient specifications are not valid ids, so other
+// gradients are identified by a hash.
+func grad_style_name(cfg *options.Configuration, gname string) string {
+	if gname == "" || gname == cfg.Gradset {
+		return "styleGrad"
+	}
+	return fmt.Sprintf("styleGrad-%08x-", crc32.ChecksumIEEE([]byte(gname)))
+}
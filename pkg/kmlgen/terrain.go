@@ -0,0 +1,56 @@
+package kmlgen
+
+import (
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"github.com/twpayne/go-kml/icon"
+	"image/color"
+)
+
+import (
+	"analysis"
+	"geo"
+	"options"
+	"types"
+)
+
+var low_clearance = color.RGBA{R: 0xff, G: 0x20, B: 0x20, A: 0xff}
+
+// Segments below the -agl-min terrain clearance, and the minimum clearance
+func getTerrainClearance(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, tr analysis.TerrainReport) kml.Element {
+	var altoff float64
+	altmode := kml.AltitudeModeRelativeToGround
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		altoff = hpos.HomeAlt
+		altmode = kml.AltitudeModeAbsolute
+	}
+	f := kml.Folder(kml.Name("Terrain clearance")).Add(kml.Visibility(true))
+	r := rec.Items[tr.MinIndex]
+	f.Add(kml.Placemark(
+		kml.Name(fmt.Sprintf("Minimum clearance (%.0fm)", tr.Min)),
+		kml.Description(fmt.Sprintf("Terrain clearance %.0f m<br/>Time %s<br/>Position %s<br/>Altitude (rel. home) %.0f m<br/>",
+			tr.Min, r.Utc.Format("15:04:05"), geo.PositionFormat(r.Lat, r.Lon, cfg.Dms), r.Alt)),
+		kml.TimeStamp(kml.When(r.Utc)),
+		kml.Style(
+			kml.IconStyle(kml.Icon(kml.Href(icon.PaddleHref("red-diamond")))),
+		).Add(balloon_style(BS_NAME_DESC)),
+		kml.Point(
+			kml.AltitudeMode(altmode),
+			kml.Coordinates(kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: altoff + r.Alt}),
+		),
+	))
+	for _, l := range tr.Low {
+		r0, r1 := rec.Items[l.First], rec.Items[l.Last]
+		f.Add(kml.Placemark(
+			kml.Name(fmt.Sprintf("Below %.0fm", cfg.AglMin)),
+			kml.Description(fmt.Sprintf("Terrain clearance below %.0f m<br/>From %s to %s (%.0f s)<br/>Minimum %.0f m<br/>",
+				cfg.AglMin, r0.Utc.Format("15:04:05"), r1.Utc.Format("15:04:05"), l.End-l.Start, l.Min)),
+			kml.TimeSpan(kml.Begin(r0.Utc), kml.End(r1.Utc)),
+			kml.Style(
+				kml.LineStyle(kml.Color(low_clearance), kml.Width(4)),
+			).Add(balloon_style(BS_NAME_DESC)),
+			track_segment(rec.Items[l.First:l.Last+1], altmode, altoff),
+		))
+	}
+	return f
+}
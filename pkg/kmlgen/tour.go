@@ -0,0 +1,94 @@
+package kmlgen
+
+import (
+	kml "github.com/twpayne/go-kml"
+	"math"
+	"time"
+)
+
+import (
+	"options"
+	"types"
+)
+
+/*
+ * Chase camera gx:Tour. A gx:FlyTo (smooth) per key frame, looking at the
+ * aircraft from behind (along the course), at the configured range and
+ * tilt. Each view has a gx:TimeStamp, so the time slider (and any time
+ * dependent layers, e.g. the 3D model) follows the tour.
+ */
+
+// Minimum log time between key frames
+const TOUR_STEP = 1 * time.Second
+
+// Circular mean of the course over a few key frames, to remove jitter
+func smooth_course(cogs []float64, n int) []float64 {
+	res := make([]float64, len(cogs))
+	for j := range cogs {
+		var sx, sy float64
+		for k := j - n; k <= j+n; k++ {
+			if k >= 0 && k < len(cogs) {
+				sx += math.Sin(cogs[k] * math.Pi / 180)
+				sy += math.Cos(cogs[k] * math.Pi / 180)
+			}
+		}
+		c := math.Atan2(sx, sy) * 180 / math.Pi
+		if c < 0 {
+			c += 360
+		}
+		res[j] = c
+	}
+	return res
+}
+
+func getTour(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec) kml.Element {
+	var altoff float64
+	altmode := kml.AltitudeModeRelativeToGround
+	if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+		altoff = hpos.HomeAlt
+		altmode = kml.AltitudeModeAbsolute
+	}
+
+	var keys []types.LogItem
+	for _, r := range rec.Items {
+		if len(keys) == 0 || r.Utc.Sub(keys[len(keys)-1].Utc) >= TOUR_STEP {
+			keys = append(keys, r)
+		}
+	}
+	cogs := make([]float64, len(keys))
+	for j, r := range keys {
+		cogs[j] = float64(r.Cog)
+	}
+	cogs = smooth_course(cogs, 2)
+
+	speed := cfg.TourSpeed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	pl := kml.GxPlaylist()
+	for j, r := range keys {
+		// fly to the start, then each leg takes its log time (scaled)
+		dur := 2.0
+		mode := kml.GxFlyToModeBounce
+		if j > 0 {
+			dur = r.Utc.Sub(keys[j-1].Utc).Seconds() / speed
+			mode = kml.GxFlyToModeSmooth
+		}
+		pl.Add(kml.GxFlyTo(
+			kml.GxDuration(dur),
+			kml.GxFlyToMode(mode),
+			kml.LookAt(
+				kml.GxTimeStamp(kml.When(r.Utc)),
+				kml.Longitude(r.Lon),
+				kml.Latitude(r.Lat),
+				kml.Altitude(altoff+r.Alt),
+				kml.Heading(cogs[j]),
+				kml.Tilt(cfg.TourTilt),
+				kml.Range(cfg.TourRange),
+				kml.AltitudeMode(altmode),
+			),
+		))
+	}
+	return kml.GxTour(kml.Name("Chase camera"), pl)
+}
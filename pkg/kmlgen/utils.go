@@ -2,25 +2,32 @@ package kmlgen
 
 import (
 	"fmt"
+	kml "github.com/twpayne/go-kml"
 	"os"
 	"path/filepath"
 )
 
 import (
 	"options"
+	"types"
 )
 
 func GenKmlName(inp string, idx int) string {
+	if options.Config.Kml {
+		return GenOutName(inp, idx, "kml")
+	} else {
+		return GenOutName(inp, idx, "kmz")
+	}
+}
+
+// As GenKmlName, for an arbitrary output type (extension)
+func GenOutName(inp string, idx int, typ string) string {
 	outfn := filepath.Base(inp)
 	ext := filepath.Ext(outfn)
 	if len(ext) < len(outfn) {
 		outfn = outfn[0 : len(outfn)-len(ext)]
 	}
-	if options.Config.Kml {
-		ext = ".kml"
-	} else {
-		ext = ".kmz"
-	}
+	ext = "." + typ
 	if idx > 0 {
 		ext = fmt.Sprintf(".%d%s", idx, ext)
 	}
@@ -34,3 +41,14 @@ func GenKmlName(inp string, idx int) string {
 	}
 	return outfn
 }
+
+// The track (points with a fix) over a segment of the log
+func track_segment(items []types.LogItem, altmode kml.AltitudeModeEnum, altoff float64) kml.Element {
+	var pts []kml.Coordinate
+	for _, b := range items {
+		if b.Fix > 1 {
+			pts = append(pts, kml.Coordinate{Lon: b.Lon, Lat: b.Lat, Alt: altoff + b.Alt})
+		}
+	}
+	return kml.LineString(kml.AltitudeMode(altmode), kml.Coordinates(pts...))
+}
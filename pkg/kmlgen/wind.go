@@ -0,0 +1,134 @@
+package kmlgen
+
+import (
+	"bytes"
+	"fmt"
+	kml "github.com/twpayne/go-kml"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+import (
+	"analysis"
+	"options"
+	"types"
+)
+
+/*
+ * Wind barbs. The icon is drawn with the staff pointing up, and rotated
+ * (IconStyle heading) to the direction from which the wind blows. Barbs
+ * are in knots, rounded to 5kt; a pennant is 50kt, a full barb 10kt and
+ * a half barb 5kt. Calm (< 2.5kt) is a circle.
+ */
+
+const (
+	BARB_SIZE = 48
+	BARB_LEN  = 22
+	BARB_STEP = 5
+)
+
+var barb_colour = color.RGBA{R: 0x10, G: 0x10, B: 0x60, A: 0xff}
+
+func barb_knots(spd float64) int {
+	return 5 * int(math.Round(spd*1.943844/5))
+}
+
+func barb_line(img *image.RGBA, x0, y0, x1, y1 float64) {
+	n := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))) * 2
+	for j := 0; j <= n; j++ {
+		t := float64(j) / float64(n)
+		x := int(math.Round(x0 + t*(x1-x0)))
+		y := int(math.Round(y0 + t*(y1-y0)))
+		img.SetRGBA(x, y, barb_colour)
+		img.SetRGBA(x+1, y, barb_colour)
+	}
+}
+
+func barb_png(kt int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, BARB_SIZE, BARB_SIZE))
+	c := float64(BARB_SIZE / 2)
+	if kt < 5 {
+		for a := 0; a < 360; a += 5 {
+			s, co := math.Sincos(float64(a) * math.Pi / 180)
+			img.SetRGBA(int(c+6*s), int(c+6*co), barb_colour)
+		}
+	} else {
+		top := c - BARB_LEN
+		barb_line(img, c, c, c, top)
+		y := top
+		for ; kt >= 50; kt -= 50 {
+			for k := 0.0; k <= BARB_STEP; k++ {
+				barb_line(img, c, y+k, c+12, y)
+			}
+			y += BARB_STEP + 2
+		}
+		for ; kt >= 10; kt -= 10 {
+			barb_line(img, c, y, c+12, y-5)
+			y += BARB_STEP
+		}
+		if kt >= 5 {
+			if y == top {
+				y += BARB_STEP
+			}
+			barb_line(img, c, y, c+6, y-2.5)
+		}
+	}
+	// station
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			img.SetRGBA(int(c)+dx, int(c)+dy, barb_colour)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func getWind(cfg *options.Configuration, rec types.LogRec, hpos types.HomeRec, ests []analysis.WindEstimate,
+	outfn string, files map[string][]byte) kml.Element {
+	f := kml.Folder(kml.Name("Wind")).Add(kml.Visibility(true))
+	for _, e := range ests {
+		kt := barb_knots(e.Speed)
+		href := aux_href(outfn, fmt.Sprintf("wind%03d.png", kt))
+		if _, ok := files[href]; !ok {
+			data, err := barb_png(kt)
+			if err != nil {
+				continue
+			}
+			files[href] = data
+		}
+		r := rec.Items[e.Index]
+		alt := r.Alt
+		altmode := kml.AltitudeModeRelativeToGround
+		if (hpos.Flags & types.HOME_ALT) == types.HOME_ALT {
+			alt += hpos.HomeAlt
+			altmode = kml.AltitudeModeAbsolute
+		}
+		desc := fmt.Sprintf("Wind %.1f m/s (%d kt) from %.0f°<br/>Airspeed %.1f m/s (%s)<br/>Time %s<br/>",
+			e.Speed, kt, e.Dir, e.Airspd, e.Method, r.Utc.Format("15:04:05"))
+		k := kml.Placemark(
+			kml.Name(fmt.Sprintf("%.1f m/s %03.0f°", e.Speed, e.Dir)),
+			kml.Description(desc),
+			kml.TimeStamp(kml.When(r.Utc)),
+			kml.Style(
+				kml.IconStyle(
+					kml.Scale(1.5),
+					kml.Heading(e.Dir),
+					kml.Icon(kml.Href(href)),
+					kml.HotSpot(kml.Vec2{X: 0.5, Y: 0.5, XUnits: kml.UnitsFraction, YUnits: kml.UnitsFraction}),
+				),
+				kml.LabelStyle(kml.Scale(0)),
+			).Add(balloon_style(BS_NAME_DESC)),
+			kml.Point(
+				kml.AltitudeMode(altmode),
+				kml.Coordinates(kml.Coordinate{Lon: r.Lon, Lat: r.Lat, Alt: alt}),
+			),
+		)
+		f.Add(k)
+	}
+	return f
+}
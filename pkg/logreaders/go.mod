@@ -0,0 +1,3 @@
+module logreaders
+
+go 1.19
@@ -0,0 +1,12 @@
+// Package logreaders links in all the built-in flight log readers; each
+// registers itself with types.RegisterReader, so importing this package
+// makes every supported format available via types.NewFlightLog.
+package logreaders
+
+import (
+	_ "aplog"
+	_ "bbl"
+	_ "bltlog"
+	_ "mwplog"
+	_ "otx"
+)
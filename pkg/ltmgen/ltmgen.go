@@ -1,7 +1,9 @@
 package ltmgen
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -239,7 +241,7 @@ func read_mission() *mission.Mission {
 	return ms
 }
 
-func LTMGen(ch chan interface{}, meta types.FlightMeta) {
+func LTMGen(ctx context.Context, ch <-chan types.LogEvent, meta types.FlightMeta) {
 	var s *MSPSerial
 
 	typ := options.Config.Type
@@ -261,6 +263,7 @@ func LTMGen(ch chan interface{}, meta types.FlightMeta) {
 
 	laststat := uint8(255)
 	tgt := 0
+	wps := inav.NewWPState(options.Config.Intvl)
 	//	xnvs := byte(0)
 	//xtgt := 0
 
@@ -312,10 +315,18 @@ func LTMGen(ch chan interface{}, meta types.FlightMeta) {
 
 	done := false
 	for !done {
-		v := <-ch
-		switch v.(type) {
-		case types.LogItem:
-			b := v.(types.LogItem)
+		var ev types.LogEvent
+		ok := false
+		select {
+		case ev, ok = <-ch:
+		case <-ctx.Done():
+		}
+		if !ok {
+			break
+		}
+		switch ev.Type {
+		case types.EV_ITEM:
+			b := ev.Item
 			if st.IsZero() {
 				st = b.Utc
 			}
@@ -344,7 +355,7 @@ func LTMGen(ch chan interface{}, meta types.FlightMeta) {
 
 			if b.Fmode == types.FM_WP && ms != nil {
 				act := 0
-				tgt, act = inav.WP_state(ms, b, tgt)
+				tgt, act = wps.WP_state(ms, b, tgt)
 				//				fmt.Fprintf(os.Stderr, "WP N frame %v %v %v %v\n", xtgt, tgt, xnvs, b.NavMode)
 				//				if tgt != xtgt || b.NavMode != xnvs {
 				if b.Utc.After(g2t) {
@@ -410,8 +421,8 @@ func LTMGen(ch chan interface{}, meta types.FlightMeta) {
 				ld = d
 			}
 			lt = b.Utc
-		case types.HomeRec:
-			h := v.(types.HomeRec)
+		case types.EV_HOME:
+			h := ev.Home
 			if h.Flags&types.HOME_SAFE != 0 {
 				hlat = h.SafeLat
 				hlon = h.SafeLon
@@ -419,7 +430,12 @@ func LTMGen(ch chan interface{}, meta types.FlightMeta) {
 				hlat = h.HomeLat
 				hlon = h.HomeLon
 			}
-		case types.MapRec:
+		case types.EV_ERROR:
+			if !errors.Is(ev.Err, types.ErrNoGPSFix) {
+				log.Printf("ltmgen: %v\n", ev.Err)
+			}
+			done = true
+		case types.EV_END:
 			done = true
 		}
 	}
@@ -0,0 +1,3 @@
+module mwplog
+
+go 1.19
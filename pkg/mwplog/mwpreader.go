@@ -0,0 +1,446 @@
+package mwplog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+import (
+	"geo"
+	"options"
+	"types"
+)
+
+// mwp JSON log records, one object per line. Only the fields used here are
+// decoded; the "type" determines which are valid.
+type mwprec struct {
+	Type     string  `json:"type"`
+	Utime    float64 `json:"utime"`
+	Armed    bool    `json:"armed"`
+	Flags    uint32  `json:"flags"`
+	Name     string  `json:"name"`
+	FcVar    string  `json:"fc_var"`
+	FcVers   string  `json:"fc_vers"`
+	GitInfo  string  `json:"git_info"`
+	Lat      float64 `json:"lat"`
+	Lon      float64 `json:"lon"`
+	Cse      float64 `json:"cse"`
+	Spd      float64 `json:"spd"`
+	Alt      float64 `json:"alt"`
+	Fix      int     `json:"fix"`
+	Numsat   int     `json:"numsat"`
+	Hdop     float64 `json:"hdop"`
+	Bearing  float64 `json:"bearing"`
+	Range    float64 `json:"range"`
+	Angx     float64 `json:"angx"`
+	Angy     float64 `json:"angy"`
+	Heading  float64 `json:"heading"`
+	Estalt   float64 `json:"estalt"`
+	Voltage  float64 `json:"voltage"`
+	Power    float64 `json:"power"`
+	Rssi     int     `json:"rssi"`
+	Amps     float64 `json:"amps"`
+	Vbat     float64 `json:"vbat"`
+	Vcurr    float64 `json:"vcurr"`
+	Disarm   int     `json:"disarm_reason"`
+	NavMode  int     `json:"nav_mode"`
+	WpNumber int     `json:"wp_number"`
+	Mrtype   int     `json:"mrtype"`
+}
+
+// LTM flight modes, as found in ltm_raw_sframe flags >> 2
+var ltm_modes = [...]uint8{types.FM_MANUAL, types.FM_ACRO, types.FM_ANGLE, types.FM_HORIZON, types.FM_ACRO,
+	types.FM_ANGLE, types.FM_HORIZON, types.FM_ANGLE, types.FM_AH, types.FM_PH, types.FM_WP, types.FM_ACRO,
+	types.FM_ACRO, types.FM_RTH, types.FM_WP, types.FM_ACRO, types.FM_ACRO, types.FM_ACRO, types.FM_CRUISE3D,
+	types.FM_EMERG, types.FM_LAUNCH}
+
+type MWPLOG struct {
+	name string
+	meta []types.FlightMeta
+	cfg  *options.Configuration
+}
+
+func NewMWPReader(fn string) MWPLOG {
+	var l MWPLOG
+	l.name = fn
+	l.meta = nil
+	l.cfg = &options.Config
+	return l
+}
+
+func (o *MWPLOG) SetConfig(cfg *options.Configuration) {
+	o.cfg = cfg
+}
+
+func init() {
+	types.RegisterReader(types.LogReader{Ftype: types.IS_MWP, Name: "mwp",
+		Detect: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte(`{"type":`))
+		},
+		New: func(fn string) types.FlightLog {
+			l := NewMWPReader(fn)
+			return &l
+		}})
+}
+
+func (o *MWPLOG) LogType() byte {
+	return 'M'
+}
+
+func (o *MWPLOG) GetMetas() ([]types.FlightMeta, error) {
+	m, err := types.ReadMetaCache(o.name)
+	if err != nil {
+		m, err = metas(o.name)
+		if err == nil {
+			types.WriteMetaCache(o.name, m)
+		}
+	}
+	o.meta = m
+	return m, err
+}
+
+func (o *MWPLOG) GetDurations() {
+}
+
+// Shows the (first) "init" record
+func (o *MWPLOG) Dump() {
+	fh, err := os.Open(o.name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log file %s\n", err)
+		return
+	}
+	defer fh.Close()
+	scanner := new_scanner(fh)
+	for scanner.Scan() {
+		var m map[string]interface{}
+		if json.Unmarshal(scanner.Bytes(), &m) == nil && m["type"] == "init" {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("%s: %v\n", k, m[k])
+			}
+			return
+		}
+	}
+}
+
+// From the MSP (MultiWii) vehicle type of the "init" record
+func mw_vehicle(mrtype int) uint8 {
+	switch mrtype {
+	case 8, 14: // flying wing, aeroplane
+		return types.VEHICLE_FW
+	case 1, 2, 3, 6, 7, 9, 10, 11, 12, 13, 17, 18:
+		return types.VEHICLE_MR
+	}
+	return types.VEHICLE_UNKNOWN
+}
+
+func new_scanner(fh *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
+
+func time_from_utime(s float64) time.Time {
+	isec, fract := math.Modf(s)
+	return time.Unix(int64(isec), int64(fract*1e9))
+}
+
+// Flights are delimited by "armed" records; a log with no arming
+// information is treated as a single flight
+func metas(logfile string) ([]types.FlightMeta, error) {
+	var metas []types.FlightMeta
+
+	fh, err := os.Open(logfile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log file %s\n", err)
+		return metas, err
+	}
+	defer fh.Close()
+
+	basefile := filepath.Base(logfile)
+	var craft, fw, fwdate string
+	var vehicle uint8
+	var lt time.Time
+	inflight := false
+	nfix := 0
+	sawarm := false
+	i := 0
+
+	closeflight := func() {
+		n := len(metas) - 1
+		metas[n].End = i
+		metas[n].Duration = lt.Sub(metas[n].Date)
+		if nfix > 0 {
+			metas[n].Flags |= types.Is_Valid
+		}
+		inflight = false
+	}
+
+	newflight := func(st time.Time) {
+		mt := types.FlightMeta{Logname: basefile, Date: st, Index: len(metas) + 1, Start: i, Flags: types.Has_Start,
+			Vehicle: vehicle}
+		if craft != "" {
+			mt.Craft = craft
+			mt.Flags |= types.Has_Craft
+		}
+		if fw != "" {
+			mt.Firmware = fw
+			mt.Fwdate = fwdate
+			mt.Flags |= types.Has_Firmware
+		}
+		metas = append(metas, mt)
+		nfix = 0
+		inflight = true
+	}
+
+	scanner := new_scanner(fh)
+	for scanner.Scan() {
+		i += 1
+		var r mwprec
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		if r.Utime != 0 {
+			lt = time_from_utime(r.Utime)
+		}
+		switch r.Type {
+		case "init":
+			craft = r.Name
+			vehicle = mw_vehicle(r.Mrtype)
+			if r.FcVers != "" {
+				fw = strings.TrimSpace(r.FcVar + " " + r.FcVers)
+				fwdate = r.GitInfo
+			}
+		case "armed":
+			if r.Armed && !sawarm {
+				// Discard any pre-arm pseudo-flight
+				metas = metas[:0]
+				sawarm = true
+				newflight(lt)
+			} else if r.Armed && !inflight {
+				newflight(lt)
+			} else if !r.Armed && inflight {
+				closeflight()
+			}
+		case "raw_gps":
+			if !inflight && !sawarm {
+				newflight(lt)
+			}
+			if r.Fix > 1 {
+				nfix += 1
+			}
+		case "ltm_xframe":
+			if inflight && r.Disarm != 0 {
+				metas[len(metas)-1].Disarm = types.Reason(r.Disarm)
+				metas[len(metas)-1].Flags |= types.Has_Disarm
+			}
+		}
+	}
+	if inflight {
+		closeflight()
+	}
+
+	if len(metas) == 0 {
+		err = errors.New("No flights in mwp log")
+	}
+	return metas, err
+}
+
+func ltm_flight_mode(flags uint32) uint8 {
+	fm := flags >> 2
+	if int(fm) < len(ltm_modes) {
+		return ltm_modes[fm]
+	}
+	return types.FM_ACRO
+}
+
+func (lg *MWPLOG) Reader(m types.FlightMeta, stream *types.LogStream) (types.LogSegment, error) {
+	var stats types.LogStats
+	var homes types.HomeRec
+	ls := types.LogSegment{}
+	var lt, st time.Time
+
+	fh, err := os.Open(lg.name)
+	if err != nil {
+		return ls, err
+	}
+	defer fh.Close()
+
+	scanner := new_scanner(fh)
+	i := 0
+	rec := types.LogRec{}
+	b := types.LogItem{Fmtext: types.Mnames[types.FM_ACRO]}
+	var llat, llon float64
+	leffic := 0.0
+	lwhkm := 0.0
+	whacc := 0.0
+	for scanner.Scan() {
+		i += 1
+		if i < m.Start {
+			continue
+		}
+		if i > m.End {
+			break
+		}
+		var r mwprec
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		switch r.Type {
+		case "armed":
+			if r.Armed {
+				b.Status |= types.Is_ARMED
+			} else {
+				b.Status &= ^types.Is_ARMED
+			}
+		case "attitude":
+			b.Roll = int16(r.Angx)
+			b.Pitch = int16(r.Angy)
+			b.Cse = uint32(r.Heading)
+		case "altitude":
+			b.Alt = r.Estalt
+			rec.Cap |= types.CAP_ALTITUDE
+		case "analog":
+			b.Volts = r.Voltage
+			rec.Cap |= types.CAP_VOLTS
+			if r.Amps > 0 {
+				b.Amps = r.Amps
+				rec.Cap |= types.CAP_AMPS
+			}
+			if r.Power > 0 {
+				b.Energy = r.Power
+				rec.Cap |= types.CAP_ENERGY
+			}
+			if r.Rssi > 0 {
+				b.Rssi = uint8(r.Rssi * 100 / 1023)
+				rec.Cap |= types.CAP_RSSI_VALID
+			}
+		case "ltm_raw_sframe":
+			b.Status = uint8(r.Flags) & (types.Is_ARMED | types.Is_FAIL)
+			b.Fmode = ltm_flight_mode(r.Flags)
+			b.Fmtext = types.Mnames[b.Fmode]
+		case "navstatus":
+			b.NavMode = byte(r.NavMode)
+			b.ActiveWP = uint8(r.WpNumber)
+		case "comp_gps":
+			b.Bearing = int32(r.Bearing)
+			b.Vrange = r.Range
+		case "raw_gps":
+			b.Lat = r.Lat
+			b.Lon = r.Lon
+			b.GAlt = r.Alt
+			b.Spd = r.Spd
+			b.Cog = uint32(r.Cse)
+			b.Numsat = uint8(r.Numsat)
+			b.Hdop = uint16(r.Hdop * 100)
+			switch {
+			case r.Fix > 1:
+				b.Fix = 2
+			case r.Fix == 1:
+				b.Fix = 1
+			default:
+				b.Fix = 0
+			}
+			rec.Cap |= types.CAP_SPEED
+			if b.Fix < 2 || (b.Lat == 0 && b.Lon == 0) {
+				break
+			}
+			b.Utc = time_from_utime(r.Utime)
+			if homes.Flags == 0 {
+				homes.HomeLat = b.Lat
+				homes.HomeLon = b.Lon
+				homes.HomeAlt = b.GAlt
+				homes.Flags = types.HOME_ARM | types.HOME_ALT
+				st = b.Utc
+				llat = b.Lat
+				llon = b.Lon
+				if stream != nil && !stream.Home(homes) {
+					return ls, stream.Err()
+				}
+			}
+			if lt.IsZero() || b.Utc.Sub(lt).Milliseconds() >= int64(lg.cfg.Intvl) {
+				b.Stamp = uint64(b.Utc.Sub(st).Microseconds())
+				c, d := geo.Csedist(homes.HomeLat, homes.HomeLon, b.Lat, b.Lon)
+				b.Bearing = int32(c)
+				b.Vrange = d * 1852.0
+				if d > stats.Max_range {
+					stats.Max_range = d
+					stats.Max_range_time = b.Stamp
+				}
+				if llat != b.Lat || llon != b.Lon {
+					_, d = geo.Csedist(llat, llon, b.Lat, b.Lon)
+					stats.Distance += d
+				}
+				b.Tdist = stats.Distance * 1852.0
+				llat = b.Lat
+				llon = b.Lon
+
+				if b.Alt > stats.Max_alt {
+					stats.Max_alt = b.Alt
+					stats.Max_alt_time = b.Stamp
+				}
+				if b.Spd < 400 && b.Spd > stats.Max_speed {
+					stats.Max_speed = b.Spd
+					stats.Max_speed_time = b.Stamp
+				}
+				if b.Amps > stats.Max_current {
+					stats.Max_current = b.Amps
+					stats.Max_current_time = b.Stamp
+				}
+
+				if (rec.Cap&types.CAP_AMPS) == types.CAP_AMPS && !lt.IsZero() {
+					deltat := b.Utc.Sub(lt).Seconds()
+					if b.Spd > 0 {
+						b.Effic = b.Amps * 1000 / (3.6 * b.Spd) // efficiency mAh/km
+						leffic = b.Effic
+						b.Whkm = b.Amps * b.Volts / (3.6 * b.Spd)
+						lwhkm = b.Whkm
+					} else {
+						b.Effic = leffic
+						b.Whkm = lwhkm
+					}
+					whacc += b.Amps * b.Volts * deltat / 3600
+					b.WhAcc = whacc
+				}
+
+				lt = b.Utc
+				if stream != nil {
+					if !stream.Item(b) {
+						return ls, stream.Err()
+					}
+				} else {
+					rec.Items = append(rec.Items, b)
+				}
+			}
+		}
+	}
+
+	srec := stats.Summary(uint64(lt.Sub(st).Microseconds()))
+	if stream != nil {
+		ls.M = srec
+		ls.S = stats
+	} else if homes.Flags != 0 && len(rec.Items) > 0 {
+		ls.L = rec
+		ls.H = homes
+		ls.M = srec
+		ls.S = stats
+	}
+	// A fix on the last record sets the home, but leaves no items
+	if homes.Flags == 0 || (stream == nil && len(rec.Items) == 0) {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, m.Index, types.ErrNoGPSFix)
+	}
+	return ls, nil
+}
@@ -0,0 +1,85 @@
+package mwplog
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+import (
+	"types"
+)
+
+// testdata/two_flights.json has a pre-arm fix-less GPS record, then two
+// armed periods, each with two GPS fixes
+func TestMWPFlights(t *testing.T) {
+	// The meta cache is in $HOME
+	t.Setenv("HOME", t.TempDir())
+	lg := NewMWPReader(filepath.Join("testdata", "two_flights.json"))
+	metas, err := lg.GetMetas()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 2 {
+		t.Fatalf("%d flights, want 2", len(metas))
+	}
+
+	want := []struct {
+		start, end int
+		date       int64
+		dur        time.Duration
+		disarm     types.Reason
+	}{
+		{3, 10, 1700000010, 2 * time.Second, 4},
+		{11, 14, 1700000100, 3 * time.Second, 0},
+	}
+	for j, w := range want {
+		m := metas[j]
+		if m.Index != j+1 || m.Start != w.start || m.End != w.end {
+			t.Errorf("flight %d: index %d, records %d-%d, want %d-%d", j+1, m.Index, m.Start, m.End, w.start, w.end)
+		}
+		if m.Date.Unix() != w.date || m.Duration != w.dur {
+			t.Errorf("flight %d: start %v, duration %v", j+1, m.Date, m.Duration)
+		}
+		if m.Flags&types.Is_Valid == 0 || m.Craft != "TESTWING" || m.Firmware != "INAV 7.1.0" ||
+			m.Vehicle != types.VEHICLE_FW {
+			t.Errorf("flight %d: %+v", j+1, m)
+		}
+		if m.Disarm != w.disarm {
+			t.Errorf("flight %d: disarm %v, want %v", j+1, m.Disarm, w.disarm)
+		}
+	}
+
+	homes := [][2]float64{{51.5, -0.12}, {51.6, -0.2}}
+	for j, m := range metas {
+		ls, err := lg.Reader(m, nil)
+		if err != nil {
+			t.Fatalf("flight %d: %v", j+1, err)
+		}
+		if ls.H.Flags&types.HOME_ARM == 0 || ls.H.HomeLat != homes[j][0] || ls.H.HomeLon != homes[j][1] {
+			t.Errorf("flight %d: home %+v", j+1, ls.H)
+		}
+		items := ls.L.Items
+		if len(items) != 2 {
+			t.Fatalf("flight %d: %d items, want 2", j+1, len(items))
+		}
+		if items[0].Lat != homes[j][0] || items[0].Lon != homes[j][1] || items[0].Stamp != 0 {
+			t.Errorf("flight %d: first item %+v", j+1, items[0])
+		}
+		if items[1].Vrange < 1 || math.Abs(items[1].Tdist-items[1].Vrange) > 0.1 {
+			t.Errorf("flight %d: range %.1f, distance %.1f", j+1, items[1].Vrange, items[1].Tdist)
+		}
+	}
+
+	// The first flight has the analog and attitude data
+	ls, _ := lg.Reader(metas[0], nil)
+	b := ls.L.Items[1]
+	if b.Volts != 12.4 || b.Amps != 5.5 || b.Rssi != 100 || b.Alt != 10.5 || b.Roll != -5 || b.Pitch != 3 {
+		t.Errorf("flight 1: %+v", b)
+	}
+	if ls.L.Cap&(types.CAP_VOLTS|types.CAP_AMPS|types.CAP_RSSI_VALID|types.CAP_ALTITUDE) !=
+		types.CAP_VOLTS|types.CAP_AMPS|types.CAP_RSSI_VALID|types.CAP_ALTITUDE {
+		t.Errorf("flight 1: capabilities %x", ls.L.Cap)
+	}
+}
@@ -21,16 +21,34 @@ type Configuration struct {
 	Extrude         bool    `json:"extrude"`
 	Fast            bool    `json:"-"`
 	Kml             bool    `json:"kml"`
+	Compact         bool    `json:"compact"`
+	Merge           bool    `json:"-"`
+	Events          bool    `json:"events"`
+	Vibration       bool    `json:"-"`
+	Link            bool    `json:"-"`
+	LowCell         float64 `json:"low-cell"`
+	Agl             bool    `json:"agl"`
+	AglMin          float64 `json:"agl-min"`
+	Model           string  `json:"model"`
+	ModelScale      float64 `json:"model-scale"`
+	Tour            bool    `json:"tour"`
+	TourRange       float64 `json:"tour-range"`
+	TourTilt        float64 `json:"tour-tilt"`
+	TourSpeed       float64 `json:"tour-speed"`
+	Format          string  `json:"format"`
 	Metas           bool    `json:"-"`
 	Rssi            bool    `json:"rssi"`
 	Summary         bool    `json:"-"`
+	SummaryFormat   string  `json:"summary-format"`
 	Bulletvers      int     `json:"blt-vers"`
 	Intvl           int     `json:"-"`
 	Idx             int     `json:"-"`
+	Jobs            int     `json:"jobs"`
 	HomeAlt         int     `json:"home-alt"`
 	SplitTime       int     `json:"split-time"`
 	Type            int     `json:"type"`
 	Blackbox_decode string  `json:"blackbox-decode"`
+	BBLExternal     bool    `json:"external-decoder"`
 	Gradset         string  `json:"gradient"`
 	Engunit         string  `json:"energy-unit"`
 	LTMdev          string  `json:"-"`
@@ -49,6 +67,7 @@ type Configuration struct {
 	Modefilter      string  `json:"-"`
 	UseTopo         bool    `json:"-"`
 	Attribs         string  `json:"attributes"`
+	Layers          []Layer `json:"layers,omitempty"`
 	Aflags          int     `json:"-"`
 	RedIsFast       bool    `json:"fast-is-red"`
 	RedIsLow        bool    `json:"low-is-red"`
@@ -62,7 +81,26 @@ type Configuration struct {
 	SitlMinimal     bool    `json:"-"`
 }
 
-var Config Configuration = Configuration{Intvl: 1000, Blackbox_decode: "blackbox_decode", Bulletvers: 2, SplitTime: 120, Epsilon: 0.015, StartOff: 30, EndOff: -30, Engunit: "mah", MaxWP: 120}
+// A user defined KML attribute layer. Field is a log field name, or an
+// arithmetic expression of fields. The value is coloured between Min and
+// Max or, if these are equal (unset), the 5% and 95% quantiles.
+type Layer struct {
+	Name     string  `json:"name"`
+	Field    string  `json:"field"`
+	Min      float64 `json:"min,omitempty"`
+	Max      float64 `json:"max,omitempty"`
+	Invert   bool    `json:"invert,omitempty"`
+	Gradient string  `json:"gradient,omitempty"`
+	Units    string  `json:"units,omitempty"`
+}
+
+// Implemented by readers etc. that can use a per-session configuration
+// (vice the global Config)
+type Configurable interface {
+	SetConfig(*Configuration)
+}
+
+var Config Configuration = Configuration{Intvl: 1000, Blackbox_decode: "blackbox_decode", Bulletvers: 2, SplitTime: 120, Epsilon: 0.015, StartOff: 30, EndOff: -30, Engunit: "mah", MaxWP: 120, ModelScale: 10, TourRange: 150, TourTilt: 70, TourSpeed: 1, LowCell: 3.3, AglMin: 30, Jobs: 1}
 
 func isFlagSet(name string) bool {
 	found := false
@@ -182,15 +220,35 @@ func ParseCLI(gv func() string) ([]string, string) {
 		flag.BoolVar(&Config.Kml, "kml", Config.Kml, "Generate KML (vice default KMZ)")
 		flag.BoolVar(&Config.Rssi, "rssi", Config.Rssi, "Set RSSI view as default")
 		flag.BoolVar(&Config.Extrude, "extrude", Config.Extrude, "Extends track points to ground")
+		flag.BoolVar(&Config.Compact, "compact", Config.Compact, "Compact KML/Z, using gx:Track (vice a placemark per point)")
 		flag.BoolVar(&Config.Efficiency, "efficiency", Config.Efficiency, "Include efficiency layer in KML/Z")
 		flag.StringVar(&Config.Engunit, "energy-unit", Config.Engunit, "Energy unit [mah, wh]")
-		flag.StringVar(&Config.Gradset, "gradient", Config.Gradset, "Specific colour gradient [red,rdgn,yor]")
+		flag.StringVar(&Config.Gradset, "gradient", Config.Gradset, "Colour gradient [red,rdgn,yor], colorgrad preset or colour list")
 		flag.BoolVar(&Config.Dms, "dms", Config.Dms, "Show positions as DD:MM:SS.s (vice decimal degrees)")
 		flag.StringVar(&Config.Outdir, "outdir", Config.Outdir, "Output directory for generated KML")
 		flag.IntVar(&Config.Visibility, "visibility", Config.Visibility, "0=folder value,-1=don't set,1=all on")
 		flag.BoolVar(&Config.Summary, "summary", Config.Summary, "Just show summary")
-		flag.StringVar(&Config.Attribs, "attributes", Config.Attribs, "Attributes to plot (effic,speed,altitude)")
+		flag.StringVar(&Config.SummaryFormat, "summary-format", Config.SummaryFormat, "Summary output format [text,json,csv]")
+		flag.StringVar(&Config.Attribs, "attributes", Config.Attribs, "Attributes to plot (effic,speed,altitude,battery,sag)")
+		flag.IntVar(&Config.Jobs, "jobs", Config.Jobs, "Number of concurrent conversions")
+		if !strings.HasPrefix(app, "mission2kml") {
+			flag.StringVar(&Config.Format, "format", Config.Format, "Output format [kmz,kml,gpx,igc,csv,geojson,czml] (-kml is the same as -format kml)")
+			flag.StringVar(&Config.Model, "model", Config.Model, "Include 3D model layer in KML/Z [auto,fw,mr]")
+			flag.Float64Var(&Config.ModelScale, "model-scale", Config.ModelScale, "3D model scale")
+			flag.BoolVar(&Config.Merge, "merge", Config.Merge, "Merge all logs / segments into one KML/Z")
+			flag.BoolVar(&Config.Events, "events", Config.Events, "Write flight events as JSON")
+			flag.BoolVar(&Config.Link, "link", Config.Link, "Link quality analysis; RSSI / LQ report and polar coverage plot")
+			flag.BoolVar(&Config.Vibration, "vibration", Config.Vibration, "[BBL] Vibration analysis; spectra plots and report (vice track output)")
+			flag.Float64Var(&Config.LowCell, "low-cell", Config.LowCell, "Low voltage threshold (V/cell); events and usable capacity (0 disables the event, capacity uses 3.3)")
+			flag.BoolVar(&Config.Agl, "agl", Config.Agl, "Terrain clearance (AGL) from the DEM (downloads DEM tiles as required)")
+			flag.Float64Var(&Config.AglMin, "agl-min", Config.AglMin, "Low terrain clearance threshold (m, 0 disables)")
+			flag.BoolVar(&Config.Tour, "tour", Config.Tour, "Include chase camera tour in KML/Z")
+			flag.Float64Var(&Config.TourRange, "tour-range", Config.TourRange, "Tour camera distance (m)")
+			flag.Float64Var(&Config.TourTilt, "tour-tilt", Config.TourTilt, "Tour camera tilt (degrees, 0 is vertical)")
+			flag.Float64Var(&Config.TourSpeed, "tour-speed", Config.TourSpeed, "Tour playback speed (multiple of real time)")
+		}
 	}
+	flag.BoolVar(&Config.BBLExternal, "external-decoder", Config.BBLExternal, "[BBL] Use blackbox_decode (vice built-in decoder)")
 	flag.StringVar(&Config.Rebase, "rebase", "", "rebase all positions on lat,lon[,alt]")
 	flag.IntVar(&Config.Intvl, "interval", Config.Intvl, "Sampling Interval (ms)")
 	flag.BoolVar(&showversion, "version", false, "Just show version")
@@ -211,6 +269,20 @@ func ParseCLI(gv func() string) ([]string, string) {
 	if strings.HasPrefix(app, "bbsummary") {
 		Config.Summary = true
 	}
+	Config.Format = strings.ToLower(Config.Format)
+	if Config.Format == "" || (Config.Kml && isFlagSet("kml")) {
+		if Config.Kml {
+			Config.Format = "kml"
+		} else {
+			Config.Format = "kmz"
+		}
+	}
+	switch Config.Format {
+	case "kml":
+		Config.Kml = true
+	case "kmz":
+		Config.Kml = false
+	}
 	if !isFlagSet("home-alt") {
 		Config.HomeAlt = -999999 // sentinel
 	}
@@ -247,6 +319,9 @@ func ParseCLI(gv func() string) ([]string, string) {
 		if strings.Contains(Config.Attribs, "battery") {
 			Config.Aflags |= types.AFlags_BATTERY
 		}
+		if strings.Contains(Config.Attribs, "sag") {
+			Config.Aflags |= types.AFlags_SAG
+		}
 	}
 
 	files := flag.Args()
@@ -1,6 +1,7 @@
 package otx
 
 import (
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"fmt"
@@ -27,15 +28,32 @@ const TIMEDATE = "2006-01-02 15:04:05"
 type OTXLOG struct {
 	name string
 	meta []types.FlightMeta
+	cfg  *options.Configuration
 }
 
 func NewOTXReader(fn string) OTXLOG {
 	var l OTXLOG
 	l.name = fn
 	l.meta = nil
+	l.cfg = &options.Config
 	return l
 }
 
+func (o *OTXLOG) SetConfig(cfg *options.Configuration) {
+	o.cfg = cfg
+}
+
+func init() {
+	types.RegisterReader(types.LogReader{Ftype: types.IS_OTX, Name: "OpenTX",
+		Detect: func(sig []byte) bool {
+			return bytes.HasPrefix(sig, []byte("Date,Time,"))
+		},
+		New: func(fn string) types.FlightLog {
+			l := NewOTXReader(fn)
+			return &l
+		}})
+}
+
 func (o *OTXLOG) LogType() byte {
 	return 'O'
 }
@@ -43,8 +61,10 @@ func (o *OTXLOG) LogType() byte {
 func (o *OTXLOG) GetMetas() ([]types.FlightMeta, error) {
 	m, err := types.ReadMetaCache(o.name)
 	if err != nil {
-		m, err = metas(o.name)
-		types.WriteMetaCache(o.name, m)
+		m, err = metas(o.name, o.cfg)
+		if err == nil {
+			types.WriteMetaCache(o.name, m)
+		}
 	}
 	o.meta = m
 	return m, err
@@ -54,19 +74,16 @@ func (o *OTXLOG) GetDurations() {
 }
 
 func (o *OTXLOG) Dump() {
-	if hdrs == nil || len(hdrs) == 0 {
-		fh, err := os.Open(o.name)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "log file %s\n", err)
-			return
-		}
-		defer fh.Close()
-		r := csv.NewReader(fh)
-		r.TrimLeadingSpace = true
-		record, err := r.Read()
-		read_headers(record) // for future usage
+	fh, err := os.Open(o.name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "log file %s\n", err)
+		return
 	}
-	dump_headers()
+	defer fh.Close()
+	r := csv.NewReader(fh)
+	r.TrimLeadingSpace = true
+	record, err := r.Read()
+	dump_headers(read_headers(record))
 }
 
 type hdrrec struct {
@@ -74,10 +91,10 @@ type hdrrec struct {
 	u string
 }
 
-var hdrs map[string]hdrrec
+type otxhdrs map[string]hdrrec
 
-func read_headers(r []string) {
-	hdrs = make(map[string]hdrrec)
+func read_headers(r []string) otxhdrs {
+	hdrs := make(otxhdrs)
 	rx := regexp.MustCompile(`(\w+)\(([A-Za-z/@%°]*)\)`)
 	var k string
 	var u string
@@ -92,9 +109,10 @@ func read_headers(r []string) {
 		}
 		hdrs[k] = hdrrec{i, u}
 	}
+	return hdrs
 }
 
-func metas(otxfile string) ([]types.FlightMeta, error) {
+func metas(otxfile string, cfg *options.Configuration) ([]types.FlightMeta, error) {
 	var metas []types.FlightMeta
 
 	fh, err := os.Open(otxfile)
@@ -116,12 +134,13 @@ func metas(otxfile string) ([]types.FlightMeta, error) {
 	for i := 1; ; i++ {
 		record, err := r.Read()
 		if err == io.EOF {
-			metas[idx-1].End = (i - 1)
-			metas[idx-1].Duration = lasttm.Sub(metas[idx-1].Date)
+			if idx > 0 {
+				metas[idx-1].End = (i - 1)
+				metas[idx-1].Duration = lasttm.Sub(metas[idx-1].Date)
+			}
 			break
 		}
 		if i == 1 {
-			read_headers(record) // for future usage
 			for j, s := range record {
 				switch s {
 				case "Date":
@@ -139,7 +158,7 @@ func metas(otxfile string) ([]types.FlightMeta, error) {
 			sb.WriteByte(' ')
 			sb.WriteString(record[tindex])
 			t_utc, _ := time.Parse(LOGTIMEPARSE, sb.String())
-			if i == 2 || (options.Config.SplitTime > 0 && t_utc.Sub(lasttm).Seconds() > (time.Duration(options.Config.SplitTime)*time.Second).Seconds()) {
+			if i == 2 || (cfg.SplitTime > 0 && t_utc.Sub(lasttm).Seconds() > (time.Duration(cfg.SplitTime)*time.Second).Seconds()) {
 				if idx > 0 {
 					metas[idx-1].End = i - 1
 					metas[idx-1].Duration = lasttm.Sub(metas[idx-1].Date)
@@ -151,8 +170,7 @@ func metas(otxfile string) ([]types.FlightMeta, error) {
 			lasttm = t_utc
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "reader %s\n", err)
-			return metas, err
+			return metas, fmt.Errorf("%w: %v", types.ErrCorruptSegment, err)
 		}
 	}
 
@@ -167,7 +185,7 @@ func metas(otxfile string) ([]types.FlightMeta, error) {
 	return metas, err
 }
 
-func dump_headers() {
+func dump_headers(hdrs otxhdrs) {
 	var s string
 	n := map[int][]string{}
 	var a []int
@@ -191,7 +209,7 @@ func dump_headers() {
 	}
 }
 
-func get_rec_value(r []string, key string) (string, string, bool) {
+func (hdrs otxhdrs) get_rec_value(r []string, key string) (string, string, bool) {
 	var s string
 	v, ok := hdrs[key]
 	if ok {
@@ -204,7 +222,7 @@ func get_rec_value(r []string, key string) (string, string, bool) {
 	return s, v.u, ok
 }
 
-func dataCapability() uint16 {
+func (hdrs otxhdrs) dataCapability() uint16 {
 	var ret uint16 = 0
 	var ok bool
 	if _, ok = hdrs["Curr"]; ok {
@@ -235,6 +253,10 @@ func dataCapability() uint16 {
 		ret |= types.CAP_ALTITUDE
 	}
 
+	if _, ok = hdrs["RQly"]; ok {
+		ret |= types.CAP_LQ
+	}
+
 	return ret
 }
 
@@ -252,10 +274,10 @@ func normalise_units(v float64, u string) float64 {
 	return v
 }
 
-func get_otx_line(r []string) types.LogItem {
+func (hdrs otxhdrs) get_otx_line(r []string) types.LogItem {
 	b := types.LogItem{}
 	status := uint8(0)
-	if s, _, ok := get_rec_value(r, "Tmp2"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Tmp2"); ok {
 		tmp2, _ := strconv.ParseInt(s, 10, 32)
 		b.Numsat = uint8(tmp2 % 100)
 		gfix := tmp2 / 1000
@@ -270,7 +292,7 @@ func get_otx_line(r []string) types.LogItem {
 		b.Hdop = uint16(550 - (hdp * 50))
 	}
 
-	if s, _, ok := get_rec_value(r, "GPS"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "GPS"); ok {
 		lstr := strings.Split(s, " ")
 		if len(lstr) == 2 {
 			b.Lat, _ = strconv.ParseFloat(lstr[0], 64)
@@ -278,8 +300,8 @@ func get_otx_line(r []string) types.LogItem {
 		}
 	}
 
-	if s, _, ok := get_rec_value(r, "Date"); ok {
-		if s1, _, ok := get_rec_value(r, "Time"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Date"); ok {
+		if s1, _, ok := hdrs.get_rec_value(r, "Time"); ok {
 			var sb strings.Builder
 			sb.WriteString(s)
 			sb.WriteByte(' ')
@@ -288,19 +310,19 @@ func get_otx_line(r []string) types.LogItem {
 		}
 	}
 
-	if s, u, ok := get_rec_value(r, "Alt"); ok {
+	if s, u, ok := hdrs.get_rec_value(r, "Alt"); ok {
 		b.Alt, _ = strconv.ParseFloat(s, 64)
 		b.Alt = normalise_units(b.Alt, u)
 	}
 
-	if s, u, ok := get_rec_value(r, "GAlt"); ok {
+	if s, u, ok := hdrs.get_rec_value(r, "GAlt"); ok {
 		b.GAlt, _ = strconv.ParseFloat(s, 64)
 		b.GAlt = normalise_units(b.GAlt, u)
 	} else {
 		b.GAlt = -999999.9
 	}
 
-	if s, units, ok := get_rec_value(r, "GSpd"); ok {
+	if s, units, ok := hdrs.get_rec_value(r, "GSpd"); ok {
 		spd, _ := strconv.ParseFloat(s, 64)
 		spd = normalise_units(spd, units)
 		if spd > 255 || spd < 0 {
@@ -309,7 +331,7 @@ func get_otx_line(r []string) types.LogItem {
 		b.Spd = spd
 	}
 
-	if s, _, ok := get_rec_value(r, "Hdg"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Hdg"); ok {
 		v, _ := strconv.ParseFloat(s, 64)
 		if v < 0 {
 			v += 360.0
@@ -318,25 +340,25 @@ func get_otx_line(r []string) types.LogItem {
 		b.Cog = b.Cse
 	}
 
-	if s, _, ok := get_rec_value(r, "AccX"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "AccX"); ok {
 		ax, _ := strconv.ParseFloat(s, 64)
-		if s, _, ok := get_rec_value(r, "AccY"); ok {
+		if s, _, ok := hdrs.get_rec_value(r, "AccY"); ok {
 			ay, _ := strconv.ParseFloat(s, 64)
-			if s, _, ok = get_rec_value(r, "AccZ"); ok {
+			if s, _, ok = hdrs.get_rec_value(r, "AccZ"); ok {
 				az, _ := strconv.ParseFloat(s, 64)
 				b.Pitch, b.Roll = acc_to_ah(ax, ay, az)
 			}
 		}
 	}
 
-	if s, _, ok := get_rec_value(r, "Thr"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Thr"); ok {
 		v, _ := strconv.ParseInt(s, 10, 32)
 		b.Throttle = int(v)
 	}
 
 	md := uint8(0)
 
-	if s, _, ok := get_rec_value(r, "Tmp1"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Tmp1"); ok {
 		tmp1, _ := strconv.ParseInt(s, 10, 32)
 		modeE := tmp1 % 10
 		modeD := (tmp1 % 100) / 10
@@ -382,7 +404,7 @@ func get_otx_line(r []string) types.LogItem {
 		}
 	}
 
-	if s, _, ok := get_rec_value(r, "ARM"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "ARM"); ok {
 		as, _ := strconv.ParseInt(s, 10, 32)
 		if as == 100 {
 			status |= (types.Is_ARMED | types.Is_ARDU)
@@ -396,25 +418,30 @@ func get_otx_line(r []string) types.LogItem {
 		}
 	}
 
-	if s, _, ok := get_rec_value(r, "RSSI"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "RSSI"); ok {
 		rssi, _ := strconv.ParseInt(s, 10, 32)
 		b.Rssi = uint8(rssi)
 	}
 
-	if s, _, ok := get_rec_value(r, "VFAS"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "VFAS"); ok {
 		b.Volts, _ = strconv.ParseFloat(s, 64)
 	}
 
-	if s, _, ok := get_rec_value(r, "1RSS"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "1RSS"); ok {
 		status |= types.Is_CRSF
 		rssi, _ := strconv.ParseInt(s, 10, 32)
 		b.Rssi = uint8(rssi)
 
-		if s, _, ok = get_rec_value(r, "RxBt"); ok {
+		if s, _, ok = hdrs.get_rec_value(r, "RQly"); ok {
+			lq, _ := strconv.ParseInt(s, 10, 32)
+			b.LQ = uint8(lq)
+		}
+
+		if s, _, ok = hdrs.get_rec_value(r, "RxBt"); ok {
 			b.Volts, _ = strconv.ParseFloat(s, 64)
 		}
 
-		if s, _, ok = get_rec_value(r, "FM"); ok {
+		if s, _, ok = hdrs.get_rec_value(r, "FM"); ok {
 			md = 0
 			status |= types.Is_ARMED
 			switch s {
@@ -445,7 +472,7 @@ func get_otx_line(r []string) types.LogItem {
 			}
 
 			if s == "0" {
-				if s, _, ok := get_rec_value(r, "Thr"); ok {
+				if s, _, ok := hdrs.get_rec_value(r, "Thr"); ok {
 					thr, _ := strconv.ParseInt(s, 10, 32)
 					if thr > -1024 {
 						status |= types.Is_ARMED
@@ -454,7 +481,7 @@ func get_otx_line(r []string) types.LogItem {
 			}
 		}
 
-		if s, _, ok := get_rec_value(r, "Sats"); ok {
+		if s, _, ok := hdrs.get_rec_value(r, "Sats"); ok {
 			ns, _ := strconv.ParseInt(s, 10, 16)
 			b.Numsat = uint8(ns)
 			if ns > 5 {
@@ -472,7 +499,7 @@ func get_otx_line(r []string) types.LogItem {
 			}
 		}
 
-		if s, u, ok := get_rec_value(r, "Yaw"); ok {
+		if s, u, ok := hdrs.get_rec_value(r, "Yaw"); ok {
 			v1, _ := strconv.ParseFloat(s, 64)
 			cse := 0.0
 			if u == "rad" {
@@ -486,7 +513,7 @@ func get_otx_line(r []string) types.LogItem {
 			b.Cse = uint32(cse)
 		}
 
-		if s, u, ok := get_rec_value(r, "Ptch"); ok {
+		if s, u, ok := hdrs.get_rec_value(r, "Ptch"); ok {
 			v1, _ := strconv.ParseFloat(s, 64)
 			if u == "rad" {
 				b.Pitch = int16(to_degrees(v1))
@@ -494,7 +521,7 @@ func get_otx_line(r []string) types.LogItem {
 				b.Pitch = int16(v1)
 			}
 		}
-		if s, u, ok := get_rec_value(r, "Roll"); ok {
+		if s, u, ok := hdrs.get_rec_value(r, "Roll"); ok {
 			v1, _ := strconv.ParseFloat(s, 64)
 			if u == "rad" {
 				b.Roll = int16(to_degrees(v1))
@@ -506,14 +533,14 @@ func get_otx_line(r []string) types.LogItem {
 	b.Fmode = md
 	b.Fmtext = types.Mnames[md]
 
-	if s, u, ok := get_rec_value(r, "Curr"); ok {
+	if s, u, ok := hdrs.get_rec_value(r, "Curr"); ok {
 		b.Amps, _ = strconv.ParseFloat(s, 64)
 		if u == "mA" {
 			b.Amps /= 1000
 		}
-		if s, u, ok = get_rec_value(r, "Fuel"); ok {
+		if s, u, ok = hdrs.get_rec_value(r, "Fuel"); ok {
 			b.Energy, _ = strconv.ParseFloat(s, 64)
-		} else if s, u, ok = get_rec_value(r, "Capa"); ok {
+		} else if s, u, ok = hdrs.get_rec_value(r, "Capa"); ok {
 			b.Energy, _ = strconv.ParseFloat(s, 64)
 		}
 		if b.Energy > 0 {
@@ -532,18 +559,18 @@ func get_otx_line(r []string) types.LogItem {
 	b.Throttle = 100 * (b.Throttle + 1024) / 2048
 	b.Status = status
 
-	if s, _, ok := get_rec_value(r, "Ail"); ok {
+	if s, _, ok := hdrs.get_rec_value(r, "Ail"); ok {
 		i64, _ := strconv.Atoi(s)
 		b.Ail = normalise_stick(i64)
-		if s, _, ok = get_rec_value(r, "Ele"); ok {
+		if s, _, ok = hdrs.get_rec_value(r, "Ele"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Ele = normalise_stick(i64)
 		}
-		if s, _, ok = get_rec_value(r, "Rud"); ok {
+		if s, _, ok = hdrs.get_rec_value(r, "Rud"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Rud = normalise_stick(i64)
 		}
-		if s, _, ok = get_rec_value(r, "Thr"); ok {
+		if s, _, ok = hdrs.get_rec_value(r, "Thr"); ok {
 			i64, _ := strconv.Atoi(s)
 			b.Thr = normalise_stick(i64)
 		}
@@ -581,8 +608,9 @@ func acc_to_ah(ax, ay, az float64) (pitch int16, roll int16) {
 	return pitch, roll
 }
 
-func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegment, bool) {
+func (lg *OTXLOG) Reader(m types.FlightMeta, stream *types.LogStream) (types.LogSegment, error) {
 	var stats types.LogStats
+	ls := types.LogSegment{}
 
 	llat := 0.0
 	llon := 0.0
@@ -595,8 +623,7 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 
 	fh, err := os.Open(lg.name)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "log file %s\n", err)
-		os.Exit(-1)
+		return ls, err
 	}
 	defer fh.Close()
 
@@ -606,6 +633,7 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 	//split_sec := 30 // to be parameterised
 	//	var armtime time.Time
 	var lt, st time.Time
+	var hdrs otxhdrs
 
 	leffic := 0.0
 	lwhkm := 0.0
@@ -617,18 +645,18 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 			break
 		}
 		if i == 1 {
-			read_headers(record)
-			rec.Cap = dataCapability()
+			hdrs = read_headers(record)
+			rec.Cap = hdrs.dataCapability()
 			continue
 		}
 		if i >= m.Start && i <= m.End {
-			b := get_otx_line(record)
+			b := hdrs.get_otx_line(record)
 			if (b.Status&types.Is_ARMED) == 0 && b.Alt < 10 && b.Spd < 7 {
 				continue
 			}
 
 			tdiff := b.Utc.Sub(lt)
-			if tdiff.Nanoseconds()/(1000*1000) >= int64(options.Config.Intvl) {
+			if tdiff.Nanoseconds()/(1000*1000) >= int64(lg.cfg.Intvl) {
 				if st.IsZero() {
 					st = b.Utc
 					lt = st
@@ -639,14 +667,14 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 						homes.HomeLat = b.Lat
 						homes.HomeLon = b.Lon
 						homes.Flags = types.HOME_ARM
-						if options.Config.HomeAlt != -999999 {
-							homes.HomeAlt = float64(options.Config.HomeAlt)
+						if lg.cfg.HomeAlt != -999999 {
+							homes.HomeAlt = float64(lg.cfg.HomeAlt)
 							homes.Flags |= types.HOME_ALT
 						} else if b.GAlt > -999999 {
 							homes.HomeAlt = b.GAlt
 							homes.Flags |= types.HOME_ALT
 						} else {
-							if options.Config.UseTopo {
+							if lg.cfg.UseTopo {
 								d := geo.InitDem("")
 								elev, err := d.Get_Elevation(homes.HomeLat, homes.HomeLon)
 								if err == nil {
@@ -658,6 +686,7 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 
 						if fb != nil {
 							fb.Set_origin(homes.HomeLat, homes.HomeLon, b.GAlt)
+							ls.R = types.RebaseOrigin{Lat: homes.HomeLat, Lon: homes.HomeLon, Alt: b.GAlt, Valid: true}
 							homes.HomeLat, homes.HomeLon, homes.HomeAlt = fb.Relocate(homes.HomeLat, homes.HomeLon, homes.HomeAlt)
 							ttmp := time.Now().Add(time.Hour * 24 * 42)
 							froboff = ttmp.Sub(b.Utc)
@@ -665,8 +694,8 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 						}
 						llat = b.Lat
 						llon = b.Lon
-						if ch != nil {
-							ch <- homes
+						if stream != nil && !stream.Home(homes) {
+							return ls, stream.Err()
 						}
 					}
 				} else {
@@ -748,8 +777,10 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 					rec.Cap |= types.CAP_RSSI_VALID
 				}
 
-				if ch != nil {
-					ch <- b
+				if stream != nil {
+					if !stream.Item(b) {
+						return ls, stream.Err()
+					}
 				} else {
 					rec.Items = append(rec.Items, b)
 				}
@@ -759,22 +790,22 @@ func (lg *OTXLOG) Reader(m types.FlightMeta, ch chan interface{}) (types.LogSegm
 			}
 		}
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "reader %s\n", err)
-			os.Exit(-1)
+			return ls, fmt.Errorf("%s / %d: %w: %v", lg.name, m.Index, types.ErrCorruptSegment, err)
 		}
 	}
 	srec := stats.Summary(uint64(lt.Sub(st).Nanoseconds() / 1000))
-	ls := types.LogSegment{}
-	if ch != nil {
-		ch <- srec
-		return ls, true
-	} else {
-		ok := homes.Flags != 0 && len(rec.Items) > 0
-		if ok {
-			ls.L = rec
-			ls.H = homes
-			ls.M = srec
-		}
-		return ls, ok
-	}
+	if stream != nil {
+		ls.M = srec
+		ls.S = stats
+	} else if homes.Flags != 0 && len(rec.Items) > 0 {
+		ls.L = rec
+		ls.H = homes
+		ls.M = srec
+		ls.S = stats
+	}
+	// A fix on the last record sets the home, but leaves no items
+	if homes.Flags == 0 || (stream == nil && len(rec.Items) == 0) {
+		return ls, fmt.Errorf("%s / %d: %w", lg.name, m.Index, types.ErrNoGPSFix)
+	}
+	return ls, nil
 }
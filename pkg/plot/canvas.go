@@ -0,0 +1,138 @@
+package plot
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+)
+
+/*
+ * Minimal drawing surfaces; plots are drawn once to a canvas, which is
+ * either SVG (text) or PNG (raster, with the basic 7x13 font).
+ */
+
+const (
+	ANCHOR_START = iota
+	ANCHOR_MIDDLE
+	ANCHOR_END
+)
+
+type canvas interface {
+	line(x0, y0, x1, y1 float64, c color.RGBA)
+	rect(x0, y0, x1, y1 float64, c color.RGBA)
+	text(x, y float64, s string, anchor int, c color.RGBA)
+	dot(x, y, r float64, c color.RGBA)
+}
+
+func rgb(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+type svgcanvas struct {
+	sb strings.Builder
+}
+
+func new_svg(w, h int) *svgcanvas {
+	s := &svgcanvas{}
+	fmt.Fprintf(&s.sb, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n", w, h, w, h)
+	fmt.Fprintf(&s.sb, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", w, h)
+	return s
+}
+
+func (s *svgcanvas) line(x0, y0, x1, y1 float64, c color.RGBA) {
+	fmt.Fprintf(&s.sb, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"%s\"/>\n", x0, y0, x1, y1, rgb(c))
+}
+
+func (s *svgcanvas) rect(x0, y0, x1, y1 float64, c color.RGBA) {
+	fmt.Fprintf(&s.sb, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"%s\"/>\n",
+		math.Min(x0, x1), math.Min(y0, y1), math.Abs(x1-x0), math.Abs(y1-y0), rgb(c))
+}
+
+func (s *svgcanvas) text(x, y float64, str string, anchor int, c color.RGBA) {
+	anchors := [...]string{"start", "middle", "end"}
+	str = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(str)
+	fmt.Fprintf(&s.sb, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"%s\" dominant-baseline=\"middle\" fill=\"%s\">%s</text>\n",
+		x, y, anchors[anchor], rgb(c), str)
+}
+
+func (s *svgcanvas) dot(x, y, r float64, c color.RGBA) {
+	fmt.Fprintf(&s.sb, "<circle cx=\"%.1f\" cy=\"%.1f\" r=\"%.1f\" fill=\"%s\"/>\n", x, y, r, rgb(c))
+}
+
+// polyline is an SVG specific optimisation for long series
+func (s *svgcanvas) polyline(xs, ys []float64, c color.RGBA) {
+	s.sb.WriteString("<polyline fill=\"none\" stroke=\"" + rgb(c) + "\" points=\"")
+	for j := range xs {
+		fmt.Fprintf(&s.sb, "%.1f,%.1f ", xs[j], ys[j])
+	}
+	s.sb.WriteString("\"/>\n")
+}
+
+func (s *svgcanvas) bytes() []byte {
+	s.sb.WriteString("</svg>\n")
+	return []byte(s.sb.String())
+}
+
+type pngcanvas struct {
+	img *image.RGBA
+}
+
+func new_png(w, h int) *pngcanvas {
+	p := &pngcanvas{img: image.NewRGBA(image.Rect(0, 0, w, h))}
+	draw.Draw(p.img, p.img.Bounds(), image.White, image.Point{}, draw.Src)
+	return p
+}
+
+func (p *pngcanvas) line(x0, y0, x1, y1 float64, c color.RGBA) {
+	n := int(math.Max(math.Abs(x1-x0), math.Abs(y1-y0)))*2 + 1
+	for j := 0; j <= n; j++ {
+		t := float64(j) / float64(n)
+		p.img.SetRGBA(int(math.Round(x0+t*(x1-x0))), int(math.Round(y0+t*(y1-y0))), c)
+	}
+}
+
+func (p *pngcanvas) rect(x0, y0, x1, y1 float64, c color.RGBA) {
+	r := image.Rect(int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x1)), int(math.Round(y1))).Canon()
+	draw.Draw(p.img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func (p *pngcanvas) dot(x, y, r float64, c color.RGBA) {
+	for dy := -r; dy <= r; dy++ {
+		for dx := -r; dx <= r; dx++ {
+			if dx*dx+dy*dy <= r*r {
+				p.img.SetRGBA(int(math.Round(x+dx)), int(math.Round(y+dy)), c)
+			}
+		}
+	}
+}
+
+func (p *pngcanvas) text(x, y float64, s string, anchor int, c color.RGBA) {
+	face := basicfont.Face7x13
+	d := &font.Drawer{Dst: p.img, Face: face, Src: image.NewUniform(c)}
+	w := d.MeasureString(s).Ceil()
+	switch anchor {
+	case ANCHOR_MIDDLE:
+		x -= float64(w) / 2
+	case ANCHOR_END:
+		x -= float64(w)
+	}
+	m := face.Metrics()
+	d.Dot = fixed.P(int(x), int(y)+(m.Ascent.Ceil()-m.Descent.Ceil())/2)
+	d.DrawString(s)
+}
+
+func (p *pngcanvas) bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, p.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
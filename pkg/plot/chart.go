@@ -0,0 +1,161 @@
+package plot
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+)
+
+/*
+ * XY line charts, rendered as SVG or PNG.
+ */
+
+const (
+	CHART_W      = 800
+	CHART_H      = 400
+	MARGIN_LEFT  = 64
+	MARGIN_RIGHT = 16
+	MARGIN_TOP   = 32
+	MARGIN_BOT   = 48
+	TICK_LEN     = 4
+	NTICKS       = 6
+)
+
+var (
+	Black = color.RGBA{A: 0xff}
+	Grey  = color.RGBA{R: 0xd8, G: 0xd8, B: 0xd8, A: 0xff}
+	// Series colours, in order
+	Palette = []color.RGBA{
+		{R: 0xd6, G: 0x27, B: 0x28, A: 0xff},
+		{R: 0x2c, G: 0xa0, B: 0x2c, A: 0xff},
+		{R: 0x1f, G: 0x77, B: 0xb4, A: 0xff},
+		{R: 0xff, G: 0x7f, B: 0x0e, A: 0xff},
+		{R: 0x94, G: 0x67, B: 0xbd, A: 0xff},
+	}
+)
+
+type Series struct {
+	Name string
+	X, Y []float64
+}
+
+// Axis ranges are from the data unless Max > Min
+type Chart struct {
+	Title          string
+	XLabel, YLabel string
+	XMin, XMax     float64
+	YMin, YMax     float64
+	Series         []Series
+}
+
+// "Nice" tick interval, for about n ticks over the range
+func tick_step(lo, hi float64, n int) float64 {
+	raw := (hi - lo) / float64(n)
+	if raw <= 0 {
+		return 1
+	}
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, f := range []float64{1, 2, 5, 10} {
+		if f*mag >= raw {
+			return f * mag
+		}
+	}
+	return 10 * mag
+}
+
+func tick_label(v float64) string {
+	return strconv.FormatFloat(v, 'g', 4, 64)
+}
+
+func (c *Chart) ranges() (float64, float64, float64, float64) {
+	xmin, xmax, ymin, ymax := c.XMin, c.XMax, c.YMin, c.YMax
+	if xmax <= xmin {
+		xmin, xmax = math.Inf(1), math.Inf(-1)
+		for _, s := range c.Series {
+			for _, x := range s.X {
+				xmin, xmax = math.Min(xmin, x), math.Max(xmax, x)
+			}
+		}
+	}
+	if ymax <= ymin {
+		ymin, ymax = 0, math.Inf(-1)
+		for _, s := range c.Series {
+			for _, y := range s.Y {
+				ymin, ymax = math.Min(ymin, y), math.Max(ymax, y)
+			}
+		}
+		step := tick_step(ymin, ymax, NTICKS)
+		ymin = step * math.Floor(ymin/step)
+		ymax = step * math.Ceil(ymax/step)
+	}
+	if !(xmax > xmin) {
+		xmin, xmax = 0, 1
+	}
+	if !(ymax > ymin) {
+		ymax = ymin + 1
+	}
+	return xmin, xmax, ymin, ymax
+}
+
+func (c *Chart) draw(cv canvas) {
+	xmin, xmax, ymin, ymax := c.ranges()
+	x0, x1 := float64(MARGIN_LEFT), float64(CHART_W-MARGIN_RIGHT)
+	y0, y1 := float64(CHART_H-MARGIN_BOT), float64(MARGIN_TOP)
+	px := func(x float64) float64 { return x0 + (x-xmin)/(xmax-xmin)*(x1-x0) }
+	py := func(y float64) float64 { return y0 + (y-ymin)/(ymax-ymin)*(y1-y0) }
+
+	// grid and ticks
+	step := tick_step(xmin, xmax, NTICKS)
+	for v := step * math.Ceil(xmin/step); v <= xmax+step/1e6; v += step {
+		cv.line(px(v), y0, px(v), y1, Grey)
+		cv.line(px(v), y0, px(v), y0+TICK_LEN, Black)
+		cv.text(px(v), y0+TICK_LEN+8, tick_label(v), ANCHOR_MIDDLE, Black)
+	}
+	step = tick_step(ymin, ymax, NTICKS)
+	for v := step * math.Ceil(ymin/step); v <= ymax+step/1e6; v += step {
+		cv.line(x0, py(v), x1, py(v), Grey)
+		cv.line(x0-TICK_LEN, py(v), x0, py(v), Black)
+		cv.text(x0-TICK_LEN-2, py(v), tick_label(v), ANCHOR_END, Black)
+	}
+	cv.line(x0, y0, x1, y0, Black)
+	cv.line(x0, y0, x0, y1, Black)
+
+	cv.text(CHART_W/2, MARGIN_TOP/2, c.Title, ANCHOR_MIDDLE, Black)
+	cv.text((x0+x1)/2, CHART_H-10, c.XLabel, ANCHOR_MIDDLE, Black)
+	cv.text(4, MARGIN_TOP/2, c.YLabel, ANCHOR_START, Black)
+
+	for j, s := range c.Series {
+		col := Palette[j%len(Palette)]
+		xs := make([]float64, 0, len(s.X))
+		ys := make([]float64, 0, len(s.X))
+		for k := range s.X {
+			if k < len(s.Y) && s.X[k] >= xmin && s.X[k] <= xmax {
+				xs = append(xs, px(s.X[k]))
+				ys = append(ys, py(math.Max(ymin, math.Min(ymax, s.Y[k]))))
+			}
+		}
+		if sv, ok := cv.(*svgcanvas); ok {
+			sv.polyline(xs, ys, col)
+		} else {
+			for k := 1; k < len(xs); k++ {
+				cv.line(xs[k-1], ys[k-1], xs[k], ys[k], col)
+			}
+		}
+		// legend, top right
+		ly := y1 + 10 + float64(j)*16
+		cv.line(x1-110, ly, x1-90, ly, col)
+		cv.text(x1-84, ly, s.Name, ANCHOR_START, Black)
+	}
+}
+
+func (c *Chart) SVG() []byte {
+	cv := new_svg(CHART_W, CHART_H)
+	c.draw(cv)
+	return cv.bytes()
+}
+
+func (c *Chart) PNG() ([]byte, error) {
+	cv := new_png(CHART_W, CHART_H)
+	c.draw(cv)
+	return cv.bytes()
+}
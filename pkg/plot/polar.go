@@ -0,0 +1,124 @@
+package plot
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+)
+
+/*
+ * Polar (bearing / range) scatter plots, the points coloured by value
+ * (red, low, to green, high), with an optional outline (e.g. the maximum
+ * range in each of a number of equal sectors, the first centred on
+ * north).
+ */
+
+const (
+	POLAR_SIZE   = 600
+	POLAR_MARGIN = 48
+	POLAR_RINGS  = 4
+	POLAR_KEY    = 12 // colour key steps
+)
+
+type Polar struct {
+	Title      string
+	Units      string // of the value
+	Bearing    []float64
+	Range      []float64
+	Value      []float64
+	VMin, VMax float64
+	Outline    []float64
+}
+
+// Red (0) - yellow - green (1)
+func Heat(q float64) color.RGBA {
+	q = math.Max(0, math.Min(1, q))
+	if q < 0.5 {
+		return color.RGBA{R: 0xd7, G: uint8(0x30 + q*2*(0xd0-0x30)), B: 0x27, A: 0xff}
+	}
+	return color.RGBA{R: uint8(0xd7 - (q-0.5)*2*(0xd7-0x1a)), G: uint8(0xd0 - (q-0.5)*2*(0xd0-0x98)), B: 0x27, A: 0xff}
+}
+
+func (p *Polar) draw(cv canvas) {
+	cx, cy := float64(POLAR_SIZE/2), float64(POLAR_SIZE/2+POLAR_MARGIN/4)
+	rpx := float64(POLAR_SIZE/2 - POLAR_MARGIN)
+	rmax := 0.0
+	for _, r := range p.Range {
+		rmax = math.Max(rmax, r)
+	}
+	for _, r := range p.Outline {
+		rmax = math.Max(rmax, r)
+	}
+	step := tick_step(0, rmax, POLAR_RINGS)
+	rmax = step * math.Ceil(rmax/step)
+	if rmax <= 0 {
+		rmax = 1
+	}
+	pt := func(brg, r float64) (float64, float64) {
+		s, c := math.Sincos(brg * math.Pi / 180)
+		return cx + s*r/rmax*rpx, cy - c*r/rmax*rpx
+	}
+
+	// rings and spokes
+	for r := step; r <= rmax+step/1e6; r += step {
+		for a := 0.0; a < 360; a += 5 {
+			x0, y0 := pt(a, r)
+			x1, y1 := pt(a+5, r)
+			cv.line(x0, y0, x1, y1, Grey)
+		}
+		x, y := pt(45, r)
+		cv.text(x+2, y, tick_label(r)+" m", ANCHOR_START, Black)
+	}
+	for a, n := range []string{"N", "E", "S", "W"} {
+		x, y := pt(float64(a*90), rmax)
+		cv.line(cx, cy, x, y, Grey)
+		x, y = pt(float64(a*90), rmax*1.08)
+		cv.text(x, y, n, ANCHOR_MIDDLE, Black)
+	}
+	cv.text(POLAR_SIZE/2, POLAR_MARGIN/4, p.Title, ANCHOR_MIDDLE, Black)
+
+	span := p.VMax - p.VMin
+	if span <= 0 {
+		span = 1
+	}
+	for j := range p.Range {
+		if j < len(p.Bearing) && j < len(p.Value) {
+			x, y := pt(p.Bearing[j], p.Range[j])
+			cv.dot(x, y, 2, Heat((p.Value[j]-p.VMin)/span))
+		}
+	}
+
+	if n := len(p.Outline); n > 0 {
+		sw := 360.0 / float64(n)
+		for j, r := range p.Outline {
+			// arc across the sector, and radials to the neighbours
+			a0 := float64(j)*sw - sw/2
+			x0, y0 := pt(a0, r)
+			x1, y1 := pt(a0+sw, r)
+			cv.line(x0, y0, x1, y1, Black)
+			x2, y2 := pt(a0+sw, p.Outline[(j+1)%n])
+			cv.line(x1, y1, x2, y2, Black)
+		}
+	}
+
+	// colour key, bottom left
+	for k := 0; k <= POLAR_KEY; k++ {
+		q := float64(k) / POLAR_KEY
+		x := 8 + float64(k)*10
+		cv.rect(x, POLAR_SIZE-20, x+10, POLAR_SIZE-10, Heat(q))
+	}
+	cv.text(8, POLAR_SIZE-30, fmt.Sprintf("%s %s", tick_label(p.VMin), p.Units), ANCHOR_START, Black)
+	cv.text(18+POLAR_KEY*10, POLAR_SIZE-30, fmt.Sprintf("%s %s", tick_label(p.VMax), p.Units), ANCHOR_END, Black)
+}
+
+func (p *Polar) SVG() []byte {
+	cv := new_svg(POLAR_SIZE, POLAR_SIZE)
+	p.draw(cv)
+	return cv.bytes()
+}
+
+func (p *Polar) PNG() ([]byte, error) {
+	cv := new_png(POLAR_SIZE, POLAR_SIZE)
+	p.draw(cv)
+	return cv.bytes()
+}
@@ -1,6 +1,7 @@
 package sitlgen
 
 import (
+	"context"
 	"log"
 	"time"
 )
@@ -24,9 +25,14 @@ func from_bbl(b types.LogItem, acc1g float32) SimData {
 	sd.Gyro_x = float32(b.Gyro_x)
 	sd.Gyro_y = float32(b.Gyro_y)
 	sd.Gyro_z = float32(b.Gyro_z)
-	sd.Acc_x = float32(b.Acc_x) / acc1g
-	sd.Acc_y = float32(b.Acc_y) / acc1g
-	sd.Acc_z = float32(b.Acc_z) / acc1g
+	if acc1g > 0 {
+		sd.Acc_x = float32(b.Acc_x) / acc1g
+		sd.Acc_y = float32(b.Acc_y) / acc1g
+		sd.Acc_z = float32(b.Acc_z) / acc1g
+	} else {
+		// No IMU data (non-BBL log), assume level
+		sd.Acc_z = 1.0
+	}
 	sd.RC_a = uint16(b.Ail)
 	sd.RC_e = uint16(b.Ele)
 	sd.RC_r = uint16(b.Rud)
@@ -37,22 +43,37 @@ func from_bbl(b types.LogItem, acc1g float32) SimData {
 	return sd
 }
 
-func file_reader(rch chan interface{}, sdch chan SimData, cmdch chan byte, acc1g float32) {
+func file_reader(ctx context.Context, rch <-chan types.LogEvent, sdch chan SimData, cmdch chan byte, acc1g float32) {
 	lt := uint64(0)
 	var sd SimData
 	done := false
 	if options.Config.Verbose > 1 {
 		log.Printf("Logreader with Acc1G = %.1f\n", acc1g)
 	}
+	send := func(sd SimData) bool {
+		select {
+		case sdch <- sd:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
 	for !done {
 		select {
-		case v := <-rch:
-			switch v.(type) {
-			case types.LogItem:
-				b := v.(types.LogItem)
+		case v, ok := <-rch:
+			if !ok {
+				done = true
+				break
+			}
+			switch v.Type {
+			case types.EV_ITEM:
+				b := v.Item
 				ts := b.Stamp
 				sd = from_bbl(b, acc1g)
-				sdch <- sd
+				if !send(sd) {
+					return
+				}
 				if lt != 0 {
 					tdiff := time.Duration(b.Stamp-lt) * time.Microsecond
 					if options.Config.Verbose > 5 {
@@ -63,25 +84,32 @@ func file_reader(rch chan interface{}, sdch chan SimData, cmdch chan byte, acc1g
 					if options.Config.Verbose > 11 {
 						log.Println("Reader waits on cmd")
 					}
-					<-cmdch
+					select {
+					case <-cmdch:
+					case <-ctx.Done():
+						return
+					}
 					if options.Config.Verbose > 11 {
 						log.Println("Reader continues on cmd")
 					}
-					sdch <- sd
+					if !send(sd) {
+						return
+					}
 				}
 				lt = ts
-			case types.HomeRec:
-				done = false
-			case types.MapRec:
+			case types.EV_ERROR:
+				log.Printf("Reader: %v\n", v.Err)
+				done = true
+			case types.EV_END:
 				done = true
 			}
-		case <-time.After(1 * time.Millisecond):
-			//
+		case <-ctx.Done():
+			return
 		}
 	}
 	if options.Config.Verbose > 1 {
 		log.Printf("Reader EOF\n")
 	}
 	sd.Fmode = types.FM_UNK
-	sdch <- sd
+	send(sd)
 }
@@ -2,6 +2,7 @@ package sitlgen
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/mattn/go-tty"
@@ -370,9 +371,13 @@ func (x *SitlGen) Faker() {
 	}
 }
 
-func (x *SitlGen) Run(rdrchan chan interface{}, meta types.FlightMeta) {
+func (x *SitlGen) Run(ctx context.Context, rdrchan <-chan types.LogEvent, meta types.FlightMeta) {
 	var txhost string
 
+	// Ensure the log reader and file_reader goroutines exit with us
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	log.SetPrefix("[fl2sitm] ")
 	log.SetFlags(log.Ltime | log.Lmicroseconds)
 	conf := read_cfg(options.Config.SitlConfig)
@@ -490,7 +495,7 @@ func (x *SitlGen) Run(rdrchan chan interface{}, meta types.FlightMeta) {
 				serial_ok = 1
 			}
 			Sitl_logger(1, "Start BBL reader\n")
-			go file_reader(rdrchan, bbchan, bbcmd, float32(meta.Acc1G))
+			go file_reader(ctx, rdrchan, bbchan, bbcmd, float32(meta.Acc1G))
 			sim = <-bbchan
 			sim.Acc_x = 0.0
 			sim.Acc_y = 0.0
@@ -0,0 +1,37 @@
+package trackgen
+
+import (
+	"encoding/csv"
+	"os"
+)
+
+import (
+	"types"
+)
+
+// Writes the LogItems as CSV, one row per item, with a header line of
+// the field names.
+func GenerateCSV(outfn string, ls types.LogSegment, meta types.FlightMeta, gv func() string) error {
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(fh)
+	row := make([]string, len(fields))
+	for j, f := range fields {
+		row[j] = f.Name
+	}
+	w.Write(row)
+	for k := range ls.L.Items {
+		for j, f := range fields {
+			row[j] = field_string(field_value(f, &ls.L.Items[k]))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	err = w.Error()
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
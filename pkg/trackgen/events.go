@@ -0,0 +1,33 @@
+package trackgen
+
+import (
+	"encoding/json"
+	"os"
+)
+
+import (
+	"types"
+)
+
+type evdoc struct {
+	Log       string              `json:"log"`
+	Flight    string              `json:"flight"`
+	Generator string              `json:"generator"`
+	Events    []types.FlightEvent `json:"events"`
+}
+
+// Writes the flight events as JSON
+func GenerateEvents(outfn string, evs []types.FlightEvent, meta types.FlightMeta, gv func() string) error {
+	if evs == nil {
+		evs = []types.FlightEvent{}
+	}
+	doc := evdoc{Log: meta.LogName(), Flight: meta.Flight(), Generator: gv(), Events: evs}
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	enc := json.NewEncoder(fh)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
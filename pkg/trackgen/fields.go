@@ -0,0 +1,67 @@
+package trackgen
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+import (
+	"types"
+)
+
+// The exported LogItem columns, as types.ItemFields
+var fields = types.ItemFields
+
+// Non-finite values are nil (JSON null, empty CSV cell). Flags are
+// booleans, 0 / 1 in CSV.
+func field_value(f types.ItemField, b *types.LogItem) interface{} {
+	switch v := f.Value(b).(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return v
+	case time.Time:
+		return v.UTC().Format(GPX_TIME)
+	default:
+		return v
+	}
+}
+
+func field_string(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case string:
+		return t
+	}
+	return strconv.FormatInt(int_value(v), 10)
+}
+
+func int_value(v interface{}) int64 {
+	switch t := v.(type) {
+	case int64:
+		return t
+	case uint64:
+		return int64(t)
+	case uint32:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case uint8:
+		return int64(t)
+	case int:
+		return int64(t)
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package trackgen
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+)
+
+import (
+	"types"
+)
+
+type gjgeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type gjfeature struct {
+	Type       string                 `json:"type"`
+	Geometry   gjgeometry             `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type gjcollection struct {
+	Type     string      `json:"type"`
+	Features []gjfeature `json:"features"`
+}
+
+// GeoJSON requires [lon, lat, alt]; rounded to ~1cm to keep the size sane
+func gj_coord(h types.HomeRec, b types.LogItem) []float64 {
+	return []float64{math.Round(b.Lon*1e7) / 1e7, math.Round(b.Lat*1e7) / 1e7,
+		math.Round(amsl(h, b)*100) / 100}
+}
+
+// Writes a GeoJSON FeatureCollection. The first Feature is the flight as
+// a LineString (properties are the log metadata and summary), followed
+// by a Point Feature for each LogItem, with the item fields as properties.
+func GenerateGeoJSON(outfn string, ls types.LogSegment, meta types.FlightMeta, gv func() string) error {
+	items := ls.L.Items
+	fc := gjcollection{Type: "FeatureCollection"}
+	fc.Features = make([]gjfeature, 0, len(items)+1)
+
+	line := make([][]float64, len(items))
+	for j, b := range items {
+		line[j] = gj_coord(ls.H, b)
+	}
+	props := map[string]interface{}{
+		"name":      meta.LogName(),
+		"generator": gv(),
+	}
+	for k, v := range meta.Summary() {
+		props[k] = v
+	}
+	for k, v := range ls.M {
+		props[k] = v
+	}
+	fc.Features = append(fc.Features, gjfeature{Type: "Feature",
+		Geometry: gjgeometry{Type: "LineString", Coordinates: line}, Properties: props})
+
+	for j := range items {
+		props := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			props[f.Name] = field_value(f, &items[j])
+		}
+		fc.Features = append(fc.Features, gjfeature{Type: "Feature",
+			Geometry: gjgeometry{Type: "Point", Coordinates: line[j]}, Properties: props})
+	}
+
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(fh).Encode(fc)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
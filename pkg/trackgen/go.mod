@@ -0,0 +1,3 @@
+module trackgen
+
+go 1.19
@@ -0,0 +1,131 @@
+package trackgen
+
+import (
+	"encoding/xml"
+	"os"
+	"time"
+)
+
+import (
+	"types"
+)
+
+const (
+	GPX_NS    = "http://www.topografix.com/GPX/1/1"
+	GPXTPX_NS = "http://www.garmin.com/xmlschemas/TrackPointExtension/v2"
+	GPX_XSI   = "http://www.w3.org/2001/XMLSchema-instance"
+	GPX_TIME  = "2006-01-02T15:04:05.000Z07:00"
+	GPX_LOC   = "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd " +
+		"http://www.garmin.com/xmlschemas/TrackPointExtension/v2 http://www8.garmin.com/xmlschemas/TrackPointExtensionv2.xsd"
+)
+
+// Element order is as required by the GPX 1.1 schema
+type gpxpt struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  float64  `xml:"ele"`
+	Time string   `xml:"time"`
+	Name string   `xml:"name,omitempty"`
+	Desc string   `xml:"desc,omitempty"`
+	Type string   `xml:"type,omitempty"`
+	Fix  string   `xml:"fix,omitempty"`
+	Sat  uint8    `xml:"sat,omitempty"`
+	Hdop float64  `xml:"hdop,omitempty"`
+	Ext  *gpxtpxt `xml:"extensions,omitempty"`
+}
+
+type gpxtpxt struct {
+	Speed  float64 `xml:"gpxtpx:TrackPointExtension>gpxtpx:speed"`
+	Course uint32  `xml:"gpxtpx:TrackPointExtension>gpxtpx:course"`
+}
+
+type gpxtrk struct {
+	Name string  `xml:"name"`
+	Desc string  `xml:"desc,omitempty"`
+	Pts  []gpxpt `xml:"trkseg>trkpt"`
+}
+
+type gpxmeta struct {
+	Name string `xml:"name"`
+	Desc string `xml:"desc,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+type gpxfile struct {
+	XMLName  xml.Name `xml:"gpx"`
+	Version  string   `xml:"version,attr"`
+	Creator  string   `xml:"creator,attr"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Tpx      string   `xml:"xmlns:gpxtpx,attr"`
+	Xsi      string   `xml:"xmlns:xsi,attr"`
+	Loc      string   `xml:"xsi:schemaLocation,attr"`
+	Metadata gpxmeta  `xml:"metadata"`
+	Wpts     []gpxpt  `xml:"wpt"`
+	Trk      gpxtrk   `xml:"trk"`
+}
+
+func gpx_fix(b types.LogItem) string {
+	switch b.Fix {
+	case 1:
+		return "2d"
+	case 2:
+		return "3d"
+	}
+	return ""
+}
+
+func gpx_point(h types.HomeRec, b types.LogItem) gpxpt {
+	return gpxpt{Lat: b.Lat, Lon: b.Lon, Ele: amsl(h, b),
+		Time: b.Utc.UTC().Format(GPX_TIME),
+		Fix:  gpx_fix(b), Sat: b.Numsat, Hdop: float64(b.Hdop) / 100.0}
+}
+
+// Writes a GPX 1.1 file with a single track, plus a waypoint for each
+// flight mode change. Speed (m/s) and course are written as Garmin
+// TrackPointExtension (v2) elements.
+func GenerateGPX(outfn string, ls types.LogSegment, meta types.FlightMeta, gv func() string) error {
+	items := ls.L.Items
+	g := gpxfile{Version: "1.1", Creator: gv(), Xmlns: GPX_NS, Tpx: GPXTPX_NS, Xsi: GPX_XSI, Loc: GPX_LOC}
+	g.Metadata.Name = meta.LogName()
+	if meta.Flags&types.Has_Craft != 0 {
+		g.Metadata.Desc = meta.Craft
+	}
+	if len(items) > 0 {
+		g.Metadata.Time = items[0].Utc.UTC().Format(time.RFC3339)
+	}
+	g.Trk.Name = meta.LogName()
+	if meta.Flags&types.Has_Firmware != 0 {
+		g.Trk.Desc = meta.Firmware
+	}
+
+	for _, j := range mode_changes(items) {
+		p := gpx_point(ls.H, items[j])
+		p.Name = mode_text(items[j])
+		p.Type = "Mode"
+		g.Wpts = append(g.Wpts, p)
+	}
+
+	g.Trk.Pts = make([]gpxpt, 0, len(items))
+	for _, b := range items {
+		p := gpx_point(ls.H, b)
+		p.Ext = &gpxtpxt{Speed: b.Spd, Course: b.Cog}
+		g.Trk.Pts = append(g.Trk.Pts, p)
+	}
+
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	_, err = fh.WriteString(xml.Header)
+	if err == nil {
+		enc := xml.NewEncoder(fh)
+		enc.Indent("", " ")
+		if err = enc.Encode(g); err == nil {
+			_, err = fh.WriteString("\n")
+		}
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package trackgen
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+import (
+	"types"
+)
+
+/*
+ * IGC (FAI) flight recorder format. The file is not signed (no G record),
+ * which is fine for analysis tools but not for competition / badge claims.
+ * B records carry ground speed (km/h) as an extension (GSP). Mode changes
+ * are written as E records, with the unofficial "XMD" code.
+ */
+
+func igc_lat(lat float64) string {
+	h := 'N'
+	if lat < 0 {
+		h = 'S'
+		lat = -lat
+	}
+	d := int(lat)
+	m := int(math.Round((lat - float64(d)) * 60000))
+	if m == 60000 {
+		d++
+		m = 0
+	}
+	return fmt.Sprintf("%02d%05d%c", d, m, h)
+}
+
+func igc_lon(lon float64) string {
+	h := 'E'
+	if lon < 0 {
+		h = 'W'
+		lon = -lon
+	}
+	d := int(lon)
+	m := int(math.Round((lon - float64(d)) * 60000))
+	if m == 60000 {
+		d++
+		m = 0
+	}
+	return fmt.Sprintf("%03d%05d%c", d, m, h)
+}
+
+// 5 characters, negative values have a leading '-'
+func igc_alt(alt float64) string {
+	a := int(math.Round(alt))
+	if a < 0 {
+		return fmt.Sprintf("-%04d", -a)
+	}
+	return fmt.Sprintf("%05d", a)
+}
+
+func igc_clean(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Writes an (unsigned) IGC file. The pressure altitude field is the baro
+// altitude (referenced to home if known), the GNSS field is GPS altitude.
+func GenerateIGC(outfn string, ls types.LogSegment, meta types.FlightMeta, gv func() string) error {
+	items := ls.L.Items
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(fh)
+	wr := func(f string, args ...interface{}) {
+		fmt.Fprintf(w, f, args...)
+		w.WriteString("\r\n")
+	}
+
+	wr("AXXXFL2X")
+	if len(items) > 0 {
+		t := items[0].Utc.UTC()
+		wr("HFDTEDATE:%02d%02d%02d,%02d", t.Day(), int(t.Month()), t.Year()%100, meta.Index)
+	}
+	wr("HFPLTPILOTINCHARGE:")
+	wr("HFGTYGLIDERTYPE:%s", igc_clean(meta.Craft))
+	wr("HFGIDGLIDERID:")
+	wr("HFDTMGPSDATUM:WGS84")
+	wr("HFRFWFIRMWAREVERSION:%s", igc_clean(meta.Firmware))
+	wr("HFFTYFRTYPE:%s", igc_clean(gv()))
+	wr("HFALGALTGPS:GEO")
+	wr("HFALPALTPRESSURE:ISA")
+	wr("I013638GSP")
+	wr("LXXXLOG %s", igc_clean(meta.LogName()))
+
+	mc := mode_changes(items)
+	k := 0
+	for j, b := range items {
+		ts := b.Utc.UTC().Format("150405")
+		for k < len(mc) && mc[k] == j {
+			wr("E%sXMD%s", ts, igc_clean(mode_text(b)))
+			k++
+		}
+		v := 'V'
+		if b.Fix == 2 {
+			v = 'A'
+		}
+		gsp := int(math.Round(b.Spd * 3.6))
+		if gsp > 999 {
+			gsp = 999
+		}
+		wr("B%s%s%s%c%s%s%03d", ts, igc_lat(b.Lat), igc_lon(b.Lon), v,
+			igc_alt(amsl(ls.H, b)), igc_alt(b.GAlt), gsp)
+	}
+	err = w.Flush()
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
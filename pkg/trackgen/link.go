@@ -0,0 +1,88 @@
+package trackgen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+import (
+	"analysis"
+	"plot"
+	"types"
+)
+
+// Bins are labelled with the range, or the centre (for bearings)
+func write_link_bins(w io.Writer, title string, bins []analysis.LinkBin, haslq bool, centre bool) {
+	fmt.Fprintf(w, "\n%-16s %6s %8s %8s", title, "Items", "RSSI", "RSSImin")
+	if haslq {
+		fmt.Fprintf(w, " %8s %8s", "LQ", "LQmin")
+	}
+	fmt.Fprintf(w, " %9s\n", "MaxRange")
+	for _, b := range bins {
+		label := fmt.Sprintf("%.0f-%.0f", b.Lo, b.Hi)
+		if centre {
+			label = fmt.Sprintf("%05.1f", (b.Lo+b.Hi)/2)
+		}
+		fmt.Fprintf(w, "%-16s %6d", label, b.N)
+		if b.N > 0 {
+			fmt.Fprintf(w, " %8.0f %8.0f", b.Rssi, b.RssiMin)
+			if haslq {
+				fmt.Fprintf(w, " %8.0f %8.0f", b.LQ, b.LQMin)
+			}
+			fmt.Fprintf(w, " %9.0f", b.MaxRange)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func write_link_report(w io.Writer, lr analysis.LinkReport, meta types.FlightMeta, gv func() string) {
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Log", meta.LogName())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Flight", meta.Flight())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Generator", gv())
+	fmt.Fprintf(w, "%-9.9s : %.0f m\n", "Range", lr.MaxRange)
+	if lr.B != 0 {
+		fmt.Fprintf(w, "%-9.9s : RSSI = %.1f %+.1f log10(range) (r² %.2f)\n", "Fit", lr.A, lr.B, lr.R2)
+	}
+	if lr.PredRange > 0 {
+		fmt.Fprintf(w, "%-9.9s : %.0f m (RSSI %.0f%%)\n", "Predicted", lr.PredRange, analysis.LINK_RSSI_LIMIT)
+	}
+	write_link_bins(w, "Range (m)", lr.ByRange, lr.HasLQ, false)
+	write_link_bins(w, "Bearing (°)", lr.ByBearing, lr.HasLQ, true)
+	write_link_bins(w, "Altitude (m)", lr.ByAlt, lr.HasLQ, false)
+}
+
+// Writes the link report (outfn) and the polar coverage plot, as SVG and
+// PNG, named as the report with the extension replaced by .svg / .png.
+func GenerateLink(outfn string, lr analysis.LinkReport, rec types.LogRec, meta types.FlightMeta, gv func() string) error {
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	write_link_report(fh, lr, meta, gv)
+	if err = fh.Close(); err != nil {
+		return err
+	}
+
+	p := &plot.Polar{Title: meta.LogName() + " RSSI coverage", Units: "%", VMin: 0, VMax: 100}
+	for _, b := range rec.Items {
+		if b.Fix > 1 && b.Bearing >= 0 {
+			p.Bearing = append(p.Bearing, float64(b.Bearing))
+			p.Range = append(p.Range, b.Vrange)
+			p.Value = append(p.Value, float64(b.Rssi))
+		}
+	}
+	for _, b := range lr.ByBearing {
+		p.Outline = append(p.Outline, b.MaxRange)
+	}
+	base := strings.TrimSuffix(outfn, ".txt")
+	if err = os.WriteFile(base+".svg", p.SVG(), 0644); err != nil {
+		return err
+	}
+	data, err := p.PNG()
+	if err == nil {
+		err = os.WriteFile(base+".png", data, 0644)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package trackgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+import (
+	"analysis"
+	"types"
+)
+
+func mmss(t float64) string {
+	secs := int(math.Round(t))
+	return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+}
+
+func write_mission_report(w io.Writer, mr analysis.MissionReport, meta types.FlightMeta, gv func() string) {
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Log", meta.LogName())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Flight", meta.Flight())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Generator", gv())
+	fmt.Fprintf(w, "%-9.9s : %d (%d reached)\n", "Legs", len(mr.Legs), mr.Reached)
+	if len(mr.Missed) > 0 {
+		var sl []string
+		for _, n := range mr.Missed {
+			sl = append(sl, fmt.Sprintf("%d", n))
+		}
+		fmt.Fprintf(w, "%-9.9s : WP %s\n", "Missed", strings.Join(sl, ", "))
+	}
+	fmt.Fprintf(w, "%-9.9s : mean %.1f m, max %.1f m\n", "XTE", mr.MeanXTE, mr.MaxXTE)
+	fmt.Fprintf(w, "%-9.9s : mean %.1f m, max %.1f m\n", "Alt error", mr.MeanAlt, mr.MaxAlt)
+
+	fmt.Fprintf(w, "\n%4s %-13s %5s %5s %7s %7s %7s %6s %6s %6s %6s %6s  %s\n",
+		"WP", "Action", "Start", "Time", "Length", "Flown", "Closest",
+		"XTE", "XTEmax", "Alt", "Altmax", "Over", "Status")
+	for _, l := range mr.Legs {
+		alt, altmax := "-", "-"
+		if l.HasAlt {
+			alt, altmax = fmt.Sprintf("%.1f", l.MeanAlt), fmt.Sprintf("%.1f", l.MaxAlt)
+		}
+		status := "reached"
+		if !l.Reached {
+			status = "not reached"
+		}
+		fmt.Fprintf(w, "%4d %-13s %5s %5.0f %7.0f %7.0f %7.0f %6.1f %6.1f %6s %6s %6.1f  %s\n",
+			l.No, l.Action, mmss(l.Start), l.End-l.Start, l.Length, l.Dist, l.Closest,
+			l.MeanXTE, l.MaxXTE, alt, altmax, l.Overshoot, status)
+	}
+}
+
+// Writes the mission adherence report (outfn)
+func GenerateMission(outfn string, mr analysis.MissionReport, meta types.FlightMeta, gv func() string) error {
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	write_mission_report(fh, mr, meta, gv)
+	return fh.Close()
+}
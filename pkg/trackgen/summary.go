@@ -0,0 +1,112 @@
+package trackgen
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+import (
+	"types"
+)
+
+// The summary CSV columns (as the JSON keys), in (stable) output order
+type sfield struct {
+	name string
+	get  func(s *types.FlightSummary) interface{}
+}
+
+var summary_fields = []sfield{
+	{"log", func(s *types.FlightSummary) interface{} { return s.Log }},
+	{"index", func(s *types.FlightSummary) interface{} { return s.Index }},
+	{"date", func(s *types.FlightSummary) interface{} { return s.Date.UTC().Format(GPX_TIME) }},
+	{"craft", func(s *types.FlightSummary) interface{} { return s.Craft }},
+	{"firmware", func(s *types.FlightSummary) interface{} { return s.Firmware }},
+	{"fw-date", func(s *types.FlightSummary) interface{} { return s.FwDate }},
+	{"size", func(s *types.FlightSummary) interface{} { return s.Size }},
+	{"disarm", func(s *types.FlightSummary) interface{} { return s.Disarm }},
+	{"suspect", func(s *types.FlightSummary) interface{} { return s.Suspect }},
+	{"motors", func(s *types.FlightSummary) interface{} { return s.Motors }},
+	{"servos", func(s *types.FlightSummary) interface{} { return s.Servos }},
+	{"sensors", func(s *types.FlightSummary) interface{} { return strings.Join(s.Sensors, " ") }},
+	{"capabilities", func(s *types.FlightSummary) interface{} { return strings.Join(s.Capabilities, " ") }},
+	{"home-lat", func(s *types.FlightSummary) interface{} { return s.HomeLat }},
+	{"home-lon", func(s *types.FlightSummary) interface{} { return s.HomeLon }},
+	{"duration", func(s *types.FlightSummary) interface{} { return s.Duration }},
+	{"distance", func(s *types.FlightSummary) interface{} { return s.Distance }},
+	{"max-alt", func(s *types.FlightSummary) interface{} { return s.MaxAlt }},
+	{"max-alt-time", func(s *types.FlightSummary) interface{} { return s.MaxAltTime }},
+	{"max-range", func(s *types.FlightSummary) interface{} { return s.MaxRange }},
+	{"max-range-time", func(s *types.FlightSummary) interface{} { return s.MaxRangeTime }},
+	{"max-speed", func(s *types.FlightSummary) interface{} { return s.MaxSpeed }},
+	{"max-speed-time", func(s *types.FlightSummary) interface{} { return s.MaxSpeedTime }},
+	{"max-current", func(s *types.FlightSummary) interface{} { return s.MaxCurrent }},
+	{"max-current-time", func(s *types.FlightSummary) interface{} { return s.MaxCurrentTime }},
+	{"output", func(s *types.FlightSummary) interface{} { return s.Output }},
+	{"wind-speed", func(s *types.FlightSummary) interface{} { return opt_float(s.WindSpeed) }},
+	{"wind-dir", func(s *types.FlightSummary) interface{} { return opt_float(s.WindDir) }},
+	{"cells", func(s *types.FlightSummary) interface{} { return opt_int(s.Cells) }},
+	{"battery-ir", func(s *types.FlightSummary) interface{} { return opt_float(s.BatteryIR) }},
+	{"max-sag", func(s *types.FlightSummary) interface{} { return opt_float(s.MaxSag) }},
+	{"mean-sag", func(s *types.FlightSummary) interface{} { return opt_float(s.MeanSag) }},
+	{"start-volts", func(s *types.FlightSummary) interface{} { return opt_float(s.StartVolts) }},
+	{"end-volts", func(s *types.FlightSummary) interface{} { return opt_float(s.EndVolts) }},
+	{"end-soc", func(s *types.FlightSummary) interface{} { return opt_float(s.EndSoc) }},
+	{"capacity", func(s *types.FlightSummary) interface{} { return opt_float(s.Capacity) }},
+	{"remaining", func(s *types.FlightSummary) interface{} { return opt_float(s.Remaining) }},
+	{"predicted-range", func(s *types.FlightSummary) interface{} { return opt_float(s.PredRange) }},
+	{"wp-reached", func(s *types.FlightSummary) interface{} { return opt_int(s.WPReached) }},
+	{"wp-missed", func(s *types.FlightSummary) interface{} { return opt_int(s.WPMissed) }},
+	{"mean-xte", func(s *types.FlightSummary) interface{} { return opt_float(s.MeanXTE) }},
+	{"max-xte", func(s *types.FlightSummary) interface{} { return opt_float(s.MaxXTE) }},
+	{"geozone-violations", func(s *types.FlightSummary) interface{} { return opt_int(s.ZoneViolations) }},
+	{"min-agl", func(s *types.FlightSummary) interface{} { return opt_float(s.MinAgl) }},
+	{"min-agl-time", func(s *types.FlightSummary) interface{} { return opt_float(s.MinAglTime) }},
+	{"low-agl", func(s *types.FlightSummary) interface{} { return opt_int(s.LowAgl) }},
+}
+
+// Analysis results that weren't computed are empty
+func opt_float(v *float64) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func opt_int(v *int) interface{} {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+// Writes the summaries as a JSON array or as CSV (with a header line)
+func WriteSummaries(w io.Writer, format string, sums []types.FlightSummary) error {
+	switch format {
+	case "json":
+		if sums == nil {
+			sums = []types.FlightSummary{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sums)
+	case "csv":
+		cw := csv.NewWriter(w)
+		row := make([]string, len(summary_fields))
+		for j, f := range summary_fields {
+			row[j] = f.name
+		}
+		cw.Write(row)
+		for k := range sums {
+			for j, f := range summary_fields {
+				row[j] = field_string(f.get(&sums[k]))
+			}
+			cw.Write(row)
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown summary format \"%s\"", format)
+}
@@ -0,0 +1,39 @@
+package trackgen
+
+import (
+	"types"
+)
+
+// Non-KML track exporters (GPX, IGC, CSV, GeoJSON). Each takes a fully read
+// types.LogSegment.
+
+// Best available AMSL altitude for an item
+func amsl(h types.HomeRec, b types.LogItem) float64 {
+	if (h.Flags & types.HOME_ALT) == types.HOME_ALT {
+		return h.HomeAlt + b.Alt
+	}
+	return b.GAlt
+}
+
+func mode_text(b types.LogItem) string {
+	s := b.Fmtext
+	if (b.Status & types.Is_FAIL) == types.Is_FAIL {
+		s = s + " FAILSAFE"
+	}
+	return s
+}
+
+// Indices of the items where the flight mode (or failsafe state) changes,
+// including the first item.
+func mode_changes(items []types.LogItem) []int {
+	var idx []int
+	last := ""
+	for j, b := range items {
+		m := mode_text(b)
+		if j == 0 || m != last {
+			idx = append(idx, j)
+			last = m
+		}
+	}
+	return idx
+}
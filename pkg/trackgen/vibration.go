@@ -0,0 +1,96 @@
+package trackgen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+import (
+	"analysis"
+	"plot"
+	"types"
+)
+
+func write_vibration_report(w io.Writer, vr analysis.VibrationReport, meta types.FlightMeta, gv func() string) {
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Log", meta.LogName())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Flight", meta.Flight())
+	fmt.Fprintf(w, "%-9.9s : %s\n", "Generator", gv())
+	fmt.Fprintf(w, "%-9.9s : %d at %.0f Hz (%.1f s)\n", "Samples", vr.Samples, vr.Rate, vr.Duration)
+	fmt.Fprintf(w, "%-9.9s : %.2f Hz\n", "Bin width", vr.Freq[1])
+
+	fmt.Fprintf(w, "\n%-12s %10s %10s %10s\n", "Gyro", "RMS deg/s", "Peak Hz", "Peak deg/s")
+	for a, n := range analysis.GyroAxes {
+		fmt.Fprintf(w, "%-12s %10.2f %10.1f %10.2f\n", n, vr.GyroRMS[a], vr.GyroPeak[a].Freq, vr.GyroPeak[a].Amp)
+	}
+	fmt.Fprintf(w, "\n%-12s %10s %10s %10s\n", "Acc", "RMS g", "Peak Hz", "Peak g")
+	for a, n := range analysis.AccAxes {
+		fmt.Fprintf(w, "%-12s %10.3f %10.1f %10.3f\n", n, vr.AccRMS[a], vr.AccPeak[a].Freq, vr.AccPeak[a].Amp)
+	}
+
+	if !vr.HasThrottle {
+		return
+	}
+	fmt.Fprintf(w, "\n%-12s %8s", "Throttle", "Segments")
+	for _, n := range analysis.GyroAxes {
+		fmt.Fprintf(w, " %8s", "G-"+n)
+	}
+	for _, n := range analysis.AccAxes {
+		fmt.Fprintf(w, " %8s", "A-"+n)
+	}
+	fmt.Fprintln(w)
+	for _, b := range vr.Bands {
+		fmt.Fprintf(w, "%-12s %8d", fmt.Sprintf("%d-%d%%", b.Lo, b.Hi), b.Segments)
+		if b.Segments > 0 {
+			for a := range b.Gyro {
+				fmt.Fprintf(w, " %8.2f", b.Gyro[a])
+			}
+			for a := range b.Acc {
+				fmt.Fprintf(w, " %8.3f", b.Acc[a])
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func vibration_chart(title, units string, axes [3]string, vr analysis.VibrationReport, amps [3][]float64) *plot.Chart {
+	c := &plot.Chart{Title: title, XLabel: "Frequency (Hz)", YLabel: units}
+	for a, n := range axes {
+		c.Series = append(c.Series, plot.Series{Name: n, X: vr.Freq, Y: amps[a]})
+	}
+	return c
+}
+
+// Writes the vibration report (outfn) and the gyro and accelerometer
+// spectra plots, as SVG and PNG, named as the report with the extension
+// replaced by .gyro.svg etc.
+func GenerateVibration(outfn string, vr analysis.VibrationReport, meta types.FlightMeta, gv func() string) error {
+	fh, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	write_vibration_report(fh, vr, meta, gv)
+	if err = fh.Close(); err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(outfn, ".txt")
+	charts := map[string]*plot.Chart{
+		"gyro": vibration_chart(meta.LogName()+" gyro spectrum", "deg/s", analysis.GyroAxes, vr, vr.Gyro),
+		"acc":  vibration_chart(meta.LogName()+" accelerometer spectrum", "g", analysis.AccAxes, vr, vr.Acc),
+	}
+	for name, c := range charts {
+		if err = os.WriteFile(base+"."+name+".svg", c.SVG(), 0644); err != nil {
+			return err
+		}
+		data, err := c.PNG()
+		if err == nil {
+			err = os.WriteFile(base+"."+name+".png", data, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
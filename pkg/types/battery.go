@@ -0,0 +1,40 @@
+package types
+
+const (
+	CELL_MIN = 3.0  // V, discharged (loaded) LiPo cell
+	CELL_MAX = 4.22 // V, charged LiPo cell
+	// Time at the start of the log over which the resting voltage is taken
+	CELL_REST_TIME = 10 * 1000000 // us
+)
+
+// A point of the resting voltage curve
+type CellPoint struct {
+	Time  float64 `json:"time"`  // s from the start of the log
+	Used  float64 `json:"used"`  // mAh, where CAP_ENERGY
+	Volts float64 `json:"volts"` // resting V/cell
+}
+
+// Number of (LiPo) cells for a battery voltage, 0 if unknown
+func CellCount(vbat float64) int {
+	for i := 1; i < 10; i++ {
+		if vbat < CELL_MAX*float64(i) && vbat > CELL_MIN*float64(i) {
+			return i
+		}
+	}
+	return 0
+}
+
+// Number of cells of the pack, from the maximum (i.e. least loaded)
+// voltage at the start of the log.
+func PackCells(items []LogItem) int {
+	var vmax float64
+	for _, b := range items {
+		if b.Stamp-items[0].Stamp > CELL_REST_TIME && vmax > 0 {
+			break
+		}
+		if b.Volts > vmax {
+			vmax = b.Volts
+		}
+	}
+	return CellCount(vmax)
+}
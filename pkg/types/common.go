@@ -38,6 +38,9 @@ const (
 	CAP_SPEED
 	CAP_ALTITUDE
 	CAP_WPNO
+	CAP_AIRSPEED
+	CAP_LQ
+	CAP_AGL
 )
 
 const (
@@ -64,6 +67,8 @@ type LogItem struct {
 	Alt      float64
 	GAlt     float64
 	Spd      float64
+	Airspd   float64 // m/s, where CAP_AIRSPEED
+	Agl      float64 // m, terrain clearance, where CAP_AGL (NaN if no DEM data)
 	Amps     float64
 	Volts    float64
 	Hlat     float64
@@ -74,10 +79,6 @@ type LogItem struct {
 	Energy   float64
 	Whkm     float64
 	WhAcc    float64
-	Qval     float64 // scaled efficiency
-	Sval     float64 // scaled speed
-	Aval     float64 // scaled Altitude
-	Bval     float64 // scaled Battery
 	Fmtext   string
 	Utc      time.Time
 	Throttle int
@@ -101,6 +102,7 @@ type LogItem struct {
 	Numsat   uint8
 	Fmode    uint8
 	Rssi     uint8
+	LQ       uint8 // %, where CAP_LQ
 	Status   uint8
 	ActiveWP uint8
 	NavMode  byte
@@ -175,20 +177,47 @@ func (b *LogStats) Summary(t uint64) MapRec {
 
 type MapRec map[string]string
 
+// Where a rebased (-rebase) segment was relocated from, see geo.Frob
+type RebaseOrigin struct {
+	Lat, Lon, Alt float64
+	Valid         bool
+}
+
+// M is the formatted summary, S the summary statistics (after Summary(),
+// so distances are in metres), R the rebase origin
 type LogSegment struct {
 	L LogRec
 	H HomeRec
 	M MapRec
+	S LogStats
+	R RebaseOrigin
 }
 
+// Reader returns a wrapped ErrNoGPSFix if the segment has no usable
+// geospatial data. With a non-nil LogStream, the records are streamed
+// and only the summary (M) is returned in the LogSegment; see Stream().
 type FlightLog interface {
-	Reader(FlightMeta, chan interface{}) (LogSegment, bool)
+	Reader(FlightMeta, *LogStream) (LogSegment, error)
 	GetMetas() ([]FlightMeta, error)
 	GetDurations()
 	Dump()
 	LogType() byte
 }
 
+// Full rate gyro and accelerometer data (Blackbox only). Gyro is deg/s,
+// Acc is g, Throttle is %.
+type IMUSample struct {
+	Stamp    uint64
+	Gyro     [3]float64
+	Acc      [3]float64
+	Throttle int
+}
+
+// Implemented by readers that can provide full rate IMU data
+type IMUReader interface {
+	IMUSamples(FlightMeta) ([]IMUSample, error)
+}
+
 const (
 	Is_Valid = 1 << iota
 	Has_Craft
@@ -199,6 +228,13 @@ const (
 	Is_Suspect = (1 << 7)
 )
 
+// FlightMeta.Vehicle, where the log records the vehicle type
+const (
+	VEHICLE_UNKNOWN = iota
+	VEHICLE_FW
+	VEHICLE_MR
+)
+
 const (
 	Has_Acc = 1 << iota
 	Has_Baro
@@ -220,6 +256,7 @@ const (
 	AFlags_SPEED
 	AFlags_ALTITUDE
 	AFlags_BATTERY
+	AFlags_SAG
 )
 
 type FlightMeta struct {
@@ -237,6 +274,7 @@ type FlightMeta struct {
 	Flags    uint8
 	Motors   uint8
 	Servos   uint8
+	Vehicle  uint8
 	Sensors  uint16
 	Acc1G    uint16
 }
@@ -282,6 +320,21 @@ func (b *FlightMeta) ShowFirmware() (string, bool) {
 	}
 }
 
+// Multirotor from the motor and servo counts (BBL), else from the vehicle
+// type; ok is false if neither is known
+func (b *FlightMeta) Multirotor() (mr bool, ok bool) {
+	if b.Motors > 0 {
+		return b.Motors > 2 && b.Servos == 0, true
+	}
+	switch b.Vehicle {
+	case VEHICLE_MR:
+		return true, true
+	case VEHICLE_FW:
+		return false, true
+	}
+	return false, false
+}
+
 func (b *FlightMeta) Flight() string {
 	var sb strings.Builder
 	if b.Flags&Has_Craft != 0 {
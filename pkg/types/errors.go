@@ -0,0 +1,13 @@
+package types
+
+import (
+	"errors"
+)
+
+// Sentinel errors returned (wrapped) by the log readers; test with errors.Is
+var (
+	ErrUnknownFormat  = errors.New("unknown log format")
+	ErrNoGPSFix       = errors.New("no valid GPS fix")
+	ErrDecoderMissing = errors.New("log decoder not available")
+	ErrCorruptSegment = errors.New("corrupt log segment")
+)
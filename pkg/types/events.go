@@ -0,0 +1,175 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Flight events, detected from the log items. Not to be confused with
+// the streamed reader events (LogEvent).
+type EventType int
+
+const (
+	EVT_TAKEOFF EventType = iota
+	EVT_LANDING
+	EVT_MODE
+	EVT_FAILSAFE
+	EVT_FAILSAFE_END
+	EVT_GPS_LOST
+	EVT_GPS_OK
+	EVT_HWFAIL
+	EVT_HWFAIL_END
+	EVT_LOW_VOLTS
+	EVT_MAX_ALT
+	EVT_MAX_RANGE
+	EVT_MAX_SPEED
+)
+
+var evnames = [...]string{"takeoff", "landing", "mode", "failsafe", "failsafe-end",
+	"gps-lost", "gps-ok", "hwfail", "hwfail-end", "low-volts", "max-alt", "max-range", "max-speed"}
+
+func (e EventType) String() string {
+	if e < 0 || int(e) >= len(evnames) {
+		return "unknown"
+	}
+	return evnames[e]
+}
+
+func (e EventType) MarshalText() ([]byte, error) {
+	return []byte(e.String()), nil
+}
+
+type FlightEvent struct {
+	Type  EventType `json:"type"`
+	Index int       `json:"-"`
+	Utc   time.Time `json:"utc"`
+	Time  float64   `json:"time"` // seconds from the start of the log
+	Lat   float64   `json:"lat"`
+	Lon   float64   `json:"lon"`
+	Alt   float64   `json:"alt"`
+	Text  string    `json:"text"`
+}
+
+const (
+	EVT_AIR_ALT   = 3.0 // m, above home, considered airborne
+	EVT_LOWV_TIME = 5   // s, below the low voltage threshold
+)
+
+// Detects the flight events, in time order. lowcell is the per cell low
+// voltage threshold (0 disables low voltage detection).
+func DetectEvents(rec LogRec, lowcell float64) []FlightEvent {
+	var evs []FlightEvent
+	items := rec.Items
+	if len(items) == 0 {
+		return evs
+	}
+	add := func(t EventType, j int, text string) {
+		b := items[j]
+		evs = append(evs, FlightEvent{Type: t, Index: j, Utc: b.Utc,
+			Time: float64(b.Stamp-items[0].Stamp) / 1e6,
+			Lat:  b.Lat, Lon: b.Lon, Alt: b.Alt, Text: text})
+	}
+
+	// Takeoff is the first airborne point, landing the point after the
+	// last airborne point (where the log does not end in the air).
+	first, last := -1, -1
+	for j, b := range items {
+		if b.Alt > EVT_AIR_ALT {
+			if first == -1 {
+				first = j
+			}
+			last = j
+		}
+	}
+	if first > 0 {
+		add(EVT_TAKEOFF, first, "Takeoff")
+	}
+	if last != -1 && last < len(items)-1 {
+		add(EVT_LANDING, last+1, "Landing")
+	}
+
+	var vlow float64
+	if lowcell > 0 && rec.Cap&CAP_VOLTS != 0 {
+		vlow = lowcell * float64(PackCells(items))
+	}
+
+	hasfix, lost := false, false
+	lowv := -1
+	lowdone := false
+	imax := [3]int{}
+	for j, b := range items {
+		if j > 0 {
+			p := items[j-1]
+			if b.Fmode != p.Fmode {
+				add(EVT_MODE, j, fmt.Sprintf("Mode %s", b.Fmtext))
+			}
+			if (b.Status&Is_FAIL != 0) != (p.Status&Is_FAIL != 0) {
+				if b.Status&Is_FAIL != 0 {
+					add(EVT_FAILSAFE, j, "Failsafe")
+				} else {
+					add(EVT_FAILSAFE_END, j, "Failsafe end")
+				}
+			}
+			if b.HWfail != p.HWfail {
+				if b.HWfail {
+					add(EVT_HWFAIL, j, "Hardware failure")
+				} else {
+					add(EVT_HWFAIL_END, j, "Hardware OK")
+				}
+			}
+		}
+		if b.Fix > 1 {
+			if lost {
+				add(EVT_GPS_OK, j, fmt.Sprintf("GPS fix (%d sats)", b.Numsat))
+				lost = false
+			}
+			hasfix = true
+		} else if hasfix {
+			add(EVT_GPS_LOST, j, fmt.Sprintf("GPS fix lost (%d sats)", b.Numsat))
+			hasfix, lost = false, true
+		}
+
+		if vlow > 0 && !lowdone {
+			if b.Volts > 0 && b.Volts < vlow {
+				if lowv == -1 {
+					lowv = j
+				} else if b.Stamp-items[lowv].Stamp >= EVT_LOWV_TIME*1000000 {
+					add(EVT_LOW_VOLTS, lowv, fmt.Sprintf("Low voltage (%.1fV)", items[lowv].Volts))
+					lowdone = true
+				}
+			} else {
+				lowv = -1
+			}
+		}
+
+		if b.Alt > items[imax[0]].Alt {
+			imax[0] = j
+		}
+		if b.Vrange > items[imax[1]].Vrange {
+			imax[1] = j
+		}
+		if b.Spd > items[imax[2]].Spd {
+			imax[2] = j
+		}
+	}
+	if items[imax[0]].Alt > 0 {
+		add(EVT_MAX_ALT, imax[0], fmt.Sprintf("Max altitude (%.0fm)", items[imax[0]].Alt))
+	}
+	if items[imax[1]].Vrange > 0 {
+		add(EVT_MAX_RANGE, imax[1], fmt.Sprintf("Max range (%.0fm)", items[imax[1]].Vrange))
+	}
+	if items[imax[2]].Spd > 0 {
+		add(EVT_MAX_SPEED, imax[2], fmt.Sprintf("Max speed (%.1fm/s)", items[imax[2]].Spd))
+	}
+
+	sort.SliceStable(evs, func(i, j int) bool { return evs[i].Index < evs[j].Index })
+	return evs
+}
+
+// Short form of the event time, as LogStats.Show_time
+func (e *FlightEvent) ShowTime() string {
+	secs := int(math.Round(e.Time))
+	return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+}
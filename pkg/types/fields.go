@@ -0,0 +1,73 @@
+package types
+
+// The exported LogItem fields, in (stable) output order, as used by the
+// CSV / GeoJSON output and the attribute layers. Add new fields at the end
+// so existing consumers are not broken.
+type ItemField struct {
+	Name  string
+	Value func(b *LogItem) interface{}
+}
+
+var ItemFields = []ItemField{
+	{"stamp", func(b *LogItem) interface{} { return b.Stamp }},
+	{"utc", func(b *LogItem) interface{} { return b.Utc }},
+	{"lat", func(b *LogItem) interface{} { return b.Lat }},
+	{"lon", func(b *LogItem) interface{} { return b.Lon }},
+	{"alt", func(b *LogItem) interface{} { return b.Alt }},
+	{"galt", func(b *LogItem) interface{} { return b.GAlt }},
+	{"spd", func(b *LogItem) interface{} { return b.Spd }},
+	{"cse", func(b *LogItem) interface{} { return b.Cse }},
+	{"cog", func(b *LogItem) interface{} { return b.Cog }},
+	{"roll", func(b *LogItem) interface{} { return b.Roll }},
+	{"pitch", func(b *LogItem) interface{} { return b.Pitch }},
+	{"fix", func(b *LogItem) interface{} { return b.Fix }},
+	{"numsat", func(b *LogItem) interface{} { return b.Numsat }},
+	{"hdop", func(b *LogItem) interface{} { return float64(b.Hdop) / 100.0 }},
+	{"mode", func(b *LogItem) interface{} { return b.Fmtext }},
+	{"failsafe", func(b *LogItem) interface{} { return (b.Status & Is_FAIL) == Is_FAIL }},
+	{"armed", func(b *LogItem) interface{} { return (b.Status & Is_ARMED) == Is_ARMED }},
+	{"rssi", func(b *LogItem) interface{} { return b.Rssi }},
+	{"volts", func(b *LogItem) interface{} { return b.Volts }},
+	{"amps", func(b *LogItem) interface{} { return b.Amps }},
+	{"energy", func(b *LogItem) interface{} { return b.Energy }},
+	{"whacc", func(b *LogItem) interface{} { return b.WhAcc }},
+	{"effic", func(b *LogItem) interface{} { return b.Effic }},
+	{"whkm", func(b *LogItem) interface{} { return b.Whkm }},
+	{"range", func(b *LogItem) interface{} { return b.Vrange }},
+	{"bearing", func(b *LogItem) interface{} { return b.Bearing }},
+	{"distance", func(b *LogItem) interface{} { return b.Tdist }},
+	{"throttle", func(b *LogItem) interface{} { return b.Throttle }},
+	{"ail", func(b *LogItem) interface{} { return b.Ail }},
+	{"ele", func(b *LogItem) interface{} { return b.Ele }},
+	{"rud", func(b *LogItem) interface{} { return b.Rud }},
+	{"thr", func(b *LogItem) interface{} { return b.Thr }},
+	{"navmode", func(b *LogItem) interface{} { return b.NavMode }},
+	{"activewp", func(b *LogItem) interface{} { return b.ActiveWP }},
+	{"hwfail", func(b *LogItem) interface{} { return b.HWfail }},
+	{"airspd", func(b *LogItem) interface{} { return b.Airspd }},
+	{"lq", func(b *LogItem) interface{} { return b.LQ }},
+	{"agl", func(b *LogItem) interface{} { return b.Agl }},
+}
+
+// The field's value as a number; false for the text, time and flag fields
+func (f ItemField) Number(b *LogItem) (float64, bool) {
+	switch t := f.Value(b).(type) {
+	case float64:
+		return t, true
+	case uint64:
+		return float64(t), true
+	case uint32:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int16:
+		return float64(t), true
+	case uint16:
+		return float64(t), true
+	case uint8:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	}
+	return 0, false
+}
@@ -1,11 +1,10 @@
 package types
 
 import (
-	"strings"
 	"bufio"
+	"fmt"
+	"io"
 	"os"
-	"log"
-	"bytes"
 )
 
 const (
@@ -17,28 +16,30 @@ const (
 	IS_AP      = 5
 )
 
-func EvinceFileType(fn string) int {
-	res := IS_UNKNOWN
+// Bytes read from the start of a log for format detection
+const SIGSIZE = 128
+
+func read_signature(fn string) ([]byte, error) {
 	file, err := os.Open(fn)
 	if err != nil {
-		log.Fatalf("filetype: %+v\n", err)
+		return nil, fmt.Errorf("filetype: %w", err)
 	}
 	defer file.Close()
-	fh := bufio.NewReader(file)
-	sig, err := fh.Peek(128) //read a few bytes without consuming
-	if err == nil {
-		switch {
-		case strings.HasPrefix(string(sig), "H Product:Blackbox"):
-			res = IS_BBL
-		case strings.HasPrefix(string(sig), "Date,Time,"):
-			res = IS_OTX
-		case strings.Contains(string(sig), "|Connected to"):
-			res = IS_BLT
-		case strings.HasPrefix(string(sig), `{"type":`):
-			res = IS_MWP
-		case bytes.HasPrefix(sig, []byte{0xa3, 0x95, 0x80, 0x80, 0x59, 0x46, 0x4d, 0x54}):
-			res = IS_AP
-		}
+	sig := make([]byte, SIGSIZE)
+	n, err := io.ReadFull(bufio.NewReader(file), sig)
+	if err != nil && n == 0 {
+		return nil, nil
+	}
+	return sig[:n], nil
+}
+
+func EvinceFileType(fn string) (int, error) {
+	sig, err := read_signature(fn)
+	if err != nil {
+		return IS_UNKNOWN, err
+	}
+	if r := find_reader(sig); r != nil {
+		return r.Ftype, nil
 	}
-	return res
+	return IS_UNKNOWN, nil
 }
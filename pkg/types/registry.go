@@ -0,0 +1,59 @@
+package types
+
+import (
+	"fmt"
+	"sync"
+)
+
+// A log reader registration. Readers register themselves (typically from
+// init()) with a detector for the file signature and a constructor.
+type LogReader struct {
+	Ftype  int
+	Name   string
+	Detect func(sig []byte) bool
+	New    func(fn string) FlightLog
+}
+
+var (
+	rmutex  sync.RWMutex
+	readers []LogReader
+)
+
+func RegisterReader(r LogReader) {
+	rmutex.Lock()
+	defer rmutex.Unlock()
+	readers = append(readers, r)
+}
+
+func RegisteredReaders() []LogReader {
+	rmutex.RLock()
+	defer rmutex.RUnlock()
+	return append([]LogReader(nil), readers...)
+}
+
+func find_reader(sig []byte) *LogReader {
+	if len(sig) == 0 {
+		return nil
+	}
+	rmutex.RLock()
+	defer rmutex.RUnlock()
+	for j := range readers {
+		if readers[j].Detect(sig) {
+			return &readers[j]
+		}
+	}
+	return nil
+}
+
+// Returns a FlightLog for the file, or (wrapped) ErrUnknownFormat if no
+// registered reader recognises it.
+func NewFlightLog(fn string) (FlightLog, error) {
+	sig, err := read_signature(fn)
+	if err != nil {
+		return nil, err
+	}
+	if r := find_reader(sig); r != nil {
+		return r.New(fn), nil
+	}
+	return nil, fmt.Errorf("%s: %w", fn, ErrUnknownFormat)
+}
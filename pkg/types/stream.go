@@ -0,0 +1,74 @@
+package types
+
+import (
+	"context"
+)
+
+// Streamed reader events. A stream is a single EV_HOME, any number of
+// EV_ITEM and then either EV_END (carrying the summary) or EV_ERROR. The
+// channel is closed after the final event, or on cancellation.
+const (
+	EV_HOME = iota
+	EV_ITEM
+	EV_END
+	EV_ERROR
+)
+
+type LogEvent struct {
+	Type    int
+	Home    HomeRec
+	Item    LogItem
+	Summary MapRec
+	Err     error
+}
+
+// LogStream is the sink passed to FlightLog.Reader. A nil *LogStream
+// means "batch mode", where the reader returns the whole LogSegment;
+// otherwise the items are only streamed, and the returned segment has
+// just the summary (M and S).
+type LogStream struct {
+	ctx context.Context
+	ch  chan LogEvent
+}
+
+func (st *LogStream) send(ev LogEvent) bool {
+	select {
+	case st.ch <- ev:
+		return true
+	case <-st.ctx.Done():
+		return false
+	}
+}
+
+// Home and Item return false if the consumer has gone away, in which case
+// the reader should return st.Err().
+func (st *LogStream) Home(h HomeRec) bool {
+	return st.send(LogEvent{Type: EV_HOME, Home: h})
+}
+
+func (st *LogStream) Item(b LogItem) bool {
+	return st.send(LogEvent{Type: EV_ITEM, Item: b})
+}
+
+func (st *LogStream) Err() error {
+	return st.ctx.Err()
+}
+
+// Stream runs the reader for meta in a goroutine and returns its events.
+// Cancelling ctx stops the reader; the goroutine does not outlive it.
+func Stream(ctx context.Context, lfr FlightLog, meta FlightMeta) <-chan LogEvent {
+	st := &LogStream{ctx: ctx, ch: make(chan LogEvent)}
+	go func() {
+		defer close(st.ch)
+		ls, err := lfr.Reader(meta, st)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			st.send(LogEvent{Type: EV_ERROR, Err: err})
+		} else {
+			st.send(LogEvent{Type: EV_END, Summary: ls.M})
+		}
+	}()
+	return st.ch
+}
@@ -0,0 +1,115 @@
+package types
+
+import (
+	"time"
+)
+
+// Machine readable flight summary. Distances are metres, times are
+// seconds from the start of the log. Analysis results are nil where the
+// analysis was not done (or not possible).
+type FlightSummary struct {
+	Log            string        `json:"log"`
+	Index          int           `json:"index"`
+	Date           time.Time     `json:"date"`
+	Craft          string        `json:"craft,omitempty"`
+	Firmware       string        `json:"firmware,omitempty"`
+	FwDate         string        `json:"fw-date,omitempty"`
+	Size           int64         `json:"size,omitempty"`
+	Disarm         string        `json:"disarm,omitempty"`
+	Suspect        bool          `json:"suspect"`
+	Motors         int           `json:"motors"`
+	Servos         int           `json:"servos"`
+	Sensors        []string      `json:"sensors"`
+	Capabilities   []string      `json:"capabilities"`
+	HomeLat        float64       `json:"home-lat"`
+	HomeLon        float64       `json:"home-lon"`
+	Duration       float64       `json:"duration"`
+	Distance       float64       `json:"distance"`
+	MaxAlt         float64       `json:"max-alt"`
+	MaxAltTime     float64       `json:"max-alt-time"`
+	MaxRange       float64       `json:"max-range"`
+	MaxRangeTime   float64       `json:"max-range-time"`
+	MaxSpeed       float64       `json:"max-speed"`
+	MaxSpeedTime   float64       `json:"max-speed-time"`
+	MaxCurrent     float64       `json:"max-current"`
+	MaxCurrentTime float64       `json:"max-current-time"`
+	Output         string        `json:"output,omitempty"`
+	WindSpeed      *float64      `json:"wind-speed"`
+	WindDir        *float64      `json:"wind-dir"`
+	Cells          *int          `json:"cells"`
+	BatteryIR      *float64      `json:"battery-ir"`
+	MaxSag         *float64      `json:"max-sag"`
+	MeanSag        *float64      `json:"mean-sag"`
+	StartVolts     *float64      `json:"start-volts"`
+	EndVolts       *float64      `json:"end-volts"`
+	EndSoc         *float64      `json:"end-soc"`
+	Capacity       *float64      `json:"capacity"`
+	Remaining      *float64      `json:"remaining"`
+	BatteryCurve   []CellPoint   `json:"battery-curve,omitempty"`
+	PredRange      *float64      `json:"predicted-range"`
+	WPReached      *int          `json:"wp-reached"`
+	WPMissed       *int          `json:"wp-missed"`
+	MeanXTE        *float64      `json:"mean-xte"`
+	MaxXTE         *float64      `json:"max-xte"`
+	ZoneViolations *int          `json:"geozone-violations"`
+	MinAgl         *float64      `json:"min-agl"`
+	MinAglTime     *float64      `json:"min-agl-time"`
+	LowAgl         *int          `json:"low-agl"`
+	Events         []FlightEvent `json:"events"`
+}
+
+var sensor_names = []string{"acc", "baro", "mag", "gps", "sonar", "opflow", "pitot"}
+
+var cap_names = []string{"amps", "volts", "energy", "rssi", "energyc", "speed", "altitude", "wpno", "airspeed", "lq", "agl"}
+
+func flag_names(flags uint16, names []string) []string {
+	l := []string{}
+	for j, n := range names {
+		if flags&(1<<j) != 0 {
+			l = append(l, n)
+		}
+	}
+	return l
+}
+
+func NewFlightSummary(meta FlightMeta, ls LogSegment) FlightSummary {
+	secs := func(t uint64) float64 { return float64(t) / 1e6 }
+	s := FlightSummary{
+		Log:            meta.Logname,
+		Index:          meta.Index,
+		Date:           meta.Date,
+		Suspect:        meta.Flags&Is_Suspect != 0,
+		Motors:         int(meta.Motors),
+		Servos:         int(meta.Servos),
+		Sensors:        flag_names(meta.Sensors, sensor_names),
+		Capabilities:   flag_names(ls.L.Cap, cap_names),
+		Duration:       secs(ls.S.Duration),
+		Distance:       ls.S.Distance,
+		MaxAlt:         ls.S.Max_alt,
+		MaxAltTime:     secs(ls.S.Max_alt_time),
+		MaxRange:       ls.S.Max_range,
+		MaxRangeTime:   secs(ls.S.Max_range_time),
+		MaxSpeed:       ls.S.Max_speed,
+		MaxSpeedTime:   secs(ls.S.Max_speed_time),
+		MaxCurrent:     ls.S.Max_current,
+		MaxCurrentTime: secs(ls.S.Max_current_time),
+	}
+	if meta.Flags&Has_Craft != 0 {
+		s.Craft = meta.Craft
+	}
+	if meta.Flags&Has_Firmware != 0 {
+		s.Firmware = meta.Firmware
+		s.FwDate = meta.Fwdate
+	}
+	if meta.Flags&Has_Size != 0 {
+		s.Size = meta.Size
+	}
+	if d, ok := meta.ShowDisarm(); ok {
+		s.Disarm = d
+	}
+	if ls.H.Flags != 0 {
+		s.HomeLat = ls.H.HomeLat
+		s.HomeLon = ls.H.HomeLon
+	}
+	return s
+}